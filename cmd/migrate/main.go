@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/pkg/store/migrations"
+)
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	envs, err := internal.LoadEnvs(".")
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+
+	mongoClient, err := internal.Connect(ctx, envs)
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+
+	str := store.NewMongoClient(mongoClient)
+	defer func() {
+		if err := str.Disconnect(ctx); err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	currency := money.DefaultCurrency
+	if envs.DEFAULT_CURRENCY != "" {
+		currency = strings.ToUpper(envs.DEFAULT_CURRENCY)
+	}
+
+	err = migrations.MoneyMinorUnits(ctx, str, currency)
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -15,6 +16,8 @@ type CoffeeShopBucket interface {
 	UploadImage(ctx context.Context, objectKey, bucketName, extension string, image []byte) error
 	UploadMultipleImages(ctx context.Context, payload []*types.PayloadUploadImage, bucket string) error
 	DeleteImage(ctx context.Context, objectKey string, bucket string) error
+	UploadObject(ctx context.Context, objectKey, bucketName, contentType string, data []byte) error
+	DownloadObject(ctx context.Context, objectKey, bucketName string) ([]byte, error)
 }
 
 type CoffeeShopS3Client struct {
@@ -77,3 +80,33 @@ func (csb *CoffeeShopS3Client) DeleteImage(ctx context.Context, objectKey string
 
 	return nil
 }
+
+// UploadObject stores a private object, unlike UploadImage whose images are
+// served publicly. Private objects are read back through DownloadObject.
+func (csb *CoffeeShopS3Client) UploadObject(ctx context.Context, objectKey, bucketName, contentType string, data []byte) error {
+	_, err := csb.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(objectKey),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+
+	if err != nil {
+		return fmt.Errorf("error occured while uploading object %s to AWS s3 bucket %w", objectKey, err)
+	}
+
+	return nil
+}
+
+func (csb *CoffeeShopS3Client) DownloadObject(ctx context.Context, objectKey, bucketName string) ([]byte, error) {
+	output, err := csb.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error occured while downloading object %s from AWS s3 bucket %w", objectKey, err)
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrGiftCardNotFound = errors.New("gift card not found")
+	ErrGiftCardUnusable = errors.New("gift card cannot be used")
+)
+
+// giftCardAlphabet leaves out characters that are easily misread: 0/O, 1/I.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const giftCardCodeLength = 16
+
+// NewGiftCardCode returns a random code like "ABCD-EFGH-JKLM-NPQR".
+func NewGiftCardCode() (string, error) {
+	max := big.NewInt(int64(len(giftCardAlphabet)))
+	code := make([]byte, giftCardCodeLength)
+	for idx := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[idx] = giftCardAlphabet[n.Int64()]
+	}
+	return NormalizeGiftCardCode(string(code)), nil
+}
+
+// NormalizeGiftCardCode accepts codes typed in any case, with or without
+// separators, and returns them in the stored form.
+func NormalizeGiftCardCode(code string) string {
+	var chars []rune
+	for _, char := range strings.ToUpper(code) {
+		if char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' {
+			chars = append(chars, char)
+		}
+	}
+
+	var groups []string
+	for start := 0; start < len(chars); start += 4 {
+		end := start + 4
+		if end > len(chars) {
+			end = len(chars)
+		}
+		groups = append(groups, string(chars[start:end]))
+	}
+	return strings.Join(groups, "-")
+}
+
+// MaskGiftCardCode hides all but the last group of a code.
+func MaskGiftCardCode(code string) string {
+	groups := strings.Split(code, "-")
+	for idx := 0; idx < len(groups)-1; idx++ {
+		groups[idx] = strings.Repeat("*", len(groups[idx]))
+	}
+	return strings.Join(groups, "-")
+}
+
+// FindGiftCard loads the card for a code a customer entered.
+func FindGiftCard(ctx context.Context, collection *mongo.Collection, code string) (store.GiftCard, error) {
+	var card store.GiftCard
+	err := collection.FindOne(ctx, bson.D{{Key: "code", Value: NormalizeGiftCardCode(code)}}).Decode(&card)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.GiftCard{}, fmt.Errorf("%w: %s", ErrGiftCardNotFound, MaskGiftCardCode(NormalizeGiftCardCode(code)))
+		}
+		return store.GiftCard{}, err
+	}
+	return card, nil
+}
+
+// IssueGiftCard stores card and logs its opening balance. It must run inside
+// a transaction.
+func IssueGiftCard(ctx context.Context, str store.Mongo, card store.GiftCard) error {
+	_, err := str.Collection(ctx, "coffeeshop", "giftcards").InsertOne(ctx, card)
+	if err != nil {
+		return err
+	}
+	return logGiftCardTransaction(ctx, str, card, types.GIFT_CARD_ISSUE, card.Balance, primitive.NilObjectID)
+}
+
+// RedeemGiftCards draws the order's amount from the cards in the order
+// given, each for as much of what is still due as it holds, and returns what
+// every card paid. It runs in the order's transaction: a card spent by
+// another order in the meantime fails the conditional debit, and the cards
+// of an order that fails are never charged.
+func RedeemGiftCards(ctx context.Context, str store.Mongo, codes []string, order store.Order, now time.Time) ([]store.AppliedGiftCard, money.Money, error) {
+	cardColl := str.Collection(ctx, "coffeeshop", "giftcards")
+
+	due := order.TotalAmount
+	paid := money.Zero(due.Currency)
+	var applied []store.AppliedGiftCard
+	for _, code := range codes {
+		card, err := FindGiftCard(ctx, cardColl, code)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+
+		masked := MaskGiftCardCode(card.Code)
+		switch {
+		case !card.ExpiresAt.IsZero() && !now.Before(card.ExpiresAt):
+			return nil, money.Money{}, fmt.Errorf("%w: %s has expired", ErrGiftCardUnusable, masked)
+		case !card.Balance.SameCurrency(due):
+			return nil, money.Money{}, fmt.Errorf("%w: %s is in %s", ErrGiftCardUnusable, masked, card.Balance.Currency)
+		case card.Balance.Amount <= 0:
+			return nil, money.Money{}, fmt.Errorf("%w: %s is empty", ErrGiftCardUnusable, masked)
+		}
+
+		remaining := due.Sub(paid)
+		if remaining.Amount <= 0 {
+			return nil, money.Money{}, fmt.Errorf("%w: the order is already covered before %s", ErrGiftCardUnusable, masked)
+		}
+
+		amount := card.Balance.Min(remaining)
+		err = cardColl.FindOneAndUpdate(ctx, bson.D{
+			{Key: "_id", Value: card.Id},
+			{Key: "balance.amount", Value: bson.D{{Key: "$gte", Value: amount.Amount}}},
+		}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "balance.amount", Value: -amount.Amount}}},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, money.Money{}, fmt.Errorf("%w: %s no longer holds %s", ErrGiftCardUnusable, masked, amount)
+			}
+			return nil, money.Money{}, err
+		}
+
+		err = logGiftCardTransaction(ctx, str, card, types.GIFT_CARD_REDEEM, money.Zero(amount.Currency).Sub(amount), order.Id)
+		if err != nil {
+			return nil, money.Money{}, err
+		}
+
+		paid = paid.Add(amount)
+		applied = append(applied, store.AppliedGiftCard{Card: card.Id, Code: masked, Amount: amount, Refunded: money.Zero(amount.Currency)})
+	}
+	return applied, paid, nil
+}
+
+// ReturnGiftCards puts back what the cards paid for a cancelled or rejected
+// order, less what refunds already gave back to them. For a paid order the
+// amount counts as refunded, so refunds that follow only give back the rest.
+func ReturnGiftCards(ctx context.Context, str store.Mongo, order store.Order) error {
+	ordColl := str.Collection(ctx, "coffeeshop", "orders")
+
+	var cards []store.AppliedGiftCard
+	set := bson.D{{Key: "updated_at", Value: time.Now()}}
+	returned := money.Zero(order.GiftCardTotal.Currency)
+	for idx, applied := range order.GiftCards {
+		amount := applied.Amount.Sub(applied.Refunded)
+		if amount.Amount <= 0 {
+			continue
+		}
+		cards = append(cards, store.AppliedGiftCard{Card: applied.Card, Code: applied.Code, Amount: amount})
+		set = append(set, bson.E{Key: fmt.Sprintf("gift_cards.%d.refunded", idx), Value: applied.Amount})
+		returned = returned.Add(amount)
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+
+	err := CreditGiftCards(ctx, str, cards, types.GIFT_CARD_RETURN, order.Id)
+	if err != nil {
+		return err
+	}
+
+	if order.PaymentStatus != types.PAID.String() {
+		_, err = ordColl.UpdateOne(ctx, bson.D{{Key: "_id", Value: order.Id}}, bson.D{{Key: "$set", Value: set}})
+		return err
+	}
+
+	if order.RefundedTotal.Add(returned).Amount >= order.PaidTotal.Amount {
+		set = append(set, bson.E{Key: "payment_status", Value: types.REFUNDED.String()})
+	}
+	_, err = ordColl.UpdateOne(ctx, bson.D{{Key: "_id", Value: order.Id}}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "refunded_total.amount", Value: returned.Amount}}},
+		{Key: "$set", Value: set},
+	})
+	return err
+}
+
+// CreditGiftCards adds each card's amount back onto its balance.
+func CreditGiftCards(ctx context.Context, str store.Mongo, cards []store.AppliedGiftCard, kind types.GiftCardKind, order primitive.ObjectID) error {
+	cardColl := str.Collection(ctx, "coffeeshop", "giftcards")
+
+	for _, applied := range cards {
+		var card store.GiftCard
+		err := cardColl.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: applied.Card}}, bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "balance.amount", Value: applied.Amount.Amount}}},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&card)
+		if err != nil {
+			return err
+		}
+
+		err = logGiftCardTransaction(ctx, str, card, kind, applied.Amount, order)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GiftCardsRefunded sums what was given back to the cards of an order.
+func GiftCardsRefunded(order store.Order) money.Money {
+	refunded := money.Zero(order.GiftCardTotal.Currency)
+	for _, applied := range order.GiftCards {
+		refunded = refunded.Add(applied.Refunded)
+	}
+	return refunded
+}
+
+func logGiftCardTransaction(ctx context.Context, str store.Mongo, card store.GiftCard, kind types.GiftCardKind, amount money.Money, order primitive.ObjectID) error {
+	_, err := str.Collection(ctx, "coffeeshop", "giftcard_transactions").InsertOne(ctx, store.GiftCardTransaction{
+		Id:        primitive.NewObjectID(),
+		Card:      card.Id,
+		Kind:      string(kind),
+		Amount:    amount,
+		Balance:   card.Balance,
+		Order:     order,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type InsufficientStockError struct {
+	Items []types.StockShortageParams
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %d product(s)", len(e.Items))
+}
+
+// requestedQuantities sums the quantity asked for each product so a product
+// listed on several order lines is reserved once.
+func requestedQuantities(items []store.OrderItem) ([]primitive.ObjectID, map[primitive.ObjectID]int64) {
+	quantities := make(map[primitive.ObjectID]int64)
+	var ids []primitive.ObjectID
+	for _, item := range items {
+		if _, ok := quantities[item.Product]; !ok {
+			ids = append(ids, item.Product)
+		}
+		quantities[item.Product] += int64(item.Quantity)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+	return ids, quantities
+}
+
+// ReserveStock decrements the stock of every tracked product in items. It
+// should run inside a transaction: when any product is short the returned
+// *InsufficientStockError lists all of them and the caller aborts, rolling
+// back the decrements that did succeed. Products that do not track stock
+// are made to order and are never reserved.
+func ReserveStock(ctx context.Context, collection *mongo.Collection, items []store.OrderItem, products map[primitive.ObjectID]store.Item) error {
+	ids, quantities := requestedQuantities(items)
+
+	var shortages []types.StockShortageParams
+	for _, id := range ids {
+		product := products[id]
+		if !product.TrackStock {
+			continue
+		}
+
+		quantity := quantities[id]
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "track_stock", Value: true}, {Key: "stock", Value: bson.D{{Key: "$gte", Value: quantity}}}}
+		update := bson.D{{Key: "$inc", Value: bson.D{{Key: "stock", Value: -quantity}}}}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+
+		if result.ModifiedCount == 0 {
+			shortages = append(shortages, types.StockShortageParams{
+				Product:   id.Hex(),
+				Name:      product.Name,
+				Requested: quantity,
+				Available: product.Stock,
+			})
+		}
+	}
+
+	if len(shortages) > 0 {
+		return &InsufficientStockError{Items: shortages}
+	}
+	return nil
+}
+
+// RestoreStock returns the quantities in items to the products that still
+// track stock.
+func RestoreStock(ctx context.Context, collection *mongo.Collection, items []store.OrderItem) error {
+	ids, quantities := requestedQuantities(items)
+
+	for _, id := range ids {
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "track_stock", Value: true}}
+		update := bson.D{{Key: "$inc", Value: bson.D{{Key: "stock", Value: quantities[id]}}}}
+		_, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal/pdf"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ParseTaxRate converts a percentage such as "16" or "7.5" into basis
+// points. An empty value means prices carry no tax.
+func ParseTaxRate(value string) (uint32, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok || rate.Sign() < 0 {
+		return 0, fmt.Errorf("invalid tax rate %q", value)
+	}
+
+	rate.Mul(rate, big.NewRat(100, 1))
+	if !rate.IsInt() || !rate.Num().IsUint64() || rate.Num().Uint64() > 10000 {
+		return 0, fmt.Errorf("invalid tax rate %q", value)
+	}
+	return uint32(rate.Num().Uint64()), nil
+}
+
+// FormatTaxRate renders basis points as a percentage, e.g. 750 as "7.5".
+func FormatTaxRate(rate uint32) string {
+	percent := fmt.Sprintf("%d.%02d", rate/100, rate%100)
+	return strings.TrimSuffix(strings.TrimRight(percent, "0"), ".")
+}
+
+// IssueInvoice numbers and stores the invoice for a completed order and links
+// it from the order. It must run inside the transaction that completes the
+// order: the per-year counter is only advanced when the invoice is written
+// too, which keeps the numbering free of gaps. The invoice carries the taxes
+// worked out when the order was placed; taxRate only applies to older orders
+// that have none.
+func IssueInvoice(ctx context.Context, str store.Mongo, order store.Order, taxRate uint32) (store.Invoice, error) {
+	counters := str.Collection(ctx, "coffeeshop", "counters")
+	invoices := str.Collection(ctx, "coffeeshop", "invoices")
+	ordColl := str.Collection(ctx, "coffeeshop", "orders")
+	prodColl := str.Collection(ctx, "coffeeshop", "products")
+	userColl := str.Collection(ctx, "coffeeshop", "users")
+
+	issuedAt := time.Now().UTC()
+	year := issuedAt.Year()
+
+	var counter struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := counters.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: fmt.Sprintf("invoice-%d", year)}}, bson.D{{Key: "$inc", Value: bson.D{{Key: "sequence", Value: 1}}}}, opts).Decode(&counter)
+	if err != nil {
+		return store.Invoice{}, err
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(order.Items))
+	for _, item := range order.Items {
+		productIDs = append(productIDs, item.Product)
+	}
+	cur, err := prodColl.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: productIDs}}}})
+	if err != nil {
+		return store.Invoice{}, err
+	}
+	defer cur.Close(ctx)
+
+	var products []store.Item
+	if err := cur.All(ctx, &products); err != nil {
+		return store.Invoice{}, err
+	}
+	names := make(map[primitive.ObjectID]string)
+	for _, product := range products {
+		names[product.Id] = product.Name
+	}
+
+	var customer store.User
+	err = userColl.FindOne(ctx, bson.D{{Key: "_id", Value: order.Owner}}).Decode(&customer)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return store.Invoice{}, err
+	}
+
+	currency := order.TotalAmount.Currency
+	subtotal := money.Zero(currency)
+	discount := money.Zero(currency)
+	lines := make([]store.InvoiceLine, 0, len(order.Items))
+	for _, item := range order.Items {
+		name, ok := names[item.Product]
+		if !ok {
+			// the product was deleted after the order was placed
+			name = item.Product.Hex()
+		}
+
+		lines = append(lines, store.InvoiceLine{
+			Product:   item.Product,
+			Name:      name,
+			Quantity:  item.Quantity,
+			Modifiers: item.Modifiers,
+			UnitPrice: item.UnitPrice,
+			Amount:    item.Amount,
+			Discount:  item.Discount,
+			Total:     item.Amount.Sub(item.Discount),
+		})
+		subtotal = subtotal.Add(item.Amount)
+		discount = discount.Add(item.Discount)
+	}
+
+	total := order.TotalAmount
+	taxTotal := money.Zero(currency)
+	taxes := []store.TaxLine{}
+	if order.TaxTotal.Currency != "" {
+		if order.Taxes != nil {
+			taxes = order.Taxes
+		}
+		taxTotal = order.TaxTotal
+	} else if taxRate > 0 {
+		// older orders carry no taxes; their prices include tax, so it is
+		// extracted from the total rather than added on top of it
+		tax := total.Ratio(int64(taxRate), 10000+int64(taxRate))
+		taxes = append(taxes, store.TaxLine{
+			Name:      "VAT",
+			Rate:      taxRate,
+			Inclusive: true,
+			Taxable:   total.Sub(tax),
+			Amount:    tax,
+		})
+		taxTotal = tax
+	}
+
+	invoice := store.Invoice{
+		Id:            primitive.NewObjectID(),
+		Number:        fmt.Sprintf("%d-%06d", year, counter.Sequence),
+		Year:          year,
+		Sequence:      counter.Sequence,
+		Order:         order.Id,
+		Customer:      order.Owner,
+		CustomerName:  customer.UserName,
+		CustomerEmail: customer.Email,
+		Lines:         lines,
+		Subtotal:      subtotal,
+		Discount:      discount,
+		Taxes:         taxes,
+		TaxTotal:      taxTotal,
+		Total:         total,
+		IssuedAt:      issuedAt,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	_, err = invoices.InsertOne(ctx, invoice)
+	if err != nil {
+		return store.Invoice{}, err
+	}
+
+	_, err = ordColl.UpdateByID(ctx, order.Id, bson.D{{Key: "$set", Value: bson.D{{Key: "invoice", Value: invoice.Id}}}})
+	if err != nil {
+		return store.Invoice{}, err
+	}
+	return invoice, nil
+}
+
+func InvoiceObjectKey(invoice store.Invoice) string {
+	return fmt.Sprintf("invoices/%d/%s.pdf", invoice.Year, invoice.Number)
+}
+
+// InvoicePDF renders invoice as an A4 PDF, continuing the line items onto
+// further pages when they do not fit on one.
+func InvoicePDF(invoice store.Invoice) []byte {
+	const (
+		left   = 50.0
+		right  = pdf.PageWidth - 50
+		bottom = 80.0
+	)
+
+	doc := pdf.New()
+	y := pdf.PageHeight - 60
+
+	doc.Text(left, y, pdf.Bold, 22, "INVOICE")
+	doc.TextRight(right, y, pdf.Bold, 12, invoice.Number)
+	y -= 30
+	doc.Text(left, y, pdf.Regular, 10, fmt.Sprintf("Issued: %s", invoice.IssuedAt.Format("02 Jan 2006")))
+	doc.TextRight(right, y, pdf.Regular, 10, fmt.Sprintf("Order: %s", invoice.Order.Hex()))
+	y -= 15
+	doc.Text(left, y, pdf.Regular, 10, fmt.Sprintf("Billed to: %s <%s>", invoice.CustomerName, invoice.CustomerEmail))
+	y -= 35
+
+	header := func() {
+		doc.Text(left, y, pdf.Bold, 10, "Item")
+		doc.TextRight(330, y, pdf.Bold, 10, "Qty")
+		doc.TextRight(410, y, pdf.Bold, 10, "Unit price")
+		doc.TextRight(480, y, pdf.Bold, 10, "Discount")
+		doc.TextRight(right, y, pdf.Bold, 10, "Total")
+		y -= 6
+		doc.Line(left, y, right, y)
+		y -= 16
+	}
+	header()
+
+	for _, line := range invoice.Lines {
+		if y < bottom {
+			doc.AddPage()
+			y = pdf.PageHeight - 60
+			header()
+		}
+
+		doc.Text(left, y, pdf.Regular, 10, line.Name)
+		doc.TextRight(330, y, pdf.Regular, 10, fmt.Sprintf("%d", line.Quantity))
+		doc.TextRight(410, y, pdf.Regular, 10, line.UnitPrice.Decimal())
+		doc.TextRight(480, y, pdf.Regular, 10, line.Discount.Decimal())
+		doc.TextRight(right, y, pdf.Regular, 10, line.Total.Decimal())
+		y -= 14
+
+		for _, modifier := range line.Modifiers {
+			doc.Text(left+12, y, pdf.Regular, 8, fmt.Sprintf("%s: %s (+%s)", modifier.Group, modifier.Option, modifier.PriceDelta.Decimal()))
+			y -= 12
+		}
+	}
+
+	if y < bottom+40+float64(len(invoice.Taxes))*14 {
+		doc.AddPage()
+		y = pdf.PageHeight - 60
+	}
+	y -= 4
+	doc.Line(left, y, right, y)
+	y -= 18
+
+	type totalRow struct {
+		label string
+		value money.Money
+		font  pdf.Font
+	}
+	totals := []totalRow{
+		{"Subtotal", invoice.Subtotal, pdf.Regular},
+		{"Discount", invoice.Discount, pdf.Regular},
+	}
+	for _, tax := range invoice.Taxes {
+		label := fmt.Sprintf("%s %s%%", tax.Name, FormatTaxRate(tax.Rate))
+		if tax.Inclusive {
+			label += " (included)"
+		}
+		totals = append(totals, totalRow{label, tax.Amount, pdf.Regular})
+	}
+	totals = append(totals, totalRow{fmt.Sprintf("Total (%s)", invoice.Total.Currency), invoice.Total, pdf.Bold})
+
+	for _, total := range totals {
+		doc.Text(360, y, total.font, 10, total.label)
+		doc.TextRight(right, y, total.font, 10, total.value.Decimal())
+		y -= 14
+	}
+
+	return doc.Bytes()
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/silaselisha/coffee-api/pkg/events"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNotInKitchen = errors.New("order is not in the kitchen queue")
+
+// kitchenFlow is the order a ticket moves through the kitchen in. Bumping a
+// completed ticket takes it off the board.
+var kitchenFlow = []types.OrderStatus{
+	types.ORDER_PENDING,
+	types.ORDER_ACCEPTED,
+	types.ORDER_PREPARING,
+	types.ORDER_READY,
+	types.ORDER_COMPLETED,
+}
+
+// kitchenOpen are the statuses of tickets shown on the board.
+var kitchenOpen = bson.A{
+	string(types.ORDER_PENDING),
+	string(types.ORDER_ACCEPTED),
+	string(types.ORDER_PREPARING),
+	string(types.ORDER_READY),
+}
+
+// BumpStatus returns the status a ticket moves forward to.
+func BumpStatus(order store.Order) (types.OrderStatus, error) {
+	if order.QueuedAt.IsZero() {
+		return "", ErrNotInKitchen
+	}
+	for idx, status := range kitchenFlow[:len(kitchenFlow)-1] {
+		if string(status) == order.Status {
+			return kitchenFlow[idx+1], nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s orders cannot be bumped", ErrInvalidOrderTransition, order.Status)
+}
+
+// RecallStatus returns the status a ticket moves back to. Only tickets being
+// prepared or ready can be recalled; accepting and completing an order have
+// effects outside the kitchen.
+func RecallStatus(order store.Order) (types.OrderStatus, error) {
+	if order.QueuedAt.IsZero() {
+		return "", ErrNotInKitchen
+	}
+	switch types.OrderStatus(order.Status) {
+	case types.ORDER_PREPARING:
+		return types.ORDER_ACCEPTED, nil
+	case types.ORDER_READY:
+		return types.ORDER_PREPARING, nil
+	}
+	return "", fmt.Errorf("%w: %s orders cannot be recalled", ErrInvalidOrderTransition, order.Status)
+}
+
+// KitchenOpen reports whether a ticket is still on the board.
+func KitchenOpen(order store.Order) bool {
+	if order.QueuedAt.IsZero() {
+		return false
+	}
+	for _, status := range kitchenOpen {
+		if status == order.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// KitchenQueueFilter matches the open tickets, of one location unless it is
+// zero.
+func KitchenQueueFilter(location primitive.ObjectID) bson.D {
+	filter := bson.D{
+		{Key: "queued_at", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: kitchenOpen}}},
+	}
+	if !location.IsZero() {
+		filter = append(filter, bson.E{Key: "location", Value: location})
+	}
+	return filter
+}
+
+// KitchenTicket lays an order out for the kitchen display, naming its items
+// from products.
+func KitchenTicket(order store.Order, products map[primitive.ObjectID]store.Item) types.KitchenTicketResParams {
+	ticket := types.KitchenTicketResParams{
+		Order:    order.Id.Hex(),
+		Status:   order.Status,
+		QueuedAt: order.QueuedAt,
+		Items:    make([]types.KitchenTicketItemResParams, 0, len(order.Items)),
+	}
+	if !order.Location.IsZero() {
+		ticket.Location = order.Location.Hex()
+	}
+	if !order.PickupAt.IsZero() {
+		pickupAt := order.PickupAt
+		ticket.PickupAt = &pickupAt
+	}
+
+	for _, item := range order.Items {
+		// refunded units are not made
+		quantity := item.Quantity - item.RefundedQuantity
+		if quantity == 0 {
+			continue
+		}
+
+		line := types.KitchenTicketItemResParams{
+			Product:  item.Product.Hex(),
+			Name:     products[item.Product].Name,
+			Quantity: quantity,
+		}
+		for _, modifier := range item.Modifiers {
+			line.Modifiers = append(line.Modifiers, fmt.Sprintf("%s: %s", modifier.Group, modifier.Option))
+		}
+		ticket.Items = append(ticket.Items, line)
+	}
+	return ticket
+}
+
+// AnnounceKitchenTicket tells the kitchen feeds that a queued order was added
+// or changed.
+func AnnounceKitchenTicket(ctx context.Context, broker events.Broker, id primitive.ObjectID) error {
+	return broker.Publish(ctx, events.KitchenChannel, []byte(id.Hex()))
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrLocationNotFound   = errors.New("location not found")
+	ErrLocationRequired   = errors.New("a location is required")
+	ErrInvalidLocation    = errors.New("invalid location id")
+	ErrLocationClosed     = errors.New("location is closed")
+	ErrProductUnavailable = errors.New("product is not available at this location")
+)
+
+// ValidateLocation checks what the request validator cannot: that the
+// timezone exists and every opening period is a valid span of one day.
+func ValidateLocation(location store.Location) error {
+	if _, err := time.LoadLocation(location.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", location.Timezone)
+	}
+
+	for _, hours := range location.OpeningHours {
+		opens, err := parseClock(hours.Opens)
+		if err != nil {
+			return err
+		}
+		closes, err := parseClock(hours.Closes)
+		if err != nil {
+			return err
+		}
+		if opens >= closes {
+			return fmt.Errorf("opening hours %s-%s end before they start", hours.Opens, hours.Closes)
+		}
+	}
+	return nil
+}
+
+// parseClock returns the minutes since midnight of a "15:04" clock time.
+// "24:00" is accepted as the end of the day.
+func parseClock(clock string) (int, error) {
+	if clock == "24:00" {
+		return 24 * 60, nil
+	}
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock time %q", clock)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// FindLocation loads a location by id.
+func FindLocation(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID) (store.Location, error) {
+	var location store.Location
+	err := collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&location)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Location{}, ErrLocationNotFound
+		}
+		return store.Location{}, err
+	}
+	return location, nil
+}
+
+// OrderLocation resolves where an order is placed: the location asked for,
+// else the default location configured. Once any location exists an order
+// must be placed at one, so its hours, closures and menu always apply; only a
+// shop that has not set up locations may leave it out and gets a zero id.
+func OrderLocation(ctx context.Context, collection *mongo.Collection, requested, fallback string) (primitive.ObjectID, error) {
+	if requested != "" {
+		id, err := primitive.ObjectIDFromHex(requested)
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("%w %q", ErrInvalidLocation, requested)
+		}
+		return id, nil
+	}
+
+	if fallback != "" {
+		id, err := primitive.ObjectIDFromHex(fallback)
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("invalid default location %q", fallback)
+		}
+		return id, nil
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if count > 0 {
+		return primitive.NilObjectID, ErrLocationRequired
+	}
+	return primitive.NilObjectID, nil
+}
+
+// LocationOpenAt reports whether the location takes orders at the given
+// instant, judged by the clock and calendar of its own timezone.
+func LocationOpenAt(location store.Location, at time.Time) (bool, error) {
+	tz, err := time.LoadLocation(location.Timezone)
+	if err != nil {
+		return false, err
+	}
+	local := at.In(tz)
+
+	date := local.Format("2006-01-02")
+	for _, closure := range location.Closures {
+		if closure.Date == date {
+			return false, nil
+		}
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	for _, hours := range location.OpeningHours {
+		if hours.Day != uint8(local.Weekday()) {
+			continue
+		}
+		opens, err := parseClock(hours.Opens)
+		if err != nil {
+			return false, err
+		}
+		closes, err := parseClock(hours.Closes)
+		if err != nil {
+			return false, err
+		}
+		if opens <= minute && minute < closes {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ApplyLocationProducts sets the prices of products to what they cost at
+// location and fails with ErrProductUnavailable for any product taken off
+// the location's menu.
+func ApplyLocationProducts(ctx context.Context, collection *mongo.Collection, location primitive.ObjectID, products map[primitive.ObjectID]store.Item) error {
+	ids := make([]primitive.ObjectID, 0, len(products))
+	for id := range products {
+		ids = append(ids, id)
+	}
+
+	cur, err := collection.Find(ctx, bson.D{
+		{Key: "location", Value: location},
+		{Key: "product", Value: bson.D{{Key: "$in", Value: ids}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var overrides []store.LocationProduct
+	if err := cur.All(ctx, &overrides); err != nil {
+		return err
+	}
+
+	for _, override := range overrides {
+		product := products[override.Product]
+		if !override.Available {
+			return fmt.Errorf("%w: %s", ErrProductUnavailable, product.Name)
+		}
+		if override.Price.Currency != "" {
+			product.Price = override.Price
+		}
+		products[override.Product] = product
+	}
+	return nil
+}
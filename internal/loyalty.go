@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInsufficientPoints = errors.New("not enough loyalty points")
+
+// LoyaltyRules says how points are earned, spent and expire.
+type LoyaltyRules struct {
+	PointsPerUnit     int64
+	PointsPerFreeItem int64
+	ExpireAfter       time.Duration
+}
+
+// ParseLoyaltyRules reads the LOYALTY_* settings, falling back to one point
+// per unit spent, a free item for 100 points and expiry after a year.
+func ParseLoyaltyRules(envs *types.Config) (LoyaltyRules, error) {
+	rules := LoyaltyRules{PointsPerUnit: 1, PointsPerFreeItem: 100, ExpireAfter: 365 * 24 * time.Hour}
+
+	parse := func(name, value string, target *int64) error {
+		if value == "" {
+			return nil
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number < 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = number
+		return nil
+	}
+
+	if err := parse("loyalty points per unit", envs.LOYALTY_POINTS_PER_UNIT, &rules.PointsPerUnit); err != nil {
+		return LoyaltyRules{}, err
+	}
+	if err := parse("loyalty points per free item", envs.LOYALTY_POINTS_PER_FREE_ITEM, &rules.PointsPerFreeItem); err != nil {
+		return LoyaltyRules{}, err
+	}
+	if rules.PointsPerFreeItem == 0 {
+		return LoyaltyRules{}, errors.New("loyalty points per free item must be positive")
+	}
+
+	days := int64(365)
+	if err := parse("loyalty points expire days", envs.LOYALTY_POINTS_EXPIRE_DAYS, &days); err != nil {
+		return LoyaltyRules{}, err
+	}
+	rules.ExpireAfter = time.Duration(days) * 24 * time.Hour
+	return rules, nil
+}
+
+// PointsEarned is what spending total earns, counting whole currency units
+// only.
+func (rules LoyaltyRules) PointsEarned(total money.Money) int64 {
+	if total.Amount <= 0 {
+		return 0
+	}
+	return total.Amount / money.Factor(total.Currency) * rules.PointsPerUnit
+}
+
+// ApplyLoyaltyRedemption makes redeem[i] units of line i free, each for
+// PointsPerFreeItem points. A unit is worth its share of the line after all
+// other discounts. It returns nil when nothing is redeemed.
+func ApplyLoyaltyRedemption(items []store.OrderItem, redeem []uint32, rules LoyaltyRules) *store.AppliedLoyalty {
+	var applied *store.AppliedLoyalty
+	for idx, item := range items {
+		if idx >= len(redeem) || redeem[idx] == 0 {
+			continue
+		}
+		if applied == nil {
+			applied = &store.AppliedLoyalty{Discount: money.Zero(item.Amount.Currency)}
+		}
+
+		quantity := redeem[idx]
+		discount := item.Amount.Sub(item.Discount).Ratio(int64(quantity), int64(item.Quantity))
+		applied.Points += int64(quantity) * rules.PointsPerFreeItem
+		applied.Discount = applied.Discount.Add(discount)
+		applied.Lines = append(applied.Lines, store.AppliedLoyaltyLine{
+			Line:     idx,
+			Product:  item.Product,
+			Quantity: quantity,
+			Discount: discount,
+		})
+	}
+	return applied
+}
+
+// EarnLoyaltyPoints credits the owner of a completed order.
+func EarnLoyaltyPoints(ctx context.Context, str store.Mongo, order store.Order, rules LoyaltyRules) error {
+	points := rules.PointsEarned(order.TotalAmount)
+	if points == 0 {
+		return nil
+	}
+	_, err := appendLoyaltyTransaction(ctx, str, order.Owner, types.LOYALTY_EARN, points, order.Id)
+	return err
+}
+
+// RedeemLoyaltyPoints debits the points an order was paid with. It fails with
+// ErrInsufficientPoints rather than let the balance go negative.
+func RedeemLoyaltyPoints(ctx context.Context, str store.Mongo, order store.Order) error {
+	if order.Loyalty == nil || order.Loyalty.Points == 0 {
+		return nil
+	}
+	_, err := appendLoyaltyTransaction(ctx, str, order.Owner, types.LOYALTY_REDEEM, -order.Loyalty.Points, order.Id)
+	return err
+}
+
+// ReturnLoyaltyPoints gives back the points of a cancelled or rejected order.
+func ReturnLoyaltyPoints(ctx context.Context, str store.Mongo, order store.Order) error {
+	if order.Loyalty == nil || order.Loyalty.Points == 0 {
+		return nil
+	}
+	_, err := appendLoyaltyTransaction(ctx, str, order.Owner, types.LOYALTY_RETURN, order.Loyalty.Points, order.Id)
+	return err
+}
+
+// ExpireLoyaltyPoints expires the user's points credited before cutoff that
+// are still unspent and returns how many expired. Points are spent oldest
+// first, so what is left of the old credits is whatever all debits so far
+// have not used up.
+func ExpireLoyaltyPoints(ctx context.Context, str store.Mongo, user primitive.ObjectID, cutoff time.Time) (int64, error) {
+	session, err := str.TxnStartSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		txColl := str.Collection(ctx, "coffeeshop", "loyalty_transactions")
+
+		cur, err := txColl.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: "user", Value: user}}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: nil},
+				{Key: "old_credits", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$and", Value: bson.A{
+						bson.D{{Key: "$gt", Value: bson.A{"$points", 0}}},
+						bson.D{{Key: "$lt", Value: bson.A{"$created_at", cutoff}}},
+					}}},
+					"$points", 0,
+				}}}}}},
+				{Key: "debits", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$lt", Value: bson.A{"$points", 0}}},
+					bson.D{{Key: "$abs", Value: "$points"}}, 0,
+				}}}}}},
+			}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer cur.Close(ctx)
+
+		var totals []struct {
+			OldCredits int64 `bson:"old_credits"`
+			Debits     int64 `bson:"debits"`
+		}
+		if err := cur.All(ctx, &totals); err != nil {
+			return nil, err
+		}
+		if len(totals) == 0 || totals[0].OldCredits <= totals[0].Debits {
+			return int64(0), nil
+		}
+
+		expired := totals[0].OldCredits - totals[0].Debits
+		_, err = appendLoyaltyTransaction(ctx, str, user, types.LOYALTY_EXPIRE, -expired, primitive.NilObjectID)
+		if err != nil {
+			return nil, err
+		}
+		return expired, nil
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return response.(int64), nil
+}
+
+// appendLoyaltyTransaction moves the user's balance by points and logs the
+// move. It must run inside a transaction so the two never disagree.
+func appendLoyaltyTransaction(ctx context.Context, str store.Mongo, user primitive.ObjectID, kind types.LoyaltyKind, points int64, order primitive.ObjectID) (store.LoyaltyTransaction, error) {
+	accColl := str.Collection(ctx, "coffeeshop", "loyalty_accounts")
+	txColl := str.Collection(ctx, "coffeeshop", "loyalty_transactions")
+
+	filter := bson.D{{Key: "_id", Value: user}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if points < 0 {
+		filter = append(filter, bson.E{Key: "balance", Value: bson.D{{Key: "$gte", Value: -points}}})
+	} else {
+		opts.SetUpsert(true)
+	}
+
+	var account store.LoyaltyAccount
+	err := accColl.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "balance", Value: points}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+	}, opts).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.LoyaltyTransaction{}, ErrInsufficientPoints
+		}
+		return store.LoyaltyTransaction{}, err
+	}
+
+	transaction := store.LoyaltyTransaction{
+		Id:        primitive.NewObjectID(),
+		User:      user,
+		Kind:      string(kind),
+		Points:    points,
+		Balance:   account.Balance,
+		Order:     order,
+		CreatedAt: time.Now(),
+	}
+	_, err = txColl.InsertOne(ctx, transaction)
+	if err != nil {
+		return store.LoyaltyTransaction{}, err
+	}
+	return transaction, nil
+}
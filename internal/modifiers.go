@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+)
+
+var ErrInvalidModifiers = errors.New("invalid modifier selection")
+
+// NormalizeModifierGroups validates the modifier groups submitted for a
+// product priced in currency. A required group needs at least one selection
+// and a group without a maximum allows every option to be picked.
+func NormalizeModifierGroups(params []types.ModifierGroupParams, currency string) ([]store.ModifierGroup, error) {
+	groups := make([]store.ModifierGroup, 0, len(params))
+	seenGroups := make(map[string]bool)
+
+	for _, param := range params {
+		if seenGroups[param.Name] {
+			return nil, fmt.Errorf("duplicate modifier group %q", param.Name)
+		}
+		seenGroups[param.Name] = true
+
+		group := store.ModifierGroup{
+			Name:          param.Name,
+			Required:      param.Required,
+			MinSelections: param.MinSelections,
+			MaxSelections: param.MaxSelections,
+		}
+
+		seenOptions := make(map[string]bool)
+		for _, option := range param.Options {
+			if seenOptions[option.Name] {
+				return nil, fmt.Errorf("duplicate option %q in modifier group %q", option.Name, param.Name)
+			}
+			seenOptions[option.Name] = true
+
+			delta := option.PriceDelta
+			if delta.Currency == "" {
+				delta = money.New(delta.Amount, currency)
+			}
+			if delta.Currency != currency {
+				return nil, fmt.Errorf("%w: option %q is priced in %s, product in %s", money.ErrCurrencyMismatch, option.Name, delta.Currency, currency)
+			}
+			group.Options = append(group.Options, store.ModifierOption{Name: option.Name, PriceDelta: delta})
+		}
+
+		if group.Required && group.MinSelections == 0 {
+			group.MinSelections = 1
+		}
+		if group.MaxSelections == 0 {
+			group.MaxSelections = uint32(len(group.Options))
+		}
+		if group.MinSelections > group.MaxSelections || int(group.MinSelections) > len(group.Options) {
+			return nil, fmt.Errorf("modifier group %q cannot require %d selection(s)", group.Name, group.MinSelections)
+		}
+
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func ModifierGroupsToParams(groups []store.ModifierGroup) []types.ModifierGroupParams {
+	params := make([]types.ModifierGroupParams, 0, len(groups))
+	for _, group := range groups {
+		param := types.ModifierGroupParams{
+			Name:          group.Name,
+			Required:      group.Required,
+			MinSelections: group.MinSelections,
+			MaxSelections: group.MaxSelections,
+		}
+		for _, option := range group.Options {
+			param.Options = append(param.Options, types.ModifierOptionParams{Name: option.Name, PriceDelta: option.PriceDelta})
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// PriceOrderItem checks the modifiers chosen for an order line against the
+// product's modifier groups and returns the unit price including every
+// option's price delta, together with the selections priced server side.
+func PriceOrderItem(product store.Item, selected []store.SelectedModifier, currency string) (money.Money, []store.SelectedModifier, error) {
+	if product.Price.Currency != currency {
+		return money.Money{}, nil, fmt.Errorf("%w: %s is priced in %s, order in %s", money.ErrCurrencyMismatch, product.Name, product.Price.Currency, currency)
+	}
+
+	groups := make(map[string]store.ModifierGroup)
+	for _, group := range product.Modifiers {
+		groups[group.Name] = group
+	}
+
+	unitPrice := product.Price
+	counts := make(map[string]uint32)
+	chosen := make(map[string]bool)
+	var priced []store.SelectedModifier
+
+	for _, selection := range selected {
+		group, ok := groups[selection.Group]
+		if !ok {
+			return money.Money{}, nil, fmt.Errorf("%w: %s has no modifier group %q", ErrInvalidModifiers, product.Name, selection.Group)
+		}
+
+		key := selection.Group + "/" + selection.Option
+		if chosen[key] {
+			return money.Money{}, nil, fmt.Errorf("%w: %q selected more than once in %q", ErrInvalidModifiers, selection.Option, selection.Group)
+		}
+
+		var option *store.ModifierOption
+		for i := range group.Options {
+			if group.Options[i].Name == selection.Option {
+				option = &group.Options[i]
+				break
+			}
+		}
+		if option == nil {
+			return money.Money{}, nil, fmt.Errorf("%w: %q is not an option of %q", ErrInvalidModifiers, selection.Option, selection.Group)
+		}
+
+		chosen[key] = true
+		counts[group.Name]++
+		unitPrice = unitPrice.Add(option.PriceDelta)
+		priced = append(priced, store.SelectedModifier{
+			Group:      group.Name,
+			Option:     option.Name,
+			PriceDelta: option.PriceDelta,
+		})
+	}
+
+	for _, group := range product.Modifiers {
+		count := counts[group.Name]
+		if count < group.MinSelections {
+			return money.Money{}, nil, fmt.Errorf("%w: %q requires at least %d selection(s)", ErrInvalidModifiers, group.Name, group.MinSelections)
+		}
+		if count > group.MaxSelections {
+			return money.Money{}, nil, fmt.Errorf("%w: %q allows at most %d selection(s)", ErrInvalidModifiers, group.Name, group.MaxSelections)
+		}
+	}
+
+	return unitPrice, priced, nil
+}
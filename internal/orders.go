@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/events"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidOrderTransition = errors.New("invalid order status transition")
+	ErrOrderStatusConflict    = errors.New("order status was changed by another request")
+)
+
+// orderTransitions maps an order status to the statuses it may move to next.
+// completed, cancelled and rejected are terminal. Preparing and ready orders
+// may be recalled a step by the kitchen.
+var orderTransitions = map[types.OrderStatus][]types.OrderStatus{
+	types.ORDER_PENDING:   {types.ORDER_ACCEPTED, types.ORDER_REJECTED, types.ORDER_CANCELLED},
+	types.ORDER_ACCEPTED:  {types.ORDER_PREPARING, types.ORDER_CANCELLED},
+	types.ORDER_PREPARING: {types.ORDER_READY, types.ORDER_ACCEPTED, types.ORDER_CANCELLED},
+	types.ORDER_READY:     {types.ORDER_COMPLETED, types.ORDER_PREPARING},
+}
+
+func ValidateOrderTransition(from, to types.OrderStatus) error {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w from %s to %s", ErrInvalidOrderTransition, from, to)
+}
+
+// TransitionOrderStatus moves order to the given status and appends change to
+// its status history. The update only applies if the stored status still
+// matches order.Status, so two concurrent transitions cannot both succeed.
+func TransitionOrderStatus(ctx context.Context, collection *mongo.Collection, order store.Order, to types.OrderStatus, change store.OrderStatusChange, extra ...bson.E) (store.Order, error) {
+	if err := ValidateOrderTransition(types.OrderStatus(order.Status), to); err != nil {
+		return store.Order{}, err
+	}
+
+	now := time.Now()
+	change.From = order.Status
+	change.To = string(to)
+	change.ChangedAt = now
+
+	set := bson.D{{Key: "status", Value: string(to)}, {Key: "updated_at", Value: now}}
+	set = append(set, extra...)
+
+	filter := bson.D{{Key: "_id", Value: order.Id}, {Key: "status", Value: order.Status}}
+	update := bson.D{{Key: "$set", Value: set}, {Key: "$push", Value: bson.D{{Key: "status_history", Value: change}}}}
+
+	newDocs := options.After
+	var updated store.Order
+	err := collection.FindOneAndUpdate(ctx, filter, update, &options.FindOneAndUpdateOptions{
+		ReturnDocument: &newDocs,
+	}).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Order{}, ErrOrderStatusConflict
+		}
+		return store.Order{}, err
+	}
+
+	return updated, nil
+}
+
+// AnnounceOrder tells the order's event streams, and the kitchen feeds when
+// it is queued, that it changed. The message only carries the length of its
+// status history; streams read the changes themselves from the stored order.
+func AnnounceOrder(ctx context.Context, broker events.Broker, order store.Order) error {
+	message := []byte(strconv.Itoa(len(order.StatusHistory)))
+	err := broker.Publish(ctx, events.OrderChannel(order.Id.Hex()), message)
+	if err != nil {
+		return err
+	}
+
+	if order.QueuedAt.IsZero() {
+		return nil
+	}
+	return AnnounceKitchenTicket(ctx, broker, order.Id)
+}
+
+// OrderFinished reports whether an order's status can no longer change.
+func OrderFinished(status string) bool {
+	_, ok := orderTransitions[types.OrderStatus(status)]
+	return !ok
+}
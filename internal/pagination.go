@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultPageLimit int64 = 20
+	MaxPageLimit     int64 = 100
+)
+
+// PageLimit reads the "limit" query parameter, falling back to
+// DefaultPageLimit and capping the value at MaxPageLimit.
+func PageLimit(query url.Values) (int64, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return DefaultPageLimit, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit %q", value)
+	}
+
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return limit, nil
+}
+
+// EncodeCursor packs the sort value and id of the last document on a page
+// into an opaque token. BSON keeps the value's type, so dates and integers
+// compare correctly when the cursor is decoded.
+func EncodeCursor(value interface{}, id primitive.ObjectID) (string, error) {
+	data, err := bson.Marshal(bson.D{{Key: "v", Value: value}, {Key: "id", Value: id}})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeCursor(cursor string) (interface{}, primitive.ObjectID, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, primitive.NilObjectID, fmt.Errorf("invalid cursor %w", err)
+	}
+
+	var decoded struct {
+		Value interface{}        `bson:"v"`
+		Id    primitive.ObjectID `bson:"id"`
+	}
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		return nil, primitive.NilObjectID, fmt.Errorf("invalid cursor %w", err)
+	}
+	return decoded.Value, decoded.Id, nil
+}
+
+// KeysetFilter matches the documents that sort after (value, id) when a
+// collection is ordered by field and then _id, both in the same direction.
+func KeysetFilter(field string, value interface{}, id primitive.ObjectID, ascending bool) bson.E {
+	operator := "$lt"
+	if ascending {
+		operator = "$gt"
+	}
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: field, Value: bson.D{{Key: operator, Value: value}}}},
+		bson.D{{Key: field, Value: value}, {Key: "_id", Value: bson.D{{Key: operator, Value: id}}}},
+	}}
+}
+
+// NextPageLink rebuilds the request URL with cursor swapped in so clients can
+// follow it without knowing how the other query parameters were built.
+func NextPageLink(u *url.URL, cursor string) string {
+	query := u.Query()
+	query.Set("cursor", cursor)
+	return fmt.Sprintf("%s?%s", u.Path, query.Encode())
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// argon2idParams are stored with every hash, so they can be raised later and
+// older hashes are upgraded on the next login.
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	saltLen uint32
+	keyLen  uint32
+}
+
+var passwordParams = argon2idParams{
+	memory:  64 * 1024,
+	time:    3,
+	threads: 2,
+	saltLen: 16,
+	keyLen:  32,
+}
+
+// HashPassword hashes a password with argon2id and a random salt, encoded as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordParams.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, passwordParams.time, passwordParams.memory, passwordParams.threads, passwordParams.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, passwordParams.memory, passwordParams.time, passwordParams.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks a password against its stored hash: argon2id, bcrypt
+// or the unsalted SHA-256 digest accounts were created with before argon2id.
+// rehash reports that the password matched a hash that should be replaced
+// with a fresh one from HashPassword.
+func VerifyPassword(password, encoded string) (ok bool, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		params.saltLen, params.keyLen = uint32(len(salt)), uint32(len(key))
+		return true, params != passwordParams, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		return true, true, nil
+	default:
+		if subtle.ConstantTimeCompare([]byte(legacyPasswordHash(password)), []byte(encoded)) != 1 {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+}
+
+func decodeArgon2id(encoded string) (params argon2idParams, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	_, err = fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil || params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	return params, salt, key, nil
+}
+
+// legacyPasswordHash is how passwords used to be stored. Sum appends the
+// digest of nothing to its argument, so this is the password itself in hex
+// followed by the SHA-256 of the empty string.
+func legacyPasswordHash(password string) string {
+	return fmt.Sprintf("%x", sha256.New().Sum([]byte(password)))
+}
@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPaymentInProgress = errors.New("order is already paid or has a payment in progress")
+	ErrPaymentNotFound   = errors.New("payment not found")
+)
+
+// BeginOrderPayment marks a pending order as awaiting payment. Orders that
+// are paid or already awaiting a payment are refused, so two requests cannot
+// charge the customer twice; a rejected payment may be retried.
+func BeginOrderPayment(ctx context.Context, collection *mongo.Collection, order store.Order) (store.Order, error) {
+	filter := bson.D{
+		{Key: "_id", Value: order.Id},
+		{Key: "status", Value: string(types.ORDER_PENDING)},
+		{Key: "payment_status", Value: bson.D{{Key: "$nin", Value: bson.A{types.PENDING.String(), types.PAID.String()}}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "payment_status", Value: types.PENDING.String()},
+		{Key: "updated_at", Value: time.Now()},
+	}}}
+
+	var updated store.Order
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Order{}, ErrPaymentInProgress
+		}
+		return store.Order{}, err
+	}
+	return updated, nil
+}
+
+// paymentStartTimeout is how long a payment may take to reach the provider.
+// A payment still without an intent after that was abandoned, e.g. by a
+// crash, and no longer blocks the order.
+const paymentStartTimeout = 10 * time.Minute
+
+// RejectUnsentPayment gives up on a payment that never reached the provider,
+// so nothing was charged, and lets the customer pay the order again. A zero
+// paymentID only resets the order, for when the payment was never stored.
+func RejectUnsentPayment(ctx context.Context, str store.Mongo, orderID, paymentID primitive.ObjectID, reason string) error {
+	session, err := str.TxnStartSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		payColl := str.Collection(ctx, "coffeeshop", "payments")
+		ordColl := str.Collection(ctx, "coffeeshop", "orders")
+
+		if !paymentID.IsZero() {
+			_, err := payColl.UpdateOne(ctx, bson.D{
+				{Key: "_id", Value: paymentID},
+				{Key: "status", Value: types.PENDING.String()},
+				{Key: "intent_id", Value: bson.D{{Key: "$exists", Value: false}}},
+			}, bson.D{{Key: "$set", Value: bson.D{
+				{Key: "status", Value: types.REJECTED.String()},
+				{Key: "failure_reason", Value: reason},
+				{Key: "updated_at", Value: time.Now()},
+			}}})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err := ordColl.UpdateOne(ctx, bson.D{
+			{Key: "_id", Value: orderID},
+			{Key: "payment_status", Value: types.PENDING.String()},
+		}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "payment_status", Value: types.REJECTED.String()},
+			{Key: "updated_at", Value: time.Now()},
+		}}})
+		return nil, err
+	}, &options.TransactionOptions{})
+	return err
+}
+
+// ReleaseAbandonedPayment rejects the payment an order awaits when it was
+// started longer than paymentStartTimeout ago and never reached the
+// provider. It reports whether the order may be paid again.
+func ReleaseAbandonedPayment(ctx context.Context, str store.Mongo, order store.Order, now time.Time) (bool, error) {
+	if order.PaymentStatus != types.PENDING.String() || now.Sub(order.UpdatedAt) < paymentStartTimeout {
+		return false, nil
+	}
+
+	payColl := str.Collection(ctx, "coffeeshop", "payments")
+	var payment store.Payment
+	err := payColl.FindOne(ctx, bson.D{
+		{Key: "order", Value: order.Id},
+		{Key: "status", Value: types.PENDING.String()},
+	}, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})).Decode(&payment)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	if payment.IntentId != "" || now.Sub(payment.CreatedAt) < paymentStartTimeout {
+		// the provider has it, or it is still on its way there
+		return false, nil
+	}
+
+	err = RejectUnsentPayment(ctx, str, order.Id, payment.Id, "abandoned before reaching the provider")
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ApplyPaymentOutcome settles the pending payment for intentID as paid or
+// rejected and updates its order in one transaction. A paid order that is
+// still pending is accepted on the customer's behalf. Settling a payment a
+// second time is a no-op, so repeated provider notifications are harmless.
+func ApplyPaymentOutcome(ctx context.Context, str store.Mongo, intentID string, status types.PaymentStatus, reason string) (store.Payment, store.Order, error) {
+	session, err := str.TxnStartSession(ctx)
+	if err != nil {
+		return store.Payment{}, store.Order{}, err
+	}
+	defer session.EndSession(ctx)
+
+	type outcome struct {
+		payment store.Payment
+		order   store.Order
+	}
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		payColl := str.Collection(ctx, "coffeeshop", "payments")
+		ordColl := str.Collection(ctx, "coffeeshop", "orders")
+
+		var payment store.Payment
+		err := payColl.FindOneAndUpdate(ctx, bson.D{
+			{Key: "intent_id", Value: intentID},
+			{Key: "status", Value: types.PENDING.String()},
+		}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status.String()},
+			{Key: "failure_reason", Value: reason},
+			{Key: "updated_at", Value: time.Now()},
+		}}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&payment)
+
+		settled := err == mongo.ErrNoDocuments
+		if settled {
+			err = payColl.FindOne(ctx, bson.D{{Key: "intent_id", Value: intentID}}).Decode(&payment)
+		}
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, fmt.Errorf("%w: %s", ErrPaymentNotFound, intentID)
+			}
+			return nil, err
+		}
+
+		var order store.Order
+		err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: payment.Order}}).Decode(&order)
+		if err != nil {
+			return nil, err
+		}
+		if settled {
+			return outcome{payment, order}, nil
+		}
+
+		paymentFields := []bson.E{
+			{Key: "payment_status", Value: status.String()},
+			{Key: "payment", Value: payment.Id},
+		}
+		if status == types.PAID {
+			// the charge pays what the gift cards left, both count as paid
+			paidTotal, refundedTotal := payment.Amount, money.Zero(payment.Amount.Currency)
+			if len(order.GiftCards) > 0 {
+				paidTotal = paidTotal.Add(order.GiftCardTotal)
+				refundedTotal = refundedTotal.Add(GiftCardsRefunded(order))
+			}
+			paymentFields = append(paymentFields,
+				bson.E{Key: "paid_total", Value: paidTotal},
+				bson.E{Key: "refunded_total", Value: refundedTotal},
+			)
+		}
+
+		if status == types.PAID && order.Status == string(types.ORDER_PENDING) {
+			change := store.OrderStatusChange{
+				ChangedBy: payment.Customer,
+				Role:      "system",
+				Note:      fmt.Sprintf("payment %s received", payment.IntentId),
+			}
+			order, err = TransitionOrderStatus(ctx, ordColl, order, types.ORDER_ACCEPTED, change, paymentFields...)
+			if err != nil {
+				return nil, err
+			}
+			return outcome{payment, order}, nil
+		}
+
+		set := append(bson.D{{Key: "updated_at", Value: time.Now()}}, paymentFields...)
+		err = ordColl.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: order.Id}}, bson.D{{Key: "$set", Value: set}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&order)
+		if err != nil {
+			return nil, err
+		}
+		return outcome{payment, order}, nil
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return store.Payment{}, store.Order{}, err
+	}
+
+	result := response.(outcome)
+	return result.payment, result.order, nil
+}
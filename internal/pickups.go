@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPickupUnavailable = errors.New("pickup time is not available")
+	ErrPickupSlotFull    = errors.New("pickup slot is fully booked")
+)
+
+// MaxPickupAhead is how far ahead an order may be placed for pickup.
+const MaxPickupAhead = 7 * 24 * time.Hour
+
+// PickupRules says how pickup slots are laid out and how long before pickup
+// an order is sent to the kitchen.
+type PickupRules struct {
+	SlotLength   time.Duration
+	SlotCapacity int64
+	PrepTime     time.Duration
+}
+
+// ParsePickupRules reads the PICKUP_* settings, falling back to slots of 15
+// minutes for 10 orders each and 10 minutes of preparation.
+func ParsePickupRules(envs *types.Config) (PickupRules, error) {
+	slotMinutes, capacity, prepMinutes := int64(15), int64(10), int64(10)
+
+	parse := func(name, value string, target *int64) error {
+		if value == "" {
+			return nil
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number < 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = number
+		return nil
+	}
+
+	if err := parse("pickup slot minutes", envs.PICKUP_SLOT_MINUTES, &slotMinutes); err != nil {
+		return PickupRules{}, err
+	}
+	if err := parse("pickup slot capacity", envs.PICKUP_SLOT_CAPACITY, &capacity); err != nil {
+		return PickupRules{}, err
+	}
+	if err := parse("pickup prep minutes", envs.PICKUP_PREP_MINUTES, &prepMinutes); err != nil {
+		return PickupRules{}, err
+	}
+	if slotMinutes == 0 {
+		return PickupRules{}, errors.New("pickup slot minutes must be positive")
+	}
+
+	return PickupRules{
+		SlotLength:   time.Duration(slotMinutes) * time.Minute,
+		SlotCapacity: capacity,
+		PrepTime:     time.Duration(prepMinutes) * time.Minute,
+	}, nil
+}
+
+// PickupSlots returns the start of every pickup slot of location on date, a
+// "2006-01-02" day in the location's timezone. Slots run back to back from
+// the start of each opening period and must end by its close.
+func PickupSlots(location store.Location, date string, rules PickupRules) ([]time.Time, error) {
+	tz, err := time.LoadLocation(location.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", date)
+	}
+
+	for _, closure := range location.Closures {
+		if closure.Date == date {
+			return nil, nil
+		}
+	}
+
+	step := int(rules.SlotLength / time.Minute)
+	var slots []time.Time
+	for _, hours := range location.OpeningHours {
+		if hours.Day != uint8(day.Weekday()) {
+			continue
+		}
+		opens, err := parseClock(hours.Opens)
+		if err != nil {
+			return nil, err
+		}
+		closes, err := parseClock(hours.Closes)
+		if err != nil {
+			return nil, err
+		}
+		for minute := opens; minute+step <= closes; minute += step {
+			slots = append(slots, time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, tz))
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Before(slots[j]) })
+	return slots, nil
+}
+
+// PickupSlotAt returns the start of the location's slot that pickup falls
+// in. Pickups must leave time to prepare the order, lie within
+// MaxPickupAhead and fall in opening hours.
+func PickupSlotAt(location store.Location, pickup time.Time, rules PickupRules, now time.Time) (time.Time, error) {
+	if pickup.Before(now.Add(rules.PrepTime)) {
+		return time.Time{}, fmt.Errorf("%w: orders need %v to prepare", ErrPickupUnavailable, rules.PrepTime)
+	}
+	if pickup.After(now.Add(MaxPickupAhead)) {
+		return time.Time{}, fmt.Errorf("%w: orders can be placed at most %v ahead", ErrPickupUnavailable, MaxPickupAhead)
+	}
+
+	tz, err := time.LoadLocation(location.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	slots, err := PickupSlots(location, pickup.In(tz).Format("2006-01-02"), rules)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, start := range slots {
+		if !pickup.Before(start) && pickup.Before(start.Add(rules.SlotLength)) {
+			return start, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %s is closed then", ErrPickupUnavailable, location.Name)
+}
+
+// PickupSlotId names the slot of location starting at start.
+func PickupSlotId(location primitive.ObjectID, start time.Time) string {
+	return fmt.Sprintf("%s:%d", location.Hex(), start.Unix())
+}
+
+// BookPickupSlot takes a place in a slot for an order. It runs in the
+// order's transaction; a full slot is never matched by the filter, so the
+// upsert tries to insert a second document under its id and fails.
+func BookPickupSlot(ctx context.Context, str store.Mongo, location primitive.ObjectID, start time.Time, capacity int64) (string, error) {
+	slotColl := str.Collection(ctx, "coffeeshop", "pickup_slots")
+
+	id := PickupSlotId(location, start)
+	_, err := slotColl.UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "booked", Value: bson.D{{Key: "$lt", Value: capacity}}},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "booked", Value: 1}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "location", Value: location}, {Key: "starts_at", Value: start}}},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", ErrPickupSlotFull
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+// ReleasePickupSlot frees the slot of a cancelled or rejected order.
+func ReleasePickupSlot(ctx context.Context, str store.Mongo, order store.Order) error {
+	if order.PickupSlot == "" {
+		return nil
+	}
+
+	slotColl := str.Collection(ctx, "coffeeshop", "pickup_slots")
+	_, err := slotColl.UpdateOne(ctx, bson.D{{Key: "_id", Value: order.PickupSlot}, {Key: "booked", Value: bson.D{{Key: "$gt", Value: 0}}}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "booked", Value: -1}}}})
+	return err
+}
+
+// BookedPickupSlots returns how many orders are booked in each slot of
+// location starting in [from, to), keyed by slot id.
+func BookedPickupSlots(ctx context.Context, collection *mongo.Collection, location primitive.ObjectID, from, to time.Time) (map[string]int64, error) {
+	cur, err := collection.Find(ctx, bson.D{
+		{Key: "location", Value: location},
+		{Key: "starts_at", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var slots []store.PickupSlot
+	if err := cur.All(ctx, &slots); err != nil {
+		return nil, err
+	}
+
+	booked := make(map[string]int64, len(slots))
+	for _, slot := range slots {
+		booked[slot.Id] = slot.Booked
+	}
+	return booked, nil
+}
+
+// DueOrders returns the orders placed ahead that are due in the kitchen by
+// now but were not sent there yet, e.g. because their task was never
+// scheduled.
+func DueOrders(ctx context.Context, collection *mongo.Collection, now time.Time) ([]primitive.ObjectID, error) {
+	cur, err := collection.Find(ctx, bson.D{
+		{Key: "due_at", Value: bson.D{{Key: "$lte", Value: now}}},
+		{Key: "queued_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{string(types.ORDER_PENDING), string(types.ORDER_ACCEPTED)}}}},
+	}, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var orders []store.Order
+	if err := cur.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.Id)
+	}
+	return ids, nil
+}
+
+// QueueOrder sends an order placed ahead to the kitchen. Orders that were
+// already sent, or cancelled or rejected meanwhile, are left alone and false
+// is returned.
+func QueueOrder(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, now time.Time) (bool, error) {
+	result, err := collection.UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "queued_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{string(types.ORDER_PENDING), string(types.ORDER_ACCEPTED)}}}},
+	}, bson.D{{Key: "$set", Value: bson.D{{Key: "queued_at", Value: now}, {Key: "updated_at", Value: now}}}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
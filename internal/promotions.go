@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrPromotionNotFound      = errors.New("promo code not found")
+	ErrPromotionInactive      = errors.New("promo code is not valid at this time")
+	ErrPromotionMinimum       = errors.New("order does not reach the promo code's minimum amount")
+	ErrPromotionNotApplicable = errors.New("promo code does not apply to any item in the order")
+	ErrPromotionUsedUp        = errors.New("promo code has reached its usage limit")
+)
+
+// NormalizePromoCode makes codes case-insensitive; they are stored upper case.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// FindPromotion loads the promotion for a code a customer entered.
+func FindPromotion(ctx context.Context, collection *mongo.Collection, code string) (store.Promotion, error) {
+	var promo store.Promotion
+	err := collection.FindOne(ctx, bson.D{{Key: "code", Value: NormalizePromoCode(code)}}).Decode(&promo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Promotion{}, ErrPromotionNotFound
+		}
+		return store.Promotion{}, err
+	}
+	return promo, nil
+}
+
+// ApplyPromotion works out what promo takes off the priced order lines at
+// time now. Lines are worth their amount after product discounts. The result
+// only depends on its arguments, so the same cart always gets the same
+// discount:
+//   - percentage takes Percent off every eligible line
+//   - fixed spreads Amount over the eligible lines in proportion to their
+//     worth, never more than they are worth together
+//   - buy X get Y makes GetQuantity of every BuyQuantity+GetQuantity eligible
+//     units free, cheapest units first and earlier lines first on a tie
+func ApplyPromotion(promo store.Promotion, items []store.OrderItem, products map[primitive.ObjectID]store.Item, now time.Time) (store.AppliedPromotion, error) {
+	if now.Before(promo.StartsAt) || !promo.EndsAt.IsZero() && !now.Before(promo.EndsAt) {
+		return store.AppliedPromotion{}, ErrPromotionInactive
+	}
+	if len(items) == 0 {
+		return store.AppliedPromotion{}, ErrPromotionNotApplicable
+	}
+
+	currency := items[0].Amount.Currency
+	subtotal := money.Zero(currency)
+	for _, item := range items {
+		subtotal = subtotal.Add(item.Amount.Sub(item.Discount))
+	}
+
+	if promo.MinOrder.Amount > 0 {
+		if !promo.MinOrder.SameCurrency(subtotal) {
+			return store.AppliedPromotion{}, money.ErrCurrencyMismatch
+		}
+		if subtotal.Amount < promo.MinOrder.Amount {
+			return store.AppliedPromotion{}, ErrPromotionMinimum
+		}
+	}
+
+	var eligible []int
+	eligibleTotal := money.Zero(currency)
+	for idx, item := range items {
+		if promotionCovers(promo, item, products[item.Product]) {
+			eligible = append(eligible, idx)
+			eligibleTotal = eligibleTotal.Add(item.Amount.Sub(item.Discount))
+		}
+	}
+
+	discounts := make(map[int]money.Money)
+	quantities := make(map[int]uint32)
+	switch types.PromotionKind(promo.Kind) {
+	case types.PROMOTION_PERCENTAGE:
+		for _, idx := range eligible {
+			item := items[idx]
+			discounts[idx] = item.Amount.Sub(item.Discount).Percent(promo.Percent)
+			quantities[idx] = item.Quantity
+		}
+
+	case types.PROMOTION_FIXED:
+		if !promo.Amount.SameCurrency(eligibleTotal) {
+			return store.AppliedPromotion{}, money.ErrCurrencyMismatch
+		}
+		if eligibleTotal.Amount <= 0 {
+			break
+		}
+
+		// allocating on running totals keeps the shares summing to total
+		total := promo.Amount.Min(eligibleTotal)
+		covered := money.Zero(currency)
+		for _, idx := range eligible {
+			item := items[idx]
+			net := item.Amount.Sub(item.Discount)
+			share := total.Ratio(covered.Add(net).Amount, eligibleTotal.Amount).Sub(total.Ratio(covered.Amount, eligibleTotal.Amount))
+			covered = covered.Add(net)
+			discounts[idx] = share
+			quantities[idx] = item.Quantity
+		}
+
+	case types.PROMOTION_BUY_X_GET_Y:
+		group := promo.BuyQuantity + promo.GetQuantity
+		if promo.GetQuantity == 0 || group == 0 {
+			break
+		}
+
+		var units uint32
+		for _, idx := range eligible {
+			units += items[idx].Quantity
+		}
+		free := units / group * promo.GetQuantity
+
+		cheapest := append([]int(nil), eligible...)
+		sort.SliceStable(cheapest, func(i, j int) bool {
+			a, b := items[cheapest[i]], items[cheapest[j]]
+			// compare unit prices without dividing
+			return a.Amount.Sub(a.Discount).Amount*int64(b.Quantity) < b.Amount.Sub(b.Discount).Amount*int64(a.Quantity)
+		})
+		for _, idx := range cheapest {
+			if free == 0 {
+				break
+			}
+			item := items[idx]
+			if item.Quantity == 0 {
+				continue
+			}
+			quantity := item.Quantity
+			if quantity > free {
+				quantity = free
+			}
+			free -= quantity
+			discounts[idx] = item.Amount.Sub(item.Discount).Ratio(int64(quantity), int64(item.Quantity))
+			quantities[idx] = quantity
+		}
+	}
+
+	applied := store.AppliedPromotion{
+		Promotion: promo.Id,
+		Code:      promo.Code,
+		Kind:      promo.Kind,
+		Discount:  money.Zero(currency),
+		Lines:     []store.AppliedPromotionLine{},
+	}
+	for idx, item := range items {
+		discount, ok := discounts[idx]
+		if !ok || discount.Amount <= 0 {
+			continue
+		}
+		applied.Discount = applied.Discount.Add(discount)
+		applied.Lines = append(applied.Lines, store.AppliedPromotionLine{
+			Line:     idx,
+			Product:  item.Product,
+			Quantity: quantities[idx],
+			Discount: discount,
+		})
+	}
+
+	if applied.Discount.Amount <= 0 {
+		return store.AppliedPromotion{}, ErrPromotionNotApplicable
+	}
+	return applied, nil
+}
+
+func promotionCovers(promo store.Promotion, item store.OrderItem, product store.Item) bool {
+	if len(promo.Products) == 0 && len(promo.Categories) == 0 {
+		return true
+	}
+	for _, id := range promo.Products {
+		if id == item.Product {
+			return true
+		}
+	}
+	for _, category := range promo.Categories {
+		if category == product.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// RedeemPromotion counts order against the promotion's limits. It runs in
+// the order's transaction: every redemption writes the promotion document,
+// so concurrent orders using the same code conflict and are retried instead
+// of both slipping under a limit.
+func RedeemPromotion(ctx context.Context, str store.Mongo, promo store.Promotion, order store.Order) error {
+	promColl := str.Collection(ctx, "coffeeshop", "promotions")
+	redColl := str.Collection(ctx, "coffeeshop", "promotion_redemptions")
+
+	if promo.PerUserLimit > 0 {
+		used, err := redColl.CountDocuments(ctx, bson.D{
+			{Key: "promotion", Value: promo.Id},
+			{Key: "customer", Value: order.Owner},
+		})
+		if err != nil {
+			return err
+		}
+		if used >= int64(promo.PerUserLimit) {
+			return ErrPromotionUsedUp
+		}
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: promo.Id},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "usage_limit", Value: 0}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$used_count", "$usage_limit"}}}}},
+		}},
+	}
+	result, err := promColl.UpdateOne(ctx, filter, bson.D{{Key: "$inc", Value: bson.D{{Key: "used_count", Value: 1}}}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrPromotionUsedUp
+	}
+
+	_, err = redColl.InsertOne(ctx, store.PromotionRedemption{
+		Id:        primitive.NewObjectID(),
+		Promotion: promo.Id,
+		Customer:  order.Owner,
+		Order:     order.Id,
+		Discount:  order.Promotion.Discount,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// ReleasePromotion gives the use of a promo code back when its order is
+// cancelled or rejected.
+func ReleasePromotion(ctx context.Context, str store.Mongo, order store.Order) error {
+	if order.Promotion == nil {
+		return nil
+	}
+
+	redColl := str.Collection(ctx, "coffeeshop", "promotion_redemptions")
+	result, err := redColl.DeleteOne(ctx, bson.D{{Key: "order", Value: order.Id}})
+	if err != nil || result.DeletedCount == 0 {
+		return err
+	}
+
+	promColl := str.Collection(ctx, "coffeeshop", "promotions")
+	_, err = promColl.UpdateOne(ctx, bson.D{{Key: "_id", Value: order.Promotion.Promotion}, {Key: "used_count", Value: bson.D{{Key: "$gt", Value: 0}}}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "used_count", Value: -1}}}})
+	return err
+}
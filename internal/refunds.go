@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOrderNotPaid     = errors.New("order has no payment left to refund")
+	ErrNothingToRefund  = errors.New("nothing left to refund")
+	ErrInvalidRefund    = errors.New("invalid refund")
+	ErrRefundConflict   = errors.New("order was refunded concurrently, try again")
+	ErrRefundNotPending = errors.New("refund is no longer pending")
+)
+
+// UnrefundedItems returns the order lines with their refunded units taken
+// off. Lines refunded in full are left out.
+func UnrefundedItems(items []store.OrderItem) []store.OrderItem {
+	var remaining []store.OrderItem
+	for _, item := range items {
+		if item.RefundedQuantity >= item.Quantity {
+			continue
+		}
+		item.Quantity -= item.RefundedQuantity
+		item.RefundedQuantity = 0
+		remaining = append(remaining, item)
+	}
+	return remaining
+}
+
+// PlanRefund works out what refunding lines of order gives back. No lines
+// means every unit not refunded yet. A line is worth its amount after
+// discount plus any tax charged on top of it, and each unit gets its share
+// of it, so refunding a line unit by unit returns exactly what refunding it
+// at once would. Once nothing is left
+// unrefunded the remainder of the paid total is returned, whatever rounding
+// happened on the way.
+func PlanRefund(order store.Order, lines []types.RefundItemParams) ([]store.RefundItem, money.Money, error) {
+	if order.PaymentStatus != types.PAID.String() || order.PaidTotal.Currency == "" {
+		return nil, money.Money{}, ErrOrderNotPaid
+	}
+
+	if len(lines) == 0 {
+		for idx, item := range order.Items {
+			if item.RefundedQuantity < item.Quantity {
+				lines = append(lines, types.RefundItemParams{Line: idx, Quantity: item.Quantity - item.RefundedQuantity})
+			}
+		}
+	}
+
+	requested := make(map[int]uint32)
+	for _, line := range lines {
+		if line.Line < 0 || line.Line >= len(order.Items) {
+			return nil, money.Money{}, fmt.Errorf("%w: order has no line %d", ErrInvalidRefund, line.Line)
+		}
+		requested[line.Line] += line.Quantity
+	}
+
+	amount := money.Zero(order.PaidTotal.Currency)
+	var items []store.RefundItem
+	for idx, item := range order.Items {
+		quantity, ok := requested[idx]
+		if !ok {
+			continue
+		}
+		if item.RefundedQuantity+quantity > item.Quantity {
+			return nil, money.Money{}, fmt.Errorf("%w: line %d has %d unit(s) left to refund", ErrInvalidRefund, idx, item.Quantity-item.RefundedQuantity)
+		}
+
+		net := item.Amount.Sub(item.Discount).Add(item.Tax)
+		refunded := item.RefundedQuantity
+		lineAmount := net.Ratio(int64(refunded+quantity), int64(item.Quantity)).Sub(net.Ratio(int64(refunded), int64(item.Quantity)))
+		amount = amount.Add(lineAmount)
+		items = append(items, store.RefundItem{
+			Line:     idx,
+			Product:  item.Product,
+			Quantity: quantity,
+			Amount:   lineAmount,
+		})
+	}
+
+	remaining := order.PaidTotal.Sub(order.RefundedTotal)
+	if len(items) == 0 || remaining.Amount <= 0 {
+		return nil, money.Money{}, ErrNothingToRefund
+	}
+
+	fullyRefunded := true
+	for idx, item := range order.Items {
+		if item.RefundedQuantity+requested[idx] < item.Quantity {
+			fullyRefunded = false
+			break
+		}
+	}
+	if fullyRefunded {
+		amount = remaining
+	}
+	return items, amount.Min(remaining), nil
+}
+
+// GiftCardRefunds splits amount of a refund of order between the provider
+// and the gift cards the order was paid with. What was charged is given back
+// first; the rest goes back onto the cards in the order they were redeemed.
+func GiftCardRefunds(order store.Order, amount money.Money) []store.AppliedGiftCard {
+	if len(order.GiftCards) == 0 {
+		return nil
+	}
+
+	cardsRefunded := GiftCardsRefunded(order)
+	charged := order.PaidTotal.Sub(order.GiftCardTotal)
+	chargeLeft := charged.Sub(order.RefundedTotal.Sub(cardsRefunded))
+	if chargeLeft.Amount < 0 {
+		chargeLeft = money.Zero(charged.Currency)
+	}
+
+	due := amount.Sub(amount.Min(chargeLeft))
+	var cards []store.AppliedGiftCard
+	for _, applied := range order.GiftCards {
+		if due.Amount <= 0 {
+			break
+		}
+		left := applied.Amount.Sub(applied.Refunded)
+		if left.Amount <= 0 {
+			continue
+		}
+		share := left.Min(due)
+		cards = append(cards, store.AppliedGiftCard{Card: applied.Card, Code: applied.Code, Amount: share})
+		due = due.Sub(share)
+	}
+	return cards
+}
+
+// RefundGiftCardTotal sums what a refund puts back on gift cards.
+func RefundGiftCardTotal(refund store.Refund) money.Money {
+	total := money.Zero(refund.Amount.Currency)
+	for _, card := range refund.GiftCards {
+		total = total.Add(card.Amount)
+	}
+	return total
+}
+
+// giftCardIndex returns where card is in the order's gift cards.
+func giftCardIndex(order store.Order, card primitive.ObjectID) int {
+	for idx, applied := range order.GiftCards {
+		if applied.Card == card {
+			return idx
+		}
+	}
+	return -1
+}
+
+// ClaimRefund records a pending refund and books its units and amount on the
+// order before any money moves, so two refunds of the same order cannot both
+// give it back. The order must not have been refunded since it was read.
+func ClaimRefund(ctx context.Context, str store.Mongo, order store.Order, refund store.Refund) (store.Refund, error) {
+	session, err := str.TxnStartSession(ctx)
+	if err != nil {
+		return store.Refund{}, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		ordColl := str.Collection(ctx, "coffeeshop", "orders")
+		refColl := str.Collection(ctx, "coffeeshop", "refunds")
+
+		inc := bson.D{{Key: "refunded_total.amount", Value: refund.Amount.Amount}}
+		for _, item := range refund.Items {
+			inc = append(inc, bson.E{Key: fmt.Sprintf("items.%d.refunded_quantity", item.Line), Value: int64(item.Quantity)})
+		}
+		for _, card := range refund.GiftCards {
+			idx := giftCardIndex(order, card.Card)
+			if idx < 0 {
+				return nil, fmt.Errorf("%w: order was not paid with gift card %s", ErrInvalidRefund, card.Code)
+			}
+			inc = append(inc, bson.E{Key: fmt.Sprintf("gift_cards.%d.refunded.amount", idx), Value: card.Amount.Amount})
+		}
+
+		filter := bson.D{
+			{Key: "_id", Value: order.Id},
+			{Key: "payment_status", Value: types.PAID.String()},
+			{Key: "refunded_total.amount", Value: order.RefundedTotal.Amount},
+		}
+		result, err := ordColl.UpdateOne(ctx, filter, bson.D{
+			{Key: "$inc", Value: inc},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount == 0 {
+			return nil, ErrRefundConflict
+		}
+
+		_, err = refColl.InsertOne(ctx, refund)
+		return nil, err
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return store.Refund{}, err
+	}
+	return refund, nil
+}
+
+// CompleteRefund marks a pending refund as given back by the provider, puts
+// its gift card share back on the cards and returns its units to stock.
+// Stock of an order that was cancelled before the refund was claimed has
+// already been returned and is left alone. An order with nothing left of its
+// payment is marked refunded.
+func CompleteRefund(ctx context.Context, str store.Mongo, refundID primitive.ObjectID, providerRefundID string) (store.Refund, store.Order, error) {
+	return settleRefund(ctx, str, refundID, func(ctx mongo.SessionContext, refund *store.Refund, order *store.Order) (bson.D, error) {
+		refund.Status = string(types.REFUND_SUCCEEDED)
+		refund.ProviderRefundId = providerRefundID
+
+		err := CreditGiftCards(ctx, str, refund.GiftCards, types.GIFT_CARD_REFUND, order.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		if refund.Restock {
+			prodColl := str.Collection(ctx, "coffeeshop", "products")
+			if err := RestoreStock(ctx, prodColl, refundedItems(*refund)); err != nil {
+				return nil, err
+			}
+		}
+
+		update := bson.D{{Key: "updated_at", Value: time.Now()}}
+		if order.RefundedTotal.Amount >= order.PaidTotal.Amount {
+			update = append(update, bson.E{Key: "payment_status", Value: types.REFUNDED.String()})
+		}
+		return bson.D{{Key: "$set", Value: update}}, nil
+	})
+}
+
+// FailRefund marks a pending refund as failed and takes its units and amount
+// off the order again. Units and gift card shares claimed before the order
+// was cancelled were not returned by the cancellation, so they go back to
+// stock and onto the cards here, and the gift card share stays refunded.
+func FailRefund(ctx context.Context, str store.Mongo, refundID primitive.ObjectID, reason string) (store.Refund, store.Order, error) {
+	return settleRefund(ctx, str, refundID, func(ctx mongo.SessionContext, refund *store.Refund, order *store.Order) (bson.D, error) {
+		refund.Status = string(types.REFUND_FAILED)
+		refund.FailureReason = reason
+
+		cancelled := order.Status == string(types.ORDER_CANCELLED) || order.Status == string(types.ORDER_REJECTED)
+		if refund.Restock && cancelled {
+			prodColl := str.Collection(ctx, "coffeeshop", "products")
+			if err := RestoreStock(ctx, prodColl, refundedItems(*refund)); err != nil {
+				return nil, err
+			}
+		}
+
+		undone := refund.Amount
+		if cancelled {
+			err := CreditGiftCards(ctx, str, refund.GiftCards, types.GIFT_CARD_RETURN, order.Id)
+			if err != nil {
+				return nil, err
+			}
+			undone = undone.Sub(RefundGiftCardTotal(*refund))
+		}
+
+		inc := bson.D{{Key: "refunded_total.amount", Value: -undone.Amount}}
+		for _, item := range refund.Items {
+			inc = append(inc, bson.E{Key: fmt.Sprintf("items.%d.refunded_quantity", item.Line), Value: -int64(item.Quantity)})
+		}
+		if !cancelled {
+			for _, card := range refund.GiftCards {
+				inc = append(inc, bson.E{Key: fmt.Sprintf("gift_cards.%d.refunded.amount", giftCardIndex(*order, card.Card)), Value: -card.Amount.Amount})
+			}
+		}
+		return bson.D{
+			{Key: "$inc", Value: inc},
+			{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+		}, nil
+	})
+}
+
+// settleRefund moves a pending refund out of pending and applies the order
+// update built by settle in one transaction.
+func settleRefund(ctx context.Context, str store.Mongo, refundID primitive.ObjectID, settle func(ctx mongo.SessionContext, refund *store.Refund, order *store.Order) (bson.D, error)) (store.Refund, store.Order, error) {
+	session, err := str.TxnStartSession(ctx)
+	if err != nil {
+		return store.Refund{}, store.Order{}, err
+	}
+	defer session.EndSession(ctx)
+
+	type outcome struct {
+		refund store.Refund
+		order  store.Order
+	}
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		ordColl := str.Collection(ctx, "coffeeshop", "orders")
+		refColl := str.Collection(ctx, "coffeeshop", "refunds")
+
+		var refund store.Refund
+		err := refColl.FindOne(ctx, bson.D{{Key: "_id", Value: refundID}}).Decode(&refund)
+		if err != nil {
+			return nil, err
+		}
+		if refund.Status != string(types.REFUND_PENDING) {
+			return nil, ErrRefundNotPending
+		}
+
+		var order store.Order
+		err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: refund.Order}}).Decode(&order)
+		if err != nil {
+			return nil, err
+		}
+
+		update, err := settle(ctx, &refund, &order)
+		if err != nil {
+			return nil, err
+		}
+
+		refund.UpdatedAt = time.Now()
+		result, err := refColl.ReplaceOne(ctx, bson.D{
+			{Key: "_id", Value: refund.Id},
+			{Key: "status", Value: string(types.REFUND_PENDING)},
+		}, refund)
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount == 0 {
+			return nil, ErrRefundNotPending
+		}
+
+		err = ordColl.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: order.Id}}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&order)
+		if err != nil {
+			return nil, err
+		}
+		return outcome{refund, order}, nil
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return store.Refund{}, store.Order{}, err
+	}
+
+	result := response.(outcome)
+	return result.refund, result.order, nil
+}
+
+func refundedItems(refund store.Refund) []store.OrderItem {
+	items := make([]store.OrderItem, 0, len(refund.Items))
+	for _, item := range refund.Items {
+		items = append(items, store.OrderItem{Product: item.Product, Quantity: item.Quantity})
+	}
+	return items
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const DefaultReservationDuration = 90 * time.Minute
+
+var ErrNoTableAvailable = errors.New("no table available for the requested time")
+
+// OverlappingReservations matches the confirmed reservations of table that
+// intersect [startsAt, endsAt). Back to back bookings do not overlap.
+func OverlappingReservations(table primitive.ObjectID, startsAt, endsAt time.Time) bson.D {
+	return bson.D{
+		{Key: "table", Value: table},
+		{Key: "status", Value: string(types.RESERVATION_CONFIRMED)},
+		{Key: "starts_at", Value: bson.D{{Key: "$lt", Value: endsAt}}},
+		{Key: "ends_at", Value: bson.D{{Key: "$gt", Value: startsAt}}},
+	}
+}
+
+// BookTable assigns reservation to the first candidate table that is free for
+// its whole slot and inserts it. It must run inside a transaction: every
+// table tried is written to first, so two parties racing for the same table
+// conflict and the retried transaction sees the booking that won.
+func BookTable(ctx context.Context, tables, reservations *mongo.Collection, candidates []store.CoffeeDateTable, reservation store.Reservation) (store.Reservation, error) {
+	for _, table := range candidates {
+		_, err := tables.UpdateByID(ctx, table.Id, bson.D{{Key: "$set", Value: bson.D{{Key: "booking_lock", Value: reservation.Id}}}})
+		if err != nil {
+			return store.Reservation{}, err
+		}
+
+		overlapping, err := reservations.CountDocuments(ctx, OverlappingReservations(table.Id, reservation.StartsAt, reservation.EndsAt))
+		if err != nil {
+			return store.Reservation{}, err
+		}
+		if overlapping > 0 {
+			continue
+		}
+
+		reservation.Table = table.Id
+		_, err = reservations.InsertOne(ctx, reservation)
+		if err != nil {
+			return store.Reservation{}, err
+		}
+		return reservation, nil
+	}
+	return store.Reservation{}, ErrNoTableAvailable
+}
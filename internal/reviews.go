@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RecomputeProductRatings recalculates a product's average rating and review
+// count from its published reviews. Run it in the same transaction as the
+// review change so concurrent reviews conflict on the product document
+// instead of overwriting each other's totals.
+func RecomputeProductRatings(ctx context.Context, reviews, products *mongo.Collection, product primitive.ObjectID) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "product", Value: product},
+			{Key: "status", Value: string(types.REVIEW_PUBLISHED)},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "average", Value: bson.D{{Key: "$avg", Value: "$rating"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cur, err := reviews.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var summary []struct {
+		Average float64 `bson:"average"`
+		Count   int64   `bson:"count"`
+	}
+	if err := cur.All(ctx, &summary); err != nil {
+		return err
+	}
+
+	var ratings float64
+	var count int64
+	if len(summary) > 0 {
+		ratings = math.Round(summary[0].Average*100) / 100
+		count = summary[0].Count
+	}
+
+	_, err = products.UpdateByID(ctx, product, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "ratings", Value: ratings},
+		{Key: "review_count", Value: count},
+		{Key: "updated_at", Value: time.Now()},
+	}}})
+	return err
+}
@@ -2,7 +2,6 @@ package internal
 
 import (
 	"context"
-	"crypto"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -14,6 +13,7 @@ import (
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/silaselisha/coffee-api/pkg/money"
 	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/types"
 	"github.com/spf13/viper"
@@ -72,11 +72,50 @@ func ResponseHandler(w http.ResponseWriter, message interface{}, statusCode int)
 func CreateNewProduct() store.Item {
 	product := store.Item{
 		Name:        "Caffe Latte",
-		Price:       4.50,
+		Price:       money.New(450, money.DefaultCurrency),
 		Description: "A cafe latte is a popular coffee drink that consists of espresso and steamed milk, topped with a thin layer of foam. It is perfect for those who enjoy a smooth and creamy coffee with a balanced flavor. At our coffee shop, we use high-quality beans and fresh milk to make our cafe lattes, and we can customize them with different syrups, spices, or whipped cream. ☕",
 		Summary:     "A cafe latte is a coffee drink made with espresso and steamed milk, with a thin layer of foam on top. It has a smooth and creamy taste, and can be customized with different flavors. Our coffee shop offers high-quality and fresh cafe lattes for any occasion.🍵",
 		Category:    "beverages",
 		Ingridients: []string{"Espresso", "Milk", "Falvored syrup"},
+		Modifiers: []store.ModifierGroup{
+			{
+				Name:          "Size",
+				Required:      true,
+				MinSelections: 1,
+				MaxSelections: 1,
+				Options: []store.ModifierOption{
+					{Name: "Small", PriceDelta: money.Zero(money.DefaultCurrency)},
+					{Name: "Medium", PriceDelta: money.New(50, money.DefaultCurrency)},
+					{Name: "Large", PriceDelta: money.New(100, money.DefaultCurrency)},
+				},
+			},
+			{
+				Name:          "Milk",
+				MaxSelections: 1,
+				Options: []store.ModifierOption{
+					{Name: "Whole", PriceDelta: money.Zero(money.DefaultCurrency)},
+					{Name: "Oat", PriceDelta: money.New(60, money.DefaultCurrency)},
+					{Name: "Almond", PriceDelta: money.New(60, money.DefaultCurrency)},
+				},
+			},
+			{
+				Name:          "Syrup",
+				MaxSelections: 2,
+				Options: []store.ModifierOption{
+					{Name: "Vanilla", PriceDelta: money.New(50, money.DefaultCurrency)},
+					{Name: "Caramel", PriceDelta: money.New(50, money.DefaultCurrency)},
+					{Name: "Hazelnut", PriceDelta: money.New(50, money.DefaultCurrency)},
+				},
+			},
+			{
+				Name:          "Extra shots",
+				MaxSelections: 1,
+				Options: []store.ModifierOption{
+					{Name: "One extra shot", PriceDelta: money.New(80, money.DefaultCurrency)},
+					{Name: "Two extra shots", PriceDelta: money.New(160, money.DefaultCurrency)},
+				},
+			},
+		},
 	}
 
 	return product
@@ -93,15 +132,6 @@ func CreateNewUser(email, name, phoneNumber, role string) store.User {
 	return user
 }
 
-func PasswordEncryption(password []byte) string {
-	return fmt.Sprintf("%x", crypto.SHA256.New().Sum(password))
-}
-
-func ComparePasswordEncryption(password, comparePassword string) bool {
-	hash := fmt.Sprintf("%x", crypto.SHA256.New().Sum([]byte(password)))
-	return hash == comparePassword
-}
-
 func genObjectToken() (string, error) {
 	buff := make([]byte, 16)
 	_, err := rand.Read(buff)
@@ -153,7 +183,7 @@ func NewErrorResponse(status string, err string) *types.ErrorResParams {
 	}
 }
 
-func ReadReqBody[T types.UserReqParams | types.OrderParams | types.UserLoginParams | types.ForgotPasswordParams | types.PasswordResetParams](data io.ReadCloser, sanitizer *validator.Validate) (payload T, err error) {
+func ReadReqBody[T types.UserReqParams | types.OrderParams | types.OrderStatusParams | types.OrderCancelParams | types.ReviewParams | types.ReviewModerationParams | types.TableParams | types.ReservationParams | types.PaymentParams | types.RefundParams | types.PromotionParams | types.GiftCardParams | types.TaxRuleParams | types.LocationParams | types.LocationProductParams | types.UserLoginParams | types.RefreshTokenParams | types.LogoutParams | types.StreamTicketParams | types.ForgotPasswordParams | types.PasswordResetParams](data io.ReadCloser, sanitizer *validator.Validate) (payload T, err error) {
 	payloadBytes, err := io.ReadAll(data)
 	if err != nil {
 		if err == io.EOF {
@@ -198,6 +228,9 @@ func ExtractProductsID(orders types.OrderParams) ([]primitive.ObjectID, []store.
 			Product: id,
 			Quantity: order.Quantity,
 		}
+		for _, modifier := range order.Modifiers {
+			item.Modifiers = append(item.Modifiers, store.SelectedModifier{Group: modifier.Group, Option: modifier.Option})
+		}
 
 		productsIds = append(productsIds, id)
 		products = append(products, item)
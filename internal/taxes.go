@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoadTaxRules returns the configured tax rules. While none are configured
+// menu prices are taken to include VAT at fallbackRate basis points.
+func LoadTaxRules(ctx context.Context, collection *mongo.Collection, fallbackRate uint32) ([]store.TaxRule, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rules []store.TaxRule
+	if err := cur.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 && fallbackRate > 0 {
+		rules = append(rules, store.TaxRule{Name: "VAT", Rate: fallbackRate, Inclusive: true})
+	}
+	return rules, nil
+}
+
+// TaxRulesFor picks the rules that tax a product of category sold at
+// location: of every tax name, the rule for both the location and the
+// category wins over the location's rule, which wins over the category's
+// rule, which wins over the rule for everything.
+func TaxRulesFor(rules []store.TaxRule, category, location string) []store.TaxRule {
+	var names []string
+	best := make(map[string]store.TaxRule)
+	score := make(map[string]int)
+	for _, rule := range rules {
+		if rule.Category != "" && rule.Category != category || rule.Location != "" && rule.Location != location {
+			continue
+		}
+
+		specificity := 0
+		if rule.Location != "" {
+			specificity += 2
+		}
+		if rule.Category != "" {
+			specificity++
+		}
+
+		current, ok := score[rule.Name]
+		if !ok {
+			names = append(names, rule.Name)
+		}
+		if !ok || specificity > current {
+			best[rule.Name] = rule
+			score[rule.Name] = specificity
+		}
+	}
+
+	applicable := make([]store.TaxRule, 0, len(names))
+	for _, name := range names {
+		applicable = append(applicable, best[name])
+	}
+	return applicable
+}
+
+// ApplyTaxes works out the taxes of the priced order lines, each line being
+// worth its amount after discounts. Inclusive taxes are extracted from that
+// worth; exclusive taxes are charged on top of what is left once inclusive
+// taxes are taken out and are recorded on the line as its Tax. The lines are
+// updated in place. It returns one tax line per tax and rate, and the total
+// added by exclusive taxes.
+func ApplyTaxes(items []store.OrderItem, products map[primitive.ObjectID]store.Item, rules []store.TaxRule, location, currency string) ([]store.TaxLine, money.Money) {
+	type taxKey struct {
+		name      string
+		rate      uint32
+		inclusive bool
+	}
+
+	var keys []taxKey
+	lines := make(map[taxKey]store.TaxLine)
+	added := money.Zero(currency)
+	for idx, item := range items {
+		items[idx].Tax = money.Zero(currency)
+		applicable := TaxRulesFor(rules, products[item.Product].Category, location)
+
+		var inclusiveRate int64
+		for _, rule := range applicable {
+			if rule.Inclusive {
+				inclusiveRate += int64(rule.Rate)
+			}
+		}
+
+		net := item.Amount.Sub(item.Discount)
+		// the line's worth without any of the taxes included in it
+		base := net.Sub(net.Ratio(inclusiveRate, 10000+inclusiveRate))
+
+		for _, rule := range applicable {
+			var tax money.Money
+			if rule.Inclusive {
+				tax = net.Ratio(int64(rule.Rate), 10000+inclusiveRate)
+			} else {
+				tax = base.Ratio(int64(rule.Rate), 10000)
+				items[idx].Tax = items[idx].Tax.Add(tax)
+				added = added.Add(tax)
+			}
+
+			key := taxKey{rule.Name, rule.Rate, rule.Inclusive}
+			line, ok := lines[key]
+			if !ok {
+				keys = append(keys, key)
+				line = store.TaxLine{
+					Name:      rule.Name,
+					Rate:      rule.Rate,
+					Inclusive: rule.Inclusive,
+					Taxable:   money.Zero(currency),
+					Amount:    money.Zero(currency),
+				}
+			}
+			line.Taxable = line.Taxable.Add(base)
+			line.Amount = line.Amount.Add(tax)
+			lines[key] = line
+		}
+	}
+
+	taxes := make([]store.TaxLine, 0, len(keys))
+	for _, key := range keys {
+		taxes = append(taxes, lines[key])
+	}
+	return taxes, added
+}
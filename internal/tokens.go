@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// TokenLifetimes says how long access and refresh tokens are valid.
+type TokenLifetimes struct {
+	Access  time.Duration
+	Refresh time.Duration
+}
+
+// ParseTokenLifetimes reads ACCESS_TOKEN_MINUTES, 15 when not set, and
+// JWT_EXPIRES_AT, the days a refresh token lasts.
+func ParseTokenLifetimes(envs *types.Config) (TokenLifetimes, error) {
+	minutes := 15
+	if envs.ACCESS_TOKEN_MINUTES != "" {
+		number, err := strconv.Atoi(envs.ACCESS_TOKEN_MINUTES)
+		if err != nil || number <= 0 {
+			return TokenLifetimes{}, fmt.Errorf("invalid access token minutes %q", envs.ACCESS_TOKEN_MINUTES)
+		}
+		minutes = number
+	}
+
+	days, err := strconv.Atoi(envs.JWT_EXPIRES_AT)
+	if err != nil || days <= 0 {
+		return TokenLifetimes{}, fmt.Errorf("invalid jwt expires at %q", envs.JWT_EXPIRES_AT)
+	}
+
+	return TokenLifetimes{
+		Access:  time.Duration(minutes) * time.Minute,
+		Refresh: time.Duration(days) * 24 * time.Hour,
+	}, nil
+}
+
+// HashRefreshToken returns what is stored of a refresh token. The tokens are
+// random, so a plain digest is enough to make a leaked collection useless.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	refreshTokenIndexMu      sync.Mutex
+	refreshTokenIndexesReady bool
+)
+
+// ensureRefreshTokenIndexes creates the unique index tokens are looked up by
+// and the TTL index that drops them once expired, once per process. Indexes
+// cannot be created inside a transaction, so it runs before one starts.
+func ensureRefreshTokenIndexes(ctx context.Context, collection *mongo.Collection) error {
+	refreshTokenIndexMu.Lock()
+	defer refreshTokenIndexMu.Unlock()
+	if refreshTokenIndexesReady {
+		return nil
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	refreshTokenIndexesReady = true
+	return nil
+}
+
+// IssueRefreshToken creates a refresh token for user in family, a new one
+// when family is zero.
+func IssueRefreshToken(ctx context.Context, str store.Mongo, user, family primitive.ObjectID, lifetime time.Duration, now time.Time) (string, store.RefreshToken, error) {
+	collection := str.Collection(ctx, "coffeeshop", "refresh_tokens")
+	if err := ensureRefreshTokenIndexes(ctx, collection); err != nil {
+		return "", store.RefreshToken{}, err
+	}
+	return insertRefreshToken(ctx, collection, user, family, lifetime, now)
+}
+
+func insertRefreshToken(ctx context.Context, collection *mongo.Collection, user, family primitive.ObjectID, lifetime time.Duration, now time.Time) (string, store.RefreshToken, error) {
+	buff := make([]byte, 32)
+	if _, err := rand.Read(buff); err != nil {
+		return "", store.RefreshToken{}, fmt.Errorf("failed to generate random bytes %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buff)
+
+	if family.IsZero() {
+		family = primitive.NewObjectID()
+	}
+	refresh := store.RefreshToken{
+		Id:        primitive.NewObjectID(),
+		Hash:      HashRefreshToken(token),
+		User:      user,
+		Family:    family,
+		ExpiresAt: now.Add(lifetime),
+		CreatedAt: now,
+	}
+	_, err := collection.InsertOne(ctx, refresh)
+	if err != nil {
+		return "", store.RefreshToken{}, err
+	}
+	return token, refresh, nil
+}
+
+// RotateRefreshToken spends token and issues its successor in the same
+// family in one transaction, so a token is never spent without a successor.
+// Spending is atomic, so of two requests racing with the same token only one
+// gets a successor. A token that was already spent is returned with
+// ErrRefreshTokenReused so its family can be revoked.
+func RotateRefreshToken(ctx context.Context, str store.Mongo, token string, lifetime time.Duration, now time.Time) (string, store.RefreshToken, error) {
+	collection := str.Collection(ctx, "coffeeshop", "refresh_tokens")
+	if err := ensureRefreshTokenIndexes(ctx, collection); err != nil {
+		return "", store.RefreshToken{}, err
+	}
+
+	session, err := str.TxnStartSession(ctx)
+	if err != nil {
+		return "", store.RefreshToken{}, err
+	}
+	defer session.EndSession(ctx)
+
+	type rotation struct {
+		token   string
+		refresh store.RefreshToken
+	}
+
+	hash := HashRefreshToken(token)
+	var current store.RefreshToken
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		collection := str.Collection(ctx, "coffeeshop", "refresh_tokens")
+
+		err := collection.FindOneAndUpdate(ctx, bson.D{
+			{Key: "hash", Value: hash},
+			{Key: "used_at", Value: bson.D{{Key: "$exists", Value: false}}},
+			{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+			{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}},
+		}, bson.D{{Key: "$set", Value: bson.D{{Key: "used_at", Value: now}}}}).Decode(&current)
+		if err != nil {
+			return nil, err
+		}
+
+		next, refresh, err := insertRefreshToken(ctx, collection, current.User, current.Family, lifetime, now)
+		if err != nil {
+			return nil, err
+		}
+		return rotation{next, refresh}, nil
+	}, &options.TransactionOptions{})
+	if err == nil {
+		result := response.(rotation)
+		return result.token, result.refresh, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", store.RefreshToken{}, err
+	}
+
+	err = collection.FindOne(ctx, bson.D{{Key: "hash", Value: hash}}).Decode(&current)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", store.RefreshToken{}, ErrRefreshTokenInvalid
+		}
+		return "", store.RefreshToken{}, err
+	}
+	if !current.UsedAt.IsZero() {
+		return "", current, ErrRefreshTokenReused
+	}
+	// expired or revoked
+	return "", store.RefreshToken{}, ErrRefreshTokenInvalid
+}
+
+// RevokeRefreshTokenFamily revokes every token of a user's family, ending
+// that login on all devices holding one of them.
+func RevokeRefreshTokenFamily(ctx context.Context, collection *mongo.Collection, user, family primitive.ObjectID, now time.Time) error {
+	_, err := collection.UpdateMany(ctx, bson.D{
+		{Key: "user", Value: user},
+		{Key: "family", Value: family},
+		{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}, bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: now}}}})
+	return err
+}
+
+// RevokeRefreshToken ends the login a refresh token of user belongs to.
+// Unknown tokens are ignored.
+func RevokeRefreshToken(ctx context.Context, collection *mongo.Collection, user primitive.ObjectID, token string, now time.Time) error {
+	var refresh store.RefreshToken
+	err := collection.FindOne(ctx, bson.D{{Key: "hash", Value: HashRefreshToken(token)}, {Key: "user", Value: user}}).Decode(&refresh)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+	return RevokeRefreshTokenFamily(ctx, collection, user, refresh.Family, now)
+}
+
+// RevokeUserRefreshTokens ends every login of a user, e.g. once their
+// password changed.
+func RevokeUserRefreshTokens(ctx context.Context, collection *mongo.Collection, user primitive.ObjectID, now time.Time) error {
+	_, err := collection.UpdateMany(ctx, bson.D{
+		{Key: "user", Value: user},
+		{Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}, bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: now}}}})
+	return err
+}
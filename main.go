@@ -41,6 +41,7 @@ func main() {
 	}()
 
 	go taskProcessor(redisOpts, server.Store, *envs, coffeeShopS3Bucket)
+	go taskScheduler(redisOpts)
 
 	fmt.Printf("serving HTTP/REST server\n")
 	fmt.Printf("http://localhost:%v/\n", envs.SERVER_REST_ADDRESS)
@@ -91,3 +92,13 @@ func taskProcessor(opts asynq.RedisClientOpt, store store.Mongo, envs types.Conf
 		return
 	}
 }
+
+func taskScheduler(opts asynq.RedisClientOpt) {
+	scheduler := workers.NewTaskScheduler(opts)
+	log.Print("task scheduler on")
+	err := scheduler.Start()
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+}
@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker fans messages out to every subscriber of a channel, whichever API
+// instance or worker they run in. Delivery is best effort: subscribers that
+// must not miss anything reload their state from the database when notified.
+type Broker interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+}
+
+type Subscription interface {
+	// Messages is closed when the subscription is closed.
+	Messages() <-chan []byte
+	Close() error
+}
+
+// OrderChannel is where changes to an order are announced.
+func OrderChannel(orderID string) string {
+	return fmt.Sprintf("orders:%s:events", orderID)
+}
+
+// KitchenChannel is where orders added to or moving through the kitchen
+// queue are announced, by id.
+const KitchenChannel = "kitchen:events"
+
+type RedisBroker struct {
+	client *redis.Client
+}
+
+func NewRedisBroker(opts *redis.Options) Broker {
+	return &RedisBroker{client: redis.NewClient(opts)}
+}
+
+func (broker *RedisBroker) Publish(ctx context.Context, channel string, message []byte) error {
+	return broker.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe returns once Redis has confirmed the subscription, so anything
+// published afterwards is delivered.
+func (broker *RedisBroker) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	pubsub := broker.client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	sub := &redisSubscription{pubsub: pubsub, messages: make(chan []byte), done: make(chan struct{})}
+	go func() {
+		defer close(sub.messages)
+		for message := range pubsub.Channel() {
+			select {
+			case sub.messages <- []byte(message.Payload):
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan []byte
+	done     chan struct{}
+}
+
+func (sub *redisSubscription) Messages() <-chan []byte {
+	return sub.messages
+}
+
+func (sub *redisSubscription) Close() error {
+	close(sub.done)
+	return sub.pubsub.Close()
+}
@@ -0,0 +1,170 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const DefaultCurrency = "USD"
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid money amount")
+)
+
+// minorUnitDigits lists currencies whose minor unit is not a hundredth.
+var minorUnitDigits = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"UGX": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Money is an amount expressed in the minor unit of its ISO 4217 currency,
+// e.g. 450 USD is $4.50.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Digits returns how many decimal places the currency's minor unit has.
+func Digits(currency string) int {
+	digits, ok := minorUnitDigits[strings.ToUpper(currency)]
+	if !ok {
+		return 2
+	}
+	return digits
+}
+
+// Factor is the number of minor units in one major unit of the currency.
+func Factor(currency string) int64 {
+	factor := int64(1)
+	for i := 0; i < Digits(currency); i++ {
+		factor *= 10
+	}
+	return factor
+}
+
+// Parse reads a decimal major unit amount such as "4.50" or "4.5E+00" without
+// going through float64. Amounts finer than the currency's minor unit are
+// rejected rather than rounded.
+func Parse(value, currency string) (Money, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, value)
+	}
+
+	rat.Mul(rat, new(big.Rat).SetInt64(Factor(currency)))
+	if !rat.IsInt() || !rat.Num().IsInt64() {
+		return Money{}, fmt.Errorf("%w %q for %s", ErrInvalidAmount, value, currency)
+	}
+	return New(rat.Num().Int64(), currency), nil
+}
+
+// Add returns m + other. Both values must share a currency; callers check
+// this once at the boundary with SameCurrency, so a mismatch here is a bug
+// and panics with ErrCurrencyMismatch.
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.sumCurrency(other)}
+}
+
+// Sub returns m - other and panics like Add on a currency mismatch.
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.sumCurrency(other)}
+}
+
+// sumCurrency is the currency of a sum of m and other. A zero Money{}, as
+// stored before a field was set, takes the currency of the other operand.
+func (m Money) sumCurrency(other Money) string {
+	switch {
+	case m.Currency == other.Currency:
+		return m.Currency
+	case m.Currency == "" && m.Amount == 0:
+		return other.Currency
+	case other.Currency == "" && other.Amount == 0:
+		return m.Currency
+	}
+	panic(fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m, other))
+}
+
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// Percent returns percent% of m rounded to the nearest minor unit, with
+// halves rounded away from zero. Discounts are computed once per order line
+// so rounding never accumulates across units.
+func (m Money) Percent(percent uint32) Money {
+	return m.Ratio(int64(percent), 100)
+}
+
+// Ratio returns m * numerator / denominator using the same rounding as
+// Percent.
+func (m Money) Ratio(numerator, denominator int64) Money {
+	product := m.Amount * numerator
+	quotient := product / denominator
+	remainder := product % denominator
+	if remainder < 0 {
+		remainder = -remainder
+	}
+
+	if remainder*2 >= denominator {
+		if product < 0 {
+			quotient--
+		} else {
+			quotient++
+		}
+	}
+	return Money{Amount: quotient, Currency: m.Currency}
+}
+
+func (m Money) Min(other Money) Money {
+	if other.Amount < m.Amount {
+		return other
+	}
+	return m
+}
+
+func (m Money) SameCurrency(other Money) bool {
+	return m.Currency == other.Currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Decimal formats the amount in major units, e.g. "4.50".
+func (m Money) Decimal() string {
+	digits := Digits(m.Currency)
+	if digits == 0 {
+		return fmt.Sprintf("%d", m.Amount)
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	factor := Factor(m.Currency)
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/factor, digits, amount%factor)
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.Decimal(), m.Currency)
+}
@@ -0,0 +1,106 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatio(t *testing.T) {
+	testCases := []struct {
+		name        string
+		amount      int64
+		numerator   int64
+		denominator int64
+		want        int64
+	}{
+		{name: "exact", amount: 300, numerator: 1, denominator: 3, want: 100},
+		{name: "below half rounds down", amount: 100, numerator: 1, denominator: 3, want: 33},
+		{name: "above half rounds up", amount: 200, numerator: 1, denominator: 3, want: 67},
+		{name: "half rounds up", amount: 5, numerator: 1, denominator: 2, want: 3},
+		{name: "negative half rounds down", amount: -5, numerator: 1, denominator: 2, want: -3},
+		{name: "negative below half rounds up", amount: -100, numerator: 1, denominator: 3, want: -33},
+		{name: "negative above half rounds down", amount: -200, numerator: 1, denominator: 3, want: -67},
+		{name: "zero", amount: 0, numerator: 7, denominator: 9, want: 0},
+		{name: "inclusive tax share", amount: 1160, numerator: 1600, denominator: 11600, want: 160},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := New(tc.amount, "usd").Ratio(tc.numerator, tc.denominator)
+			require.Equal(t, New(tc.want, DefaultCurrency), got)
+		})
+	}
+}
+
+func TestPercent(t *testing.T) {
+	testCases := []struct {
+		name    string
+		amount  int64
+		percent uint32
+		want    int64
+	}{
+		{name: "none", amount: 450, percent: 0, want: 0},
+		{name: "all", amount: 450, percent: 100, want: 450},
+		{name: "exact", amount: 450, percent: 20, want: 90},
+		{name: "half rounds up", amount: 50, percent: 15, want: 8},
+		{name: "below half rounds down", amount: 333, percent: 10, want: 33},
+		{name: "negative half rounds down", amount: -50, percent: 15, want: -8},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, New(tc.want, DefaultCurrency), New(tc.amount, DefaultCurrency).Percent(tc.percent))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		currency string
+		want     Money
+		err      error
+	}{
+		{name: "cents", value: "4.50", currency: "USD", want: New(450, "USD")},
+		{name: "whole", value: "4", currency: "USD", want: New(400, "USD")},
+		{name: "exponent", value: "4.5E+00", currency: "USD", want: New(450, "USD")},
+		{name: "surrounding space", value: " 4.5 ", currency: "USD", want: New(450, "USD")},
+		{name: "negative", value: "-0.05", currency: "USD", want: New(-5, "USD")},
+		{name: "lower case currency", value: "1.25", currency: "kes", want: New(125, "KES")},
+		{name: "no minor unit", value: "1200", currency: "JPY", want: New(1200, "JPY")},
+		{name: "thousandths", value: "1.005", currency: "KWD", want: New(1005, "KWD")},
+		{name: "finer than cents", value: "4.505", currency: "USD", err: ErrInvalidAmount},
+		{name: "fraction of a yen", value: "1.5", currency: "JPY", err: ErrInvalidAmount},
+		{name: "out of range", value: "1e30", currency: "USD", err: ErrInvalidAmount},
+		{name: "not a number", value: "four", currency: "USD", err: ErrInvalidAmount},
+		{name: "empty", value: "", currency: "USD", err: ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.value, tc.currency)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestAddSub(t *testing.T) {
+	usd := New(450, "USD")
+
+	require.Equal(t, New(500, "USD"), usd.Add(New(50, "USD")))
+	require.Equal(t, New(400, "USD"), usd.Sub(New(50, "USD")))
+	// zero values stored before a field was set
+	require.Equal(t, usd, usd.Add(Money{}))
+	require.Equal(t, New(-450, "USD"), Money{}.Sub(usd))
+
+	require.PanicsWithError(t, "currency mismatch: 4.50 USD and 0.50 KES", func() { usd.Add(New(50, "KES")) })
+	require.Panics(t, func() { usd.Sub(New(50, "KES")) })
+	require.Panics(t, func() { usd.Add(Zero("KES")) })
+}
@@ -0,0 +1,209 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+)
+
+const (
+	FakeProviderName    = "fake"
+	FakeSignatureHeader = "Fake-Signature"
+)
+
+// Payment methods understood by the fake provider, in the spirit of card
+// networks' test numbers. Any other method is authorised.
+const (
+	FakeMethodDeclined = "fake_card_declined"
+	FakeMethodDelayed  = "fake_card_delayed"
+)
+
+// FakeProvider settles payments in memory. It signs its webhooks exactly as
+// VerifyWebhook expects them, so the whole webhook path can be exercised
+// locally with SignWebhook.
+type FakeProvider struct {
+	secret  []byte
+	mu      sync.Mutex
+	intents map[string]*Intent
+	refunds map[string]money.Money
+}
+
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{
+		secret:  []byte(secret),
+		intents: make(map[string]*Intent),
+		refunds: make(map[string]money.Money),
+	}
+}
+
+func (fp *FakeProvider) Name() string {
+	return FakeProviderName
+}
+
+func (fp *FakeProvider) CreateIntent(ctx context.Context, params IntentParams) (Intent, error) {
+	intent := &Intent{
+		Id:     fakeId("pi"),
+		Amount: params.Amount,
+		Status: INTENT_REQUIRES_CAPTURE,
+	}
+
+	switch params.PaymentMethod {
+	case FakeMethodDeclined:
+		intent.Status = INTENT_FAILED
+		intent.FailureReason = "card declined"
+	case FakeMethodDelayed:
+		intent.Status = INTENT_PROCESSING
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.intents[intent.Id] = intent
+	return *intent, nil
+}
+
+func (fp *FakeProvider) Capture(ctx context.Context, intentID string, amount money.Money) (Intent, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	intent, ok := fp.intents[intentID]
+	if !ok {
+		return Intent{}, fmt.Errorf("%w: %s", ErrIntentNotFound, intentID)
+	}
+	if intent.Status != INTENT_REQUIRES_CAPTURE {
+		return Intent{}, fmt.Errorf("intent %s is %s and cannot be captured", intentID, intent.Status)
+	}
+	if amount.Currency != intent.Amount.Currency || amount.Amount > intent.Amount.Amount {
+		return Intent{}, fmt.Errorf("cannot capture %s of an intent for %s", amount, intent.Amount)
+	}
+
+	intent.Amount = amount
+	intent.Status = INTENT_SUCCEEDED
+	return *intent, nil
+}
+
+func (fp *FakeProvider) Refund(ctx context.Context, intentID string, amount money.Money, reason string) (Refund, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	intent, ok := fp.intents[intentID]
+	if !ok {
+		return Refund{}, fmt.Errorf("%w: %s", ErrIntentNotFound, intentID)
+	}
+	if intent.Status != INTENT_SUCCEEDED {
+		return Refund{}, fmt.Errorf("intent %s is %s and cannot be refunded", intentID, intent.Status)
+	}
+
+	refunded := fp.refunds[intentID]
+	if refunded.Currency == "" {
+		refunded = money.Zero(intent.Amount.Currency)
+	}
+	if amount.Currency != intent.Amount.Currency || refunded.Add(amount).Amount > intent.Amount.Amount {
+		return Refund{}, fmt.Errorf("cannot refund %s, %s of %s already refunded", amount, refunded, intent.Amount)
+	}
+	fp.refunds[intentID] = refunded.Add(amount)
+
+	return Refund{Id: fakeId("re"), Intent: intentID, Amount: amount}, nil
+}
+
+// Settle decides an intent left processing by FakeMethodDelayed and returns
+// the event the provider would send about it.
+func (fp *FakeProvider) Settle(intentID string, succeeded bool) (Event, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	intent, ok := fp.intents[intentID]
+	if !ok {
+		return Event{}, fmt.Errorf("%w: %s", ErrIntentNotFound, intentID)
+	}
+
+	event := Event{Id: fakeId("evt"), Intent: intentID, Amount: intent.Amount, CreatedAt: time.Now()}
+	if succeeded {
+		intent.Status = INTENT_SUCCEEDED
+		event.Type = EVENT_PAYMENT_SUCCEEDED
+	} else {
+		intent.Status = INTENT_FAILED
+		intent.FailureReason = "payment failed"
+		event.Type = EVENT_PAYMENT_FAILED
+		event.FailureReason = intent.FailureReason
+	}
+	return event, nil
+}
+
+// SignWebhook serialises event and returns it with the signature header
+// value for a delivery at time at: "t=<unix seconds>,v1=<hex hmac>".
+func (fp *FakeProvider) SignWebhook(event Event, at time.Time) ([]byte, string, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return payload, fmt.Sprintf("t=%s,v1=%s", timestamp, fp.sign(timestamp, payload)), nil
+}
+
+func (fp *FakeProvider) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	var timestamp, signature string
+	for _, part := range strings.Split(header.Get(FakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == "" || signature == "" {
+		return Event{}, ErrInvalidSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, fp.mac(timestamp, payload)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Event{}, ErrInvalidSignature
+	}
+	drift := time.Since(time.Unix(seconds, 0))
+	if drift > WebhookTolerance || drift < -WebhookTolerance {
+		return Event{}, ErrStaleWebhook
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, fmt.Errorf("invalid webhook payload %w", err)
+	}
+	return event, nil
+}
+
+// mac signs the timestamp together with the payload so that a captured
+// delivery cannot be replayed later under a fresh timestamp.
+func (fp *FakeProvider) mac(timestamp string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, fp.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (fp *FakeProvider) sign(timestamp string, payload []byte) string {
+	return hex.EncodeToString(fp.mac(timestamp, payload))
+}
+
+func fakeId(prefix string) string {
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+	return fmt.Sprintf("fake_%s_%s", prefix, hex.EncodeToString(buf))
+}
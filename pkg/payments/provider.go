@@ -0,0 +1,92 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/types"
+)
+
+type IntentStatus string
+
+const (
+	// the customer's payment method was authorised and the funds are held
+	// until they are captured
+	INTENT_REQUIRES_CAPTURE IntentStatus = "requires_capture"
+	// the provider has not decided yet and will report back via webhook
+	INTENT_PROCESSING IntentStatus = "processing"
+	INTENT_SUCCEEDED  IntentStatus = "succeeded"
+	INTENT_FAILED     IntentStatus = "failed"
+)
+
+type EventType string
+
+const (
+	EVENT_PAYMENT_SUCCEEDED EventType = "payment.succeeded"
+	EVENT_PAYMENT_FAILED    EventType = "payment.failed"
+	EVENT_REFUND_SUCCEEDED  EventType = "refund.succeeded"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleWebhook     = errors.New("webhook timestamp outside tolerance")
+	ErrIntentNotFound   = errors.New("payment intent not found")
+)
+
+// WebhookTolerance is how far a webhook's signed timestamp may drift from
+// the current time before it is rejected as a possible replay.
+const WebhookTolerance = 5 * time.Minute
+
+type IntentParams struct {
+	Amount money.Money
+	// Reference identifies the payment on our side, normally the order id
+	Reference     string
+	PaymentMethod string
+}
+
+type Intent struct {
+	Id            string       `json:"id"`
+	Amount        money.Money  `json:"amount"`
+	Status        IntentStatus `json:"status"`
+	FailureReason string       `json:"failure_reason,omitempty"`
+}
+
+type Refund struct {
+	Id     string      `json:"id"`
+	Intent string      `json:"intent"`
+	Amount money.Money `json:"amount"`
+}
+
+// Event is a verified webhook notification from a provider.
+type Event struct {
+	Id            string      `json:"id"`
+	Type          EventType   `json:"type"`
+	Intent        string      `json:"intent"`
+	Refund        string      `json:"refund,omitempty"`
+	Amount        money.Money `json:"amount"`
+	FailureReason string      `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+}
+
+type Provider interface {
+	Name() string
+	CreateIntent(ctx context.Context, params IntentParams) (Intent, error)
+	Capture(ctx context.Context, intentID string, amount money.Money) (Intent, error)
+	Refund(ctx context.Context, intentID string, amount money.Money, reason string) (Refund, error)
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+}
+
+// NewProvider builds the provider named by PAYMENT_PROVIDER, defaulting to
+// the in-process fake so development needs no external account.
+func NewProvider(envs *types.Config) (Provider, error) {
+	switch envs.PAYMENT_PROVIDER {
+	case "", FakeProviderName:
+		return NewFakeProvider(envs.PAYMENT_WEBHOOK_SECRET), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", envs.PAYMENT_PROVIDER)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/events"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// orderEventsHeartbeat keeps idle streams from being closed by proxies.
+const orderEventsHeartbeat = 15 * time.Second
+
+// OrderEventsHandler streams an order's status changes as server-sent
+// events. The id of an event is the change's position in the order's status
+// history, so a client reconnecting with Last-Event-ID gets every change it
+// missed first. The stream ends once the order is completed, cancelled or
+// rejected.
+func (s *Server) OrderEventsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	sent := 0
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		sent, err = strconv.Atoi(lastEventID)
+		if err != nil || sent < 0 {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Sprintf("invalid Last-Event-ID %q", lastEventID)), http.StatusBadRequest)
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", "streaming is not supported"), http.StatusInternalServerError)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	// subscribe before reading the order so no change falls in between
+	sub, err := s.events.Subscribe(ctx, events.OrderChannel(id.Hex()))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer sub.Close()
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if order.Owner != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to follow their own orders")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+
+	send := func(order store.Order) error {
+		for ; sent < len(order.StatusHistory); sent++ {
+			change := order.StatusHistory[sent]
+			data, err := json.Marshal(types.OrderEventResParams{
+				Order:     order.Id.Hex(),
+				From:      change.From,
+				Status:    change.To,
+				Note:      change.Note,
+				ChangedAt: change.ChangedAt,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", sent+1, data)
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if err := send(order); err != nil || internal.OrderFinished(order.Status) {
+		return err
+	}
+
+	heartbeat := time.NewTicker(orderEventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(w, "event: heartbeat\ndata: {}\n\n")
+			flusher.Flush()
+		case _, ok := <-sub.Messages():
+			if !ok {
+				return nil
+			}
+
+			err := ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+			if err != nil {
+				return err
+			}
+			if err := send(order); err != nil || internal.OrderFinished(order.Status) {
+				return err
+			}
+		}
+	}
+}
+
+// announceOrder lets the order's event streams know it changed. The change
+// is already stored and streams catch up when they reconnect, so a failure
+// is only logged.
+func (s *Server) announceOrder(ctx context.Context, order store.Order) {
+	if err := internal.AnnounceOrder(ctx, s.events, order); err != nil {
+		log.Error().Err(err).Str("order", order.Id.Hex()).Msg("announcing order change failed")
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// giftCardCodeAttempts bounds how often a colliding random code is redrawn.
+const giftCardCodeAttempts = 3
+
+func (s *Server) CreateGiftCardHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	cardColl := s.Store.Collection(ctx, "coffeeshop", "giftcards")
+
+	giftCardPayload, err := internal.ReadReqBody[types.GiftCardParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	amount, err := money.Parse(giftCardPayload.Amount, s.currency())
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+	if amount.Amount <= 0 {
+		err := fmt.Errorf("%w: gift card amount must be positive", money.ErrInvalidAmount)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+	if !giftCardPayload.ExpiresAt.IsZero() && giftCardPayload.ExpiresAt.Before(time.Now()) {
+		err := errors.New("gift card cannot expire in the past")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	_, err = cardColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	session, err := s.Store.TxnStartSession(ctx)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer session.EndSession(ctx)
+
+	var card store.GiftCard
+	for attempt := 0; attempt < giftCardCodeAttempts; attempt++ {
+		code, codeErr := internal.NewGiftCardCode()
+		if codeErr != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", codeErr.Error()), http.StatusInternalServerError)
+		}
+
+		card = store.GiftCard{
+			Id:             primitive.NewObjectID(),
+			Code:           code,
+			InitialBalance: amount,
+			Balance:        amount,
+			Note:           giftCardPayload.Note,
+			IssuedBy:       userInfo.Id,
+			ExpiresAt:      giftCardPayload.ExpiresAt,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+			return nil, internal.IssueGiftCard(ctx, s.Store, card)
+		}, &options.TransactionOptions{})
+		if err == nil || !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string         `json:"status"`
+		Data   store.GiftCard `json:"data"`
+	}{
+		Status: "success",
+		Data:   card,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetAllGiftCardsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	cardColl := s.Store.Collection(ctx, "coffeeshop", "giftcards")
+
+	queries := r.URL.Query()
+	limit, err := internal.PageLimit(queries)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	filter := bson.D{}
+	if cursor := queries.Get("cursor"); cursor != "" {
+		lastID, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid cursor %w", err).Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: lastID}}})
+	}
+
+	// fetch one extra document to find out whether another page exists
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	cur, err := cardColl.Find(ctx, filter, opts)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	cards := []store.GiftCard{}
+	if err := cur.All(ctx, &cards); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var nextCursor string
+	if int64(len(cards)) > limit {
+		cards = cards[:limit]
+		nextCursor = cards[len(cards)-1].Id.Hex()
+	}
+
+	result := struct {
+		Status     string           `json:"status"`
+		Results    int32            `json:"results"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+		Data       []store.GiftCard `json:"data"`
+	}{
+		Status:     "success",
+		Results:    int32(len(cards)),
+		NextCursor: nextCursor,
+		Data:       cards,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// GetGiftCardBalanceHandler lets anyone holding a code look up what is left
+// on it.
+func (s *Server) GetGiftCardBalanceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	cardColl := s.Store.Collection(ctx, "coffeeshop", "giftcards")
+
+	params := mux.Vars(r)
+	card, err := internal.FindGiftCard(ctx, cardColl, params["code"])
+	if err != nil {
+		if errors.Is(err, internal.ErrGiftCardNotFound) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string `json:"status"`
+		Data   struct {
+			Code      string      `json:"code"`
+			Balance   money.Money `json:"balance"`
+			ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+		} `json:"data"`
+	}{Status: "success"}
+	result.Data.Code = internal.MaskGiftCardCode(card.Code)
+	result.Data.Balance = card.Balance
+	if !card.ExpiresAt.IsZero() {
+		result.Data.ExpiresAt = &card.ExpiresAt
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
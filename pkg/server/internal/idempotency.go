@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/pkg/token"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyLockTimeout is how long a request holds its key. A retry that
+// finds the key still in progress after that takes it over, so a request
+// whose instance died does not block the key until the record expires.
+const idempotencyLockTimeout = time.Minute
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// IdempotencyMiddleware replays the stored response when a request is retried
+// with the same Idempotency-Key header. Keys are scoped to the authenticated
+// user, so it must run after AuthMiddleware. Records expire after ttl.
+func IdempotencyMiddleware(str store.Mongo, ttl time.Duration) func(next http.Handler) http.Handler {
+	var mu sync.Mutex
+	var indexed bool
+
+	ensureIndex := func(ctx context.Context, collection *mongo.Collection) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if indexed {
+			return nil
+		}
+
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+		})
+		if err != nil {
+			return err
+		}
+		indexed = true
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > 255 {
+				http.Error(w, "idempotency key too long", http.StatusBadRequest)
+				return
+			}
+
+			collection := str.Collection(r.Context(), "coffeeshop", "idempotency_keys")
+			if err := ensureIndex(r.Context(), collection); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			payload := r.Context().Value(types.AuthPayloadKey{}).(*token.Payload)
+			id := fmt.Sprintf("%s:%s:%s:%s", payload.Id, r.Method, r.URL.Path, key)
+			requestHash := fmt.Sprintf("%x", sha256.Sum256(body))
+
+			now := time.Now()
+			record := store.IdempotencyRecord{
+				Id:          id,
+				RequestHash: requestHash,
+				LockedUntil: now.Add(idempotencyLockTimeout),
+				CreatedAt:   now,
+			}
+			_, err = collection.InsertOne(r.Context(), record)
+			if err != nil {
+				if !mongo.IsDuplicateKeyError(err) {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				err = collection.FindOne(r.Context(), bson.D{{Key: "_id", Value: id}}).Decode(&record)
+				if err != nil {
+					if errors.Is(err, mongo.ErrNoDocuments) {
+						err = errors.New("idempotency key expired while in use, retry the request")
+						http.Error(w, err.Error(), http.StatusConflict)
+						return
+					}
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				inProgress := errors.New("a request with this idempotency key is still in progress")
+				switch {
+				case record.RequestHash != requestHash:
+					err := errors.New("idempotency key already used for a different request")
+					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+					return
+				case !record.Completed && record.LockedUntil.After(now):
+					http.Error(w, inProgress.Error(), http.StatusConflict)
+					return
+				case !record.Completed:
+					// the request holding the key is presumed dead, of the
+					// retries racing for its lock only one wins
+					result, err := collection.UpdateOne(r.Context(), bson.D{
+						{Key: "_id", Value: id},
+						{Key: "completed", Value: false},
+						{Key: "locked_until", Value: record.LockedUntil},
+					}, bson.D{{Key: "$set", Value: bson.D{{Key: "locked_until", Value: now.Add(idempotencyLockTimeout)}}}})
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					if result.ModifiedCount == 0 {
+						http.Error(w, inProgress.Error(), http.StatusConflict)
+						return
+					}
+				default:
+					w.Header().Set("Content-Type", record.ContentType)
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(record.StatusCode)
+					w.Write(record.Body)
+					return
+				}
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// the record is settled even when the client has gone away
+			ctx := context.WithoutCancel(r.Context())
+
+			// server errors are not cached so the client can safely retry them
+			if recorder.statusCode >= http.StatusInternalServerError {
+				releaseIdempotencyKey(ctx, collection, id)
+				return
+			}
+
+			update := bson.D{{Key: "$set", Value: bson.D{
+				{Key: "completed", Value: true},
+				{Key: "status_code", Value: recorder.statusCode},
+				{Key: "content_type", Value: recorder.Header().Get("Content-Type")},
+				{Key: "body", Value: recorder.body.Bytes()},
+			}}}
+			_, err = collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+			if err != nil {
+				// without the response a retry could not be replayed; free
+				// the key rather than leave it in progress
+				log.Error().Err(err).Str("key", id).Msg("storing idempotent response failed")
+				releaseIdempotencyKey(ctx, collection, id)
+			}
+		})
+	}
+}
+
+// releaseIdempotencyKey forgets the record of a request that left nothing to
+// replay. Should that fail the lock still runs out after
+// idempotencyLockTimeout.
+func releaseIdempotencyKey(ctx context.Context, collection *mongo.Collection, id string) {
+	_, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		log.Error().Err(err).Str("key", id).Msg("releasing idempotency key failed")
+	}
+}
@@ -11,49 +11,138 @@ import (
 	"github.com/silaselisha/coffee-api/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func AuthMiddleware(tkn token.Token) func(next http.Handler) http.Handler {
+// AuthMiddleware accepts valid, unrevoked bearer tokens issued after the
+// user last changed their password.
+func AuthMiddleware(tkn token.Token, str store.Mongo) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authorizationHeader := r.Header.Get("authorization")
-			if len(authorizationHeader) == 0 {
-				http.Error(w, "invalid token header", http.StatusForbidden)
+			payload, ok := bearerPayload(w, r, tkn)
+			if !ok {
 				return
 			}
+			authenticate(w, r, str, payload, next)
+		})
+	}
+}
 
-			fields := strings.Split(authorizationHeader, " ")
-			if len(fields) < 2 {
-				http.Error(w, "invalid token header", http.StatusForbidden)
+// StreamAuthMiddleware authenticates like AuthMiddleware but also accepts a
+// ticket in the query string, since browsers cannot set an Authorization
+// header on EventSource and WebSocket requests. A ticket only opens the
+// path it was issued for.
+func StreamAuthMiddleware(tkn token.Token, str store.Mongo) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ticket := r.URL.Query().Get("ticket")
+			if ticket == "" {
+				payload, ok := bearerPayload(w, r, tkn)
+				if !ok {
+					return
+				}
+				authenticate(w, r, str, payload, next)
 				return
 			}
 
-			if strings.ToLower(fields[0]) != "bearer" {
-				http.Error(w, "invalid token header", http.StatusForbidden)
+			payload, err := tkn.VerifyToken(r.Context(), ticket)
+			if err != nil {
+				if errors.Is(err, token.ErrDenylistUnavailable) {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+				http.Error(w, "invalid ticket", http.StatusForbidden)
 				return
 			}
-
-			payload, err := tkn.VerifyToken(context.Background(), fields[1])
-			if err != nil {
-				http.Error(w, "invalid token", http.StatusForbidden)
+			if payload.Scope != r.URL.Path {
+				http.Error(w, "ticket not issued for this stream", http.StatusForbidden)
 				return
 			}
-
-			ctx := context.WithValue(r.Context(), types.AuthPayloadKey{}, payload)
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
+			authenticate(w, r, str, payload, next)
 		})
 	}
 }
 
+// bearerPayload verifies the access token in the Authorization header and
+// answers the request itself when there is none or it is not valid.
+func bearerPayload(w http.ResponseWriter, r *http.Request, tkn token.Token) (*token.Payload, bool) {
+	authorizationHeader := r.Header.Get("authorization")
+	if len(authorizationHeader) == 0 {
+		http.Error(w, "invalid token header", http.StatusForbidden)
+		return nil, false
+	}
+
+	fields := strings.Split(authorizationHeader, " ")
+	if len(fields) < 2 {
+		http.Error(w, "invalid token header", http.StatusForbidden)
+		return nil, false
+	}
+
+	if strings.ToLower(fields[0]) != "bearer" {
+		http.Error(w, "invalid token header", http.StatusForbidden)
+		return nil, false
+	}
+
+	payload, err := tkn.VerifyToken(r.Context(), fields[1])
+	if err != nil {
+		if errors.Is(err, token.ErrDenylistUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return nil, false
+		}
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return nil, false
+	}
+
+	// stream tickets travel in URLs and are no access tokens
+	if payload.Scope != "" {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return nil, false
+	}
+	return payload, true
+}
+
+// authenticate lets the request through as the token's user unless the
+// user is gone or changed their password after the token was issued.
+func authenticate(w http.ResponseWriter, r *http.Request, str store.Mongo, payload *token.Payload, next http.Handler) {
+	id, err := primitive.ObjectIDFromHex(payload.Id)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+
+	var user store.User
+	collection := str.Collection(r.Context(), "coffeeshop", "users")
+	opts := options.FindOne().SetProjection(bson.D{{Key: "password_changed_at", Value: 1}})
+	err = collection.FindOne(r.Context(), bson.D{{Key: "_id", Value: id}}, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if payload.IssuedAt.Before(user.PasswordChangedAt) {
+		http.Error(w, "token issued before the password was changed", http.StatusForbidden)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), types.AuthPayloadKey{}, payload)
+	r = r.WithContext(ctx)
+	next.ServeHTTP(w, r)
+}
+
 func RestrictToMiddleware(str store.Mongo, args ...string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var isAuthorized bool
 			var authorized map[string]string = map[string]string{}
 			roles := map[string]string{
-				"admin": "admin",
-				"user":  "user",
+				"admin":   "admin",
+				"barista": "barista",
+				"user":    "user",
 			}
 
 			for _, role := range args {
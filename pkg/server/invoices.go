@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (s *Server) GetInvoiceByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	invoice, res, code := s.findInvoice(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	result := struct {
+		Status string        `json:"status"`
+		Data   store.Invoice `json:"data"`
+	}{
+		Status: "success",
+		Data:   invoice,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) DownloadInvoiceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	invoice, res, code := s.findInvoice(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	// the stored copy is written by a worker shortly after the order is
+	// completed; until then, or if the bucket is unreachable, the same
+	// document is rendered on the spot
+	var data []byte
+	if invoice.ObjectKey != "" {
+		var err error
+		data, err = s.coffeeShopS3Bucket.DownloadObject(ctx, invoice.ObjectKey, s.envs.S3_BUCKET_NAME)
+		if err != nil {
+			log.Error().Err(err).Str("invoice", invoice.Number).Msg("falling back to rendering invoice")
+		}
+	}
+	if data == nil {
+		data = internal.InvoicePDF(invoice)
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%s.pdf\"", invoice.Number))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(data)
+	return err
+}
+
+// findInvoice loads the invoice named by the {id} route variable, which only
+// staff and the customer it was issued to may see. When it fails the error
+// response and status code to send are returned instead.
+func (s *Server) findInvoice(ctx context.Context, r *http.Request) (store.Invoice, *types.ErrorResParams, int) {
+	invColl := s.Store.Collection(ctx, "coffeeshop", "invoices")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return store.Invoice{}, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest
+	}
+
+	var invoice store.Invoice
+	err = invColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&invoice)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Invoice{}, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound
+		}
+		return store.Invoice{}, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	if invoice.Customer != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to retrieve their own invoices")
+		return store.Invoice{}, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden
+	}
+	return invoice, nil, http.StatusOK
+}
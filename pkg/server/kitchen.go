@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/events"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	kitchenFeedWriteWait = 10 * time.Second
+	kitchenFeedPongWait  = 60 * time.Second
+	// pings must go out well before the client is given up on
+	kitchenFeedPingPeriod = kitchenFeedPongWait * 9 / 10
+)
+
+// kitchenUpgrader accepts any origin like the API's CORS policy does; the
+// bearer token is what authenticates the feed.
+var kitchenUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// GetKitchenQueueHandler lists the open tickets, oldest first, optionally of
+// a single location.
+func (s *Server) GetKitchenQueueHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	location, err := kitchenLocation(r)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	tickets, err := s.kitchenQueue(ctx, location)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string                         `json:"status"`
+		Results int32                          `json:"results"`
+		Data    []types.KitchenTicketResParams `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(tickets)),
+		Data:    tickets,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// BumpKitchenTicketHandler moves a ticket to the next status, taking it off
+// the board once the order is completed.
+func (s *Server) BumpKitchenTicketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return s.moveKitchenTicket(ctx, w, r, internal.BumpStatus, "bumped")
+}
+
+// RecallKitchenTicketHandler moves a ticket that was bumped too early back a
+// status.
+func (s *Server) RecallKitchenTicketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return s.moveKitchenTicket(ctx, w, r, internal.RecallStatus, "recalled")
+}
+
+func (s *Server) moveKitchenTicket(ctx context.Context, w http.ResponseWriter, r *http.Request, next func(store.Order) (types.OrderStatus, error), note string) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	to, err := next(order)
+	if err == nil {
+		change := store.OrderStatusChange{
+			ChangedBy: userInfo.Id,
+			Role:      userInfo.Role,
+			Note:      note,
+		}
+		order, err = s.transitionOrder(ctx, order, to, change)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, internal.ErrNotInKitchen), errors.Is(err, internal.ErrInvalidOrderTransition), errors.Is(err, internal.ErrOrderStatusConflict):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		default:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	if !order.Invoice.IsZero() {
+		err = s.distributeInvoicePDF(ctx, order.Invoice)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	products, err := s.BatchGetAllProductsByIds(ctx, orderProducts(order))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string                       `json:"status"`
+		Data   types.KitchenTicketResParams `json:"data"`
+	}{
+		Status: "success",
+		Data:   internal.KitchenTicket(order, products),
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// KitchenFeedHandler upgrades to a WebSocket that sends the open tickets,
+// then every ticket added to the queue or changing status, optionally of a
+// single location. The feed is send only; messages from the client are
+// discarded.
+func (s *Server) KitchenFeedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	location, err := kitchenLocation(r)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	// subscribe before reading the queue so no ticket falls in between
+	sub, err := s.events.Subscribe(ctx, events.KitchenChannel)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer sub.Close()
+
+	tickets, err := s.kitchenQueue(ctx, location)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	conn, err := kitchenUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied
+		return err
+	}
+	defer conn.Close()
+
+	// reading keeps the pong deadline moving and notices the client leaving
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(kitchenFeedPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(kitchenFeedPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(message types.KitchenFeedMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(kitchenFeedWriteWait))
+		return conn.WriteJSON(message)
+	}
+
+	if err := send(types.KitchenFeedMessage{Type: "queue", Tickets: tickets}); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(kitchenFeedPingPeriod)
+	defer ping.Stop()
+
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+	for {
+		select {
+		case <-closed:
+			return nil
+		case <-ping.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(kitchenFeedWriteWait))
+			if err != nil {
+				return err
+			}
+		case message, ok := <-sub.Messages():
+			if !ok {
+				return nil
+			}
+
+			id, err := primitive.ObjectIDFromHex(string(message))
+			if err != nil {
+				continue
+			}
+
+			var order store.Order
+			err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+			if err != nil {
+				log.Error().Err(err).Str("order", id.Hex()).Msg("loading kitchen ticket failed")
+				continue
+			}
+			if !location.IsZero() && order.Location != location {
+				continue
+			}
+
+			products, err := s.BatchGetAllProductsByIds(ctx, orderProducts(order))
+			if err != nil {
+				log.Error().Err(err).Str("order", id.Hex()).Msg("loading kitchen ticket failed")
+				continue
+			}
+
+			ticket := internal.KitchenTicket(order, products)
+			if err := send(types.KitchenFeedMessage{Type: "ticket", Ticket: &ticket}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// kitchenQueue returns the open tickets oldest first.
+func (s *Server) kitchenQueue(ctx context.Context, location primitive.ObjectID) ([]types.KitchenTicketResParams, error) {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	opts := options.Find().SetSort(bson.D{{Key: "queued_at", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := ordColl.Find(ctx, internal.KitchenQueueFilter(location), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	orders := []store.Order{}
+	if err := cur.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	var productIds []primitive.ObjectID
+	for _, order := range orders {
+		productIds = append(productIds, orderProducts(order)...)
+	}
+	products, err := s.BatchGetAllProductsByIds(ctx, productIds)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets := make([]types.KitchenTicketResParams, 0, len(orders))
+	for _, order := range orders {
+		tickets = append(tickets, internal.KitchenTicket(order, products))
+	}
+	return tickets, nil
+}
+
+func kitchenLocation(r *http.Request) (primitive.ObjectID, error) {
+	location := r.URL.Query().Get("location")
+	if location == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(location)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("invalid location %w", err)
+	}
+	return id, nil
+}
+
+func orderProducts(order store.Order) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(order.Items))
+	for _, item := range order.Items {
+		ids = append(ids, item.Product)
+	}
+	return ids
+}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) CreateLocationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	locColl := s.Store.Collection(ctx, "coffeeshop", "locations")
+
+	locationPayload, err := internal.ReadReqBody[types.LocationParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	location := locationFromParams(locationPayload)
+	if err := internal.ValidateLocation(location); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	location.Id = primitive.NewObjectID()
+	location.CreatedAt = time.Now()
+	location.UpdatedAt = time.Now()
+
+	_, err = locColl.InsertOne(ctx, location)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string         `json:"status"`
+		Data   store.Location `json:"data"`
+	}{
+		Status: "success",
+		Data:   location,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetAllLocationsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	locColl := s.Store.Collection(ctx, "coffeeshop", "locations")
+
+	cur, err := locColl.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	locations := []store.Location{}
+	if err := cur.All(ctx, &locations); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string           `json:"status"`
+		Results int32            `json:"results"`
+		Data    []store.Location `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(locations)),
+		Data:    locations,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// GetLocationByIdHandler returns a location and whether it is open right now.
+func (s *Server) GetLocationByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	location, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	open, err := internal.LocationOpenAt(location, time.Now())
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string         `json:"status"`
+		Open   bool           `json:"open"`
+		Data   store.Location `json:"data"`
+	}{
+		Status: "success",
+		Open:   open,
+		Data:   location,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) UpdateLocationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	locColl := s.Store.Collection(ctx, "coffeeshop", "locations")
+
+	current, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	locationPayload, err := internal.ReadReqBody[types.LocationParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	location := locationFromParams(locationPayload)
+	if err := internal.ValidateLocation(location); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+	location.Id = current.Id
+	location.CreatedAt = current.CreatedAt
+	location.UpdatedAt = time.Now()
+
+	_, err = locColl.ReplaceOne(ctx, bson.D{{Key: "_id", Value: current.Id}}, location)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string         `json:"status"`
+		Data   store.Location `json:"data"`
+	}{
+		Status: "success",
+		Data:   location,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// DeleteLocationHandler removes a location and its menu overrides. Orders
+// placed there keep referring to it.
+func (s *Server) DeleteLocationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	location, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	session, err := s.Store.TxnStartSession(ctx)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		locColl := s.Store.Collection(ctx, "coffeeshop", "locations")
+		menuColl := s.Store.Collection(ctx, "coffeeshop", "location_products")
+
+		_, err := menuColl.DeleteMany(ctx, bson.D{{Key: "location", Value: location.Id}})
+		if err != nil {
+			return nil, err
+		}
+		_, err = locColl.DeleteOne(ctx, bson.D{{Key: "_id", Value: location.Id}})
+		return nil, err
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	return internal.ResponseHandler(w, "", http.StatusNoContent)
+}
+
+// SetLocationProductHandler takes a product off a location's menu or
+// changes its price there.
+func (s *Server) SetLocationProductHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	menuColl := s.Store.Collection(ctx, "coffeeshop", "location_products")
+	prodColl := s.Store.Collection(ctx, "coffeeshop", "products")
+
+	location, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	productID, err := primitive.ObjectIDFromHex(mux.Vars(r)["product"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	menuPayload, err := internal.ReadReqBody[types.LocationProductParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	var product store.Item
+	err = prodColl.FindOne(ctx, bson.D{{Key: "_id", Value: productID}}).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	override := store.LocationProduct{
+		Id:        primitive.NewObjectID(),
+		Location:  location.Id,
+		Product:   product.Id,
+		Available: menuPayload.Available,
+		UpdatedAt: time.Now(),
+	}
+	if menuPayload.Price != "" {
+		price, err := money.Parse(menuPayload.Price, s.currency())
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		if price.Amount <= 0 {
+			err := fmt.Errorf("%w: price must be positive", money.ErrInvalidAmount)
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		override.Price = price
+	}
+
+	_, err = menuColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "location", Value: 1}, {Key: "product", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	filter := bson.D{{Key: "location", Value: location.Id}, {Key: "product", Value: product.Id}}
+	err = menuColl.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "available", Value: override.Available},
+			{Key: "price", Value: override.Price},
+			{Key: "updated_at", Value: override.UpdatedAt},
+		}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: override.Id}}},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&override)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string                `json:"status"`
+		Data   store.LocationProduct `json:"data"`
+	}{
+		Status: "success",
+		Data:   override,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// DeleteLocationProductHandler puts a product back on a location's menu at
+// its own price.
+func (s *Server) DeleteLocationProductHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	menuColl := s.Store.Collection(ctx, "coffeeshop", "location_products")
+
+	location, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	productID, err := primitive.ObjectIDFromHex(mux.Vars(r)["product"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	result, err := menuColl.DeleteOne(ctx, bson.D{{Key: "location", Value: location.Id}, {Key: "product", Value: productID}})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if result.DeletedCount == 0 {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", mongo.ErrNoDocuments).Error()), http.StatusNotFound)
+	}
+	return internal.ResponseHandler(w, "", http.StatusNoContent)
+}
+
+// GetLocationMenuHandler lists the products sold at a location at the prices
+// charged there. ?category= narrows it to one category.
+func (s *Server) GetLocationMenuHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	prodColl := s.Store.Collection(ctx, "coffeeshop", "products")
+	menuColl := s.Store.Collection(ctx, "coffeeshop", "location_products")
+
+	location, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	cur, err := menuColl.Find(ctx, bson.D{{Key: "location", Value: location.Id}})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	var overrides []store.LocationProduct
+	if err := cur.All(ctx, &overrides); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	unavailable := []primitive.ObjectID{}
+	prices := make(map[primitive.ObjectID]money.Money)
+	for _, override := range overrides {
+		if !override.Available {
+			unavailable = append(unavailable, override.Product)
+		} else if override.Price.Currency != "" {
+			prices[override.Product] = override.Price
+		}
+	}
+
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$nin", Value: unavailable}}}}
+	if category := r.URL.Query().Get("category"); category != "" {
+		filter = append(filter, bson.E{Key: "category", Value: category})
+	}
+
+	cur, err = prodColl.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "category", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	products := store.ItemList{}
+	if err := cur.All(ctx, &products); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	for idx, product := range products {
+		if price, ok := prices[product.Id]; ok {
+			products[idx].Price = price
+		}
+	}
+
+	result := struct {
+		Status  string         `json:"status"`
+		Results int32          `json:"results"`
+		Data    store.ItemList `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(products)),
+		Data:    products,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// GetPickupSlotsHandler lists the pickup slots of a location on ?date=, a
+// day in the location's timezone that defaults to today, with the places
+// left in each. Slots too soon to prepare an order for are left out.
+func (s *Server) GetPickupSlotsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	slotColl := s.Store.Collection(ctx, "coffeeshop", "pickup_slots")
+
+	location, errRes, code := s.findLocation(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	rules, err := internal.ParsePickupRules(s.envs)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	tz, err := time.LoadLocation(location.Timezone)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	now := time.Now()
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = now.In(tz).Format("2006-01-02")
+	}
+
+	starts, err := internal.PickupSlots(location, date, rules)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	slots := []types.PickupSlotResParams{}
+	if len(starts) > 0 {
+		booked, err := internal.BookedPickupSlots(ctx, slotColl, location.Id, starts[0], starts[len(starts)-1].Add(rules.SlotLength))
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+
+		for _, start := range starts {
+			if start.Add(rules.SlotLength).Before(now.Add(rules.PrepTime)) || start.After(now.Add(internal.MaxPickupAhead)) {
+				continue
+			}
+			available := rules.SlotCapacity - booked[internal.PickupSlotId(location.Id, start)]
+			if available < 0 {
+				available = 0
+			}
+			slots = append(slots, types.PickupSlotResParams{
+				StartsAt:  start,
+				EndsAt:    start.Add(rules.SlotLength),
+				Available: available,
+			})
+		}
+	}
+
+	result := struct {
+		Status  string                      `json:"status"`
+		Results int32                       `json:"results"`
+		Data    []types.PickupSlotResParams `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(slots)),
+		Data:    slots,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) findLocation(ctx context.Context, r *http.Request) (store.Location, *types.ErrorResParams, int) {
+	locColl := s.Store.Collection(ctx, "coffeeshop", "locations")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		return store.Location{}, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest
+	}
+
+	location, err := internal.FindLocation(ctx, locColl, id)
+	if err != nil {
+		if err == internal.ErrLocationNotFound {
+			return store.Location{}, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", mongo.ErrNoDocuments).Error()), http.StatusNotFound
+		}
+		return store.Location{}, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError
+	}
+	return location, nil, http.StatusOK
+}
+
+func locationFromParams(params types.LocationParams) store.Location {
+	location := store.Location{
+		Name: params.Name,
+		Address: store.Address{
+			Street:     params.Address.Street,
+			City:       params.Address.City,
+			PostalCode: params.Address.PostalCode,
+			Country:    params.Address.Country,
+		},
+		Timezone:     params.Timezone,
+		OpeningHours: []store.OpeningHours{},
+		Closures:     []store.Closure{},
+	}
+	for _, hours := range params.OpeningHours {
+		location.OpeningHours = append(location.OpeningHours, store.OpeningHours{Day: hours.Day, Opens: hours.Opens, Closes: hours.Closes})
+	}
+	for _, closure := range params.Closures {
+		location.Closures = append(location.Closures, store.Closure{Date: closure.Date, Reason: closure.Reason})
+	}
+	return location
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) GetLoyaltyBalanceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	accColl := s.Store.Collection(ctx, "coffeeshop", "loyalty_accounts")
+
+	rules, err := internal.ParseLoyaltyRules(s.envs)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	// customers who never earned a point have no account yet
+	account := store.LoyaltyAccount{Id: userInfo.Id}
+	err = accColl.FindOne(ctx, bson.D{{Key: "_id", Value: userInfo.Id}}).Decode(&account)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string `json:"status"`
+		Data   struct {
+			Balance           int64 `json:"balance"`
+			PointsPerFreeItem int64 `json:"points_per_free_item"`
+			FreeItems         int64 `json:"free_items"`
+		} `json:"data"`
+	}{Status: "success"}
+	result.Data.Balance = account.Balance
+	result.Data.PointsPerFreeItem = rules.PointsPerFreeItem
+	result.Data.FreeItems = account.Balance / rules.PointsPerFreeItem
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) GetLoyaltyTransactionsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	txColl := s.Store.Collection(ctx, "coffeeshop", "loyalty_transactions")
+
+	queries := r.URL.Query()
+	limit, err := internal.PageLimit(queries)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	filter := bson.D{{Key: "user", Value: userInfo.Id}}
+	if cursor := queries.Get("cursor"); cursor != "" {
+		lastID, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid cursor %w", err).Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: lastID}}})
+	}
+
+	// fetch one extra document to find out whether another page exists
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	cur, err := txColl.Find(ctx, filter, opts)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	transactions := []store.LoyaltyTransaction{}
+	if err := cur.All(ctx, &transactions); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var nextCursor string
+	if int64(len(transactions)) > limit {
+		transactions = transactions[:limit]
+		nextCursor = transactions[len(transactions)-1].Id.Hex()
+	}
+
+	result := struct {
+		Status     string                     `json:"status"`
+		Results    int32                      `json:"results"`
+		NextCursor string                     `json:"next_cursor,omitempty"`
+		Data       []store.LoyaltyTransaction `json:"data"`
+	}{
+		Status:     "success",
+		Results:    int32(len(transactions)),
+		NextCursor: nextCursor,
+		Data:       transactions,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
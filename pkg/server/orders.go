@@ -2,19 +2,29 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
 	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
 	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/types"
+	"github.com/silaselisha/coffee-api/workers"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (s *Server) CreateOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+var errProductNotFound = errors.New("product not found")
 
+func (s *Server) CreateOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	orderPayload, err := internal.ReadReqBody[types.OrderParams](r.Body, s.vd)
 	if err != nil {
 		res := internal.NewErrorResponse("failed", err.Error())
@@ -29,53 +39,647 @@ func (s *Server) CreateOrderHandler(ctx context.Context, w http.ResponseWriter,
 		return internal.ResponseHandler(w, res, http.StatusInternalServerError)
 	}
 
-	products, err := s.BatchGetAllProductsByIds(ctx, productsID)
+	loyaltyRules, err := internal.ParseLoyaltyRules(s.envs)
 	if err != nil {
-		res := internal.NewErrorResponse("failed", err.Error())
-		return internal.ResponseHandler(w, res, http.StatusInternalServerError)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 	}
 
-	var totalAmount float64
-	var totalDiscount float64
-	var orderItems []store.OrderItem
-	for _, order := range cart {
-		product, ok := products[order.Product]
+	taxRate, err := internal.ParseTaxRate(s.envs.TAX_RATE)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	pickupRules, err := internal.ParsePickupRules(s.envs)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	locationID, err := internal.OrderLocation(ctx, s.Store.Collection(ctx, "coffeeshop", "locations"), orderPayload.Location, s.envs.DEFAULT_LOCATION)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidLocation) || errors.Is(err, internal.ErrLocationRequired) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if !orderPayload.PickupAt.IsZero() && locationID.IsZero() {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", "a pickup time needs a location"), http.StatusBadRequest)
+	}
+
+	session, err := s.Store.TxnStartSession(ctx)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer session.EndSession(ctx)
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+		prodColl := s.Store.Collection(ctx, "coffeeshop", "products")
+
+		products, err := s.BatchGetAllProductsByIds(ctx, productsID)
+		if err != nil {
+			return nil, err
+		}
+
+		var pickupSlot time.Time
+		if !locationID.IsZero() {
+			location, err := internal.FindLocation(ctx, s.Store.Collection(ctx, "coffeeshop", "locations"), locationID)
+			if err != nil {
+				return nil, err
+			}
+
+			if orderPayload.PickupAt.IsZero() {
+				open, err := internal.LocationOpenAt(location, time.Now())
+				if err != nil {
+					return nil, err
+				}
+				if !open {
+					return nil, fmt.Errorf("%w: %s is not taking orders now", internal.ErrLocationClosed, location.Name)
+				}
+			} else {
+				// orders placed ahead only need the shop open at pickup
+				pickupSlot, err = internal.PickupSlotAt(location, orderPayload.PickupAt, pickupRules, time.Now())
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			err = internal.ApplyLocationProducts(ctx, s.Store.Collection(ctx, "coffeeshop", "location_products"), locationID, products)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		currency := s.currency()
+		totalAmount := money.Zero(currency)
+		totalDiscount := money.Zero(currency)
+		var orderItems []store.OrderItem
+		for _, order := range cart {
+			product, ok := products[order.Product]
+
+			if !ok {
+				return nil, errProductNotFound
+			}
+
+			unitPrice, modifiers, err := internal.PriceOrderItem(product, order.Modifiers, currency)
+			if err != nil {
+				return nil, err
+			}
+
+			amount := unitPrice.Mul(int64(order.Quantity))
+			discount := amount.Percent(product.Discount)
+			totalAmount = totalAmount.Add(amount.Sub(discount))
+			totalDiscount = totalDiscount.Add(discount)
+
+			orderItem := store.OrderItem{
+				Product:   order.Product,
+				Quantity:  order.Quantity,
+				Modifiers: modifiers,
+				UnitPrice: unitPrice,
+				Amount:    amount,
+				Discount:  discount,
+			}
+			orderItems = append(orderItems, orderItem)
+		}
+
+		var promotion store.Promotion
+		var applied *store.AppliedPromotion
+		if orderPayload.PromoCode != "" {
+			promotion, err = internal.FindPromotion(ctx, s.Store.Collection(ctx, "coffeeshop", "promotions"), orderPayload.PromoCode)
+			if err != nil {
+				return nil, err
+			}
 
-		if !ok {
-			res := internal.NewErrorResponse("failed", fmt.Errorf("product not found").Error())
-			return internal.ResponseHandler(w, res, http.StatusNotFound)
+			result, err := internal.ApplyPromotion(promotion, orderItems, products, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range result.Lines {
+				orderItems[line.Line].Discount = orderItems[line.Line].Discount.Add(line.Discount)
+			}
+			totalAmount = totalAmount.Sub(result.Discount)
+			totalDiscount = totalDiscount.Add(result.Discount)
+			applied = &result
 		}
 
-		amount := product.Price * float64(order.Quantity)
-		discount := (amount / 100.00) * float64(product.Discount)
-		totalAmount += (amount - discount)
-		totalDiscount += discount
+		redeem := make([]uint32, len(orderPayload.Items))
+		for idx, item := range orderPayload.Items {
+			redeem[idx] = item.Redeem
+		}
+		loyalty := internal.ApplyLoyaltyRedemption(orderItems, redeem, loyaltyRules)
+		if loyalty != nil {
+			for _, line := range loyalty.Lines {
+				orderItems[line.Line].Discount = orderItems[line.Line].Discount.Add(line.Discount)
+			}
+			totalAmount = totalAmount.Sub(loyalty.Discount)
+			totalDiscount = totalDiscount.Add(loyalty.Discount)
+		}
+
+		taxRules, err := internal.LoadTaxRules(ctx, s.Store.Collection(ctx, "coffeeshop", "tax_rules"), taxRate)
+		if err != nil {
+			return nil, err
+		}
+		var location string
+		if !locationID.IsZero() {
+			location = locationID.Hex()
+		}
+		taxes, addedTax := internal.ApplyTaxes(orderItems, products, taxRules, location, currency)
+		taxTotal := money.Zero(currency)
+		for _, tax := range taxes {
+			taxTotal = taxTotal.Add(tax.Amount)
+		}
+		totalAmount = totalAmount.Add(addedTax)
+
+		err = internal.ReserveStock(ctx, prodColl, orderItems, products)
+		if err != nil {
+			return nil, err
+		}
+
+		order := store.Order{
+			Id:          primitive.NewObjectID(),
+			Items:       orderItems,
+			TotalAmount: totalAmount,
+			Owner:       userInfo.Id,
+			Status:      string(types.ORDER_PENDING),
+			StatusHistory: []store.OrderStatusChange{{
+				To:        string(types.ORDER_PENDING),
+				ChangedBy: userInfo.Id,
+				Role:      userInfo.Role,
+				ChangedAt: time.Now(),
+			}},
+			TotalDiscount: totalDiscount,
+			Promotion:     applied,
+			Loyalty:       loyalty,
+			Location:      locationID,
+			PickupAt:      orderPayload.PickupAt,
+			Taxes:         taxes,
+			TaxTotal:      taxTotal,
+			GiftCardTotal: money.Zero(currency),
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+		}
+
+		if pickupSlot.IsZero() {
+			order.QueuedAt = order.CreatedAt
+		} else {
+			order.DueAt = orderPayload.PickupAt.Add(-pickupRules.PrepTime)
+			order.PickupSlot, err = internal.BookPickupSlot(ctx, s.Store, locationID, pickupSlot, pickupRules.SlotCapacity)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(orderPayload.GiftCards) > 0 {
+			order.GiftCards, order.GiftCardTotal, err = internal.RedeemGiftCards(ctx, s.Store, orderPayload.GiftCards, order, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			// nothing is left to charge, the order is paid and accepted
+			// like one whose payment went through
+			if order.GiftCardTotal == order.TotalAmount {
+				order.PaymentStatus = types.PAID.String()
+				order.PaidTotal = order.TotalAmount
+				order.RefundedTotal = money.Zero(currency)
+				order.Status = string(types.ORDER_ACCEPTED)
+				order.StatusHistory = append(order.StatusHistory, store.OrderStatusChange{
+					From:      string(types.ORDER_PENDING),
+					To:        string(types.ORDER_ACCEPTED),
+					ChangedBy: userInfo.Id,
+					Role:      "system",
+					Note:      "paid with gift cards",
+					ChangedAt: time.Now(),
+				})
+			}
+		}
+
+		_, err = ordColl.InsertOne(ctx, order)
+		if err != nil {
+			return nil, err
+		}
+
+		if applied != nil {
+			err = internal.RedeemPromotion(ctx, s.Store, promotion, order)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-		orderItem := store.OrderItem{
-			Product:  order.Product,
-			Quantity: order.Quantity,
-			Amount:   amount,
-			Discount: discount,
+		err = internal.RedeemLoyaltyPoints(ctx, s.Store, order)
+		if err != nil {
+			return nil, err
+		}
+		return order, nil
+	}, &options.TransactionOptions{})
+
+	if err != nil {
+		var stockErr *internal.InsufficientStockError
+		switch {
+		case errors.As(err, &stockErr):
+			res := types.StockErrorResParams{Status: "failed", Error: "insufficient stock", Items: stockErr.Items}
+			return internal.ResponseHandler(w, res, http.StatusConflict)
+		case errors.Is(err, internal.ErrInvalidModifiers), errors.Is(err, money.ErrCurrencyMismatch):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		case errors.Is(err, errProductNotFound), errors.Is(err, internal.ErrPromotionNotFound), errors.Is(err, internal.ErrGiftCardNotFound),
+			errors.Is(err, internal.ErrLocationNotFound):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusNotFound)
+		case errors.Is(err, internal.ErrPromotionInactive), errors.Is(err, internal.ErrPromotionMinimum), errors.Is(err, internal.ErrPromotionNotApplicable):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusUnprocessableEntity)
+		case errors.Is(err, internal.ErrInsufficientPoints), errors.Is(err, internal.ErrGiftCardUnusable):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusUnprocessableEntity)
+		case errors.Is(err, internal.ErrLocationClosed), errors.Is(err, internal.ErrProductUnavailable), errors.Is(err, internal.ErrPickupUnavailable):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusUnprocessableEntity)
+		case errors.Is(err, internal.ErrPromotionUsedUp), errors.Is(err, internal.ErrPickupSlotFull):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		default:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 		}
-		orderItems = append(orderItems, orderItem)
 	}
 
-	order := store.Order{
-		Id:            primitive.NewObjectID(),
-		Items:         orderItems,
-		TotalAmount:   totalAmount,
-		Owner:         userInfo.Id,
-		Status:        "pending",
-		TotalDiscount: totalDiscount,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	order := response.(store.Order)
+	s.announceOrder(ctx, order)
+
+	if order.PickupSlot != "" {
+		opts := []asynq.Option{
+			asynq.MaxRetry(10),
+			asynq.ProcessAt(order.DueAt),
+			asynq.Queue(workers.CriticalQueue),
+			asynq.TaskID(order.Id.Hex()),
+		}
+		err = s.taskDistributor.QueueScheduledOrderTask(ctx, &types.PayloadScheduledOrder{OrderId: order.Id.Hex()}, opts...)
+		if err != nil {
+			// the order is placed; the sweep for due orders sends it to the
+			// kitchen without the task
+			log.Error().Err(err).Str("order", order.Id.Hex()).Msg("scheduling order failed")
+		}
 	}
 
-	_, err = ordColl.InsertOne(ctx, order)
+	return internal.ResponseHandler(w, order, http.StatusCreated)
+}
+
+func (s *Server) UpdateOrderStatusHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
 	if err != nil {
-		res := internal.NewErrorResponse("failed", err.Error())
-		return internal.ResponseHandler(w, res, http.StatusInternalServerError)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
 	}
 
-	return internal.ResponseHandler(w, order, http.StatusCreated)
+	statusPayload, err := internal.ReadReqBody[types.OrderStatusParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	change := store.OrderStatusChange{
+		ChangedBy: userInfo.Id,
+		Role:      userInfo.Role,
+		Note:      statusPayload.Note,
+	}
+	updated, err := s.transitionOrder(ctx, order, types.OrderStatus(statusPayload.Status), change)
+	if err != nil {
+		switch {
+		case errors.Is(err, internal.ErrInvalidOrderTransition), errors.Is(err, internal.ErrOrderStatusConflict):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		default:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	if !updated.Invoice.IsZero() {
+		err = s.distributeInvoicePDF(ctx, updated.Invoice)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	result := struct {
+		Status string      `json:"status"`
+		Data   store.Order `json:"data"`
+	}{
+		Status: "success",
+		Data:   updated,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) GetAllOrdersHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	queries := r.URL.Query()
+
+	limit, err := internal.PageLimit(queries)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	filter := bson.D{}
+	if status := queries.Get("status"); status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	if isStaff(userInfo.Role) {
+		if owner := queries.Get("owner"); owner != "" {
+			ownerID, err := primitive.ObjectIDFromHex(owner)
+			if err != nil {
+				return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid owner %w", err).Error()), http.StatusBadRequest)
+			}
+			filter = append(filter, bson.E{Key: "owner", Value: ownerID})
+		}
+	} else {
+		filter = append(filter, bson.E{Key: "owner", Value: userInfo.Id})
+	}
+
+	createdAt := bson.D{}
+	for _, bound := range []struct{ param, operator string }{{"from", "$gte"}, {"to", "$lte"}} {
+		value := queries.Get(bound.param)
+		if value == "" {
+			continue
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid %s date %w", bound.param, err).Error()), http.StatusBadRequest)
+		}
+		createdAt = append(createdAt, bson.E{Key: bound.operator, Value: timestamp})
+	}
+	if len(createdAt) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	if cursor := queries.Get("cursor"); cursor != "" {
+		lastID, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid cursor %w", err).Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: lastID}}})
+	}
+
+	// fetch one extra document to find out whether another page exists
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	cur, err := ordColl.Find(ctx, filter, opts)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	orders := []store.Order{}
+	if err := cur.All(ctx, &orders); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var nextCursor string
+	if int64(len(orders)) > limit {
+		orders = orders[:limit]
+		nextCursor = orders[len(orders)-1].Id.Hex()
+	}
+
+	result := struct {
+		Status     string        `json:"status"`
+		Results    int32         `json:"results"`
+		NextCursor string        `json:"next_cursor,omitempty"`
+		Data       []store.Order `json:"data"`
+	}{
+		Status:     "success",
+		Results:    int32(len(orders)),
+		NextCursor: nextCursor,
+		Data:       orders,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) GetOrderByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if order.Owner != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to retrieve their own orders")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	result := struct {
+		Status string      `json:"status"`
+		Data   store.Order `json:"data"`
+	}{
+		Status: "success",
+		Data:   order,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) CancelOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	cancelPayload, err := internal.ReadReqBody[types.OrderCancelParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	gracePeriod, err := s.orderCancelGracePeriod()
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if order.Owner != userInfo.Id {
+		err := errors.New("user only allowed to cancel their own orders")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	withinGracePeriod := time.Since(order.CreatedAt) <= gracePeriod
+	if order.Status != string(types.ORDER_PENDING) && !withinGracePeriod {
+		err := fmt.Errorf("order is %s and can no longer be cancelled", order.Status)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+	}
+
+	change := store.OrderStatusChange{
+		ChangedBy: userInfo.Id,
+		Role:      userInfo.Role,
+		Note:      cancelPayload.Reason,
+	}
+	updated, err := s.transitionOrder(ctx, order, types.ORDER_CANCELLED, change, bson.E{Key: "cancel_reason", Value: cancelPayload.Reason})
+	if err != nil {
+		switch {
+		case errors.Is(err, internal.ErrInvalidOrderTransition), errors.Is(err, internal.ErrOrderStatusConflict):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		default:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	opts := []asynq.Option{
+		asynq.MaxRetry(5),
+		asynq.ProcessIn(5 * time.Second),
+		asynq.Queue(workers.DefaultQueue),
+	}
+	// one task per receiver, so retrying a failed mail never repeats the
+	// other; the shop is notified on the same address it sends mail from
+	for _, receiver := range []string{userInfo.Email, s.envs.SMTP_SENDER} {
+		err = s.taskDistributor.OrderCancelledMailTask(ctx, &types.PayloadOrderNotification{OrderId: updated.Id.Hex(), Email: receiver}, opts...)
+		if err != nil {
+			// the order is cancelled already, a retry would only get 409
+			log.Error().Err(err).Str("order", updated.Id.Hex()).Msg("queueing order cancelled mail failed")
+		}
+	}
+
+	result := struct {
+		Status string      `json:"status"`
+		Data   store.Order `json:"data"`
+	}{
+		Status: "success",
+		Data:   updated,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// orderCancelGracePeriod reads ORDER_CANCEL_GRACE_PERIOD in minutes. When it
+// is not set only pending orders can be cancelled by their owner.
+func (s *Server) orderCancelGracePeriod() (time.Duration, error) {
+	if s.envs.ORDER_CANCEL_GRACE_PERIOD == "" {
+		return 0, nil
+	}
+
+	minutes, err := strconv.Atoi(s.envs.ORDER_CANCEL_GRACE_PERIOD)
+	if err != nil {
+		return 0, fmt.Errorf("invalid order cancel grace period %w", err)
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// transitionOrder applies a status change and, when the order is cancelled or
+// rejected, returns its reserved stock, promo code use, redeemed points, gift
+// card balances and pickup slot within the same transaction. Completing an order issues
+// its invoice and credits the customer's loyalty points in that transaction
+// too.
+func (s *Server) transitionOrder(ctx context.Context, order store.Order, to types.OrderStatus, change store.OrderStatusChange, extra ...bson.E) (store.Order, error) {
+	taxRate, err := internal.ParseTaxRate(s.envs.TAX_RATE)
+	if err != nil {
+		return store.Order{}, err
+	}
+
+	loyaltyRules, err := internal.ParseLoyaltyRules(s.envs)
+	if err != nil {
+		return store.Order{}, err
+	}
+
+	session, err := s.Store.TxnStartSession(ctx)
+	if err != nil {
+		return store.Order{}, err
+	}
+	defer session.EndSession(ctx)
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+		prodColl := s.Store.Collection(ctx, "coffeeshop", "products")
+
+		updated, err := internal.TransitionOrderStatus(ctx, ordColl, order, to, change, extra...)
+		if err != nil {
+			return nil, err
+		}
+
+		if to == types.ORDER_CANCELLED || to == types.ORDER_REJECTED {
+			// refunded units were returned to stock by their refund
+			err = internal.RestoreStock(ctx, prodColl, internal.UnrefundedItems(updated.Items))
+			if err != nil {
+				return nil, err
+			}
+
+			err = internal.ReleasePromotion(ctx, s.Store, updated)
+			if err != nil {
+				return nil, err
+			}
+
+			err = internal.ReturnLoyaltyPoints(ctx, s.Store, updated)
+			if err != nil {
+				return nil, err
+			}
+
+			err = internal.ReturnGiftCards(ctx, s.Store, updated)
+			if err != nil {
+				return nil, err
+			}
+
+			err = internal.ReleasePickupSlot(ctx, s.Store, updated)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if to == types.ORDER_COMPLETED {
+			invoice, err := internal.IssueInvoice(ctx, s.Store, updated, taxRate)
+			if err != nil {
+				return nil, err
+			}
+			updated.Invoice = invoice.Id
+
+			err = internal.EarnLoyaltyPoints(ctx, s.Store, updated, loyaltyRules)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return updated, nil
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return store.Order{}, err
+	}
+
+	updated := response.(store.Order)
+	s.announceOrder(ctx, updated)
+	return updated, nil
+}
+
+// distributeInvoicePDF renders the PDF of an invoice issued on completing an
+// order in the background.
+func (s *Server) distributeInvoicePDF(ctx context.Context, invoice primitive.ObjectID) error {
+	opts := []asynq.Option{
+		asynq.MaxRetry(10),
+		asynq.Queue(workers.DefaultQueue),
+	}
+	return s.taskDistributor.InvoicePDFTask(ctx, &types.PayloadInvoice{InvoiceId: invoice.Hex()}, opts...)
+}
+
+func isStaff(role string) bool {
+	return role == "admin" || role == "barista"
 }
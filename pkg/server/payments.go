@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/payments"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) PayOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+	payColl := s.Store.Collection(ctx, "coffeeshop", "payments")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	paymentPayload, err := internal.ReadReqBody[types.PaymentParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if order.Owner != userInfo.Id {
+		err := errors.New("user only allowed to pay for their own orders")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	if order.Status != string(types.ORDER_PENDING) {
+		err := fmt.Errorf("order is %s and can no longer be paid", order.Status)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+	}
+
+	order, err = s.beginOrderPayment(ctx, ordColl, order)
+	if err != nil {
+		if errors.Is(err, internal.ErrPaymentInProgress) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	// the payment is stored before the provider hears of it, so the intent
+	// always has a payment its webhooks can settle
+	payment := store.Payment{
+		Id:       primitive.NewObjectID(),
+		Order:    order.Id,
+		Customer: userInfo.Id,
+		Provider: s.paymentProvider.Name(),
+		// gift cards already paid their part when the order was placed
+		Amount:    order.TotalAmount.Sub(order.GiftCardTotal),
+		Status:    types.PENDING.String(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err = payColl.InsertOne(ctx, payment)
+	if err != nil {
+		return s.rejectUnsentPayment(ctx, w, order.Id, primitive.NilObjectID, err, http.StatusInternalServerError)
+	}
+
+	intent, err := s.paymentProvider.CreateIntent(ctx, payments.IntentParams{
+		Amount:        payment.Amount,
+		Reference:     order.Id.Hex(),
+		PaymentMethod: paymentPayload.PaymentMethod,
+	})
+	if err != nil {
+		return s.rejectUnsentPayment(ctx, w, order.Id, payment.Id, err, http.StatusBadGateway)
+	}
+
+	err = payColl.FindOneAndUpdate(ctx, bson.D{
+		{Key: "_id", Value: payment.Id},
+		{Key: "status", Value: types.PENDING.String()},
+	}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "intent_id", Value: intent.Id},
+		{Key: "amount", Value: intent.Amount},
+		{Key: "updated_at", Value: time.Now()},
+	}}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&payment)
+	if err != nil {
+		return s.rejectUnsentPayment(ctx, w, order.Id, payment.Id, err, http.StatusInternalServerError)
+	}
+
+	// drinks are made straight away, so authorised payments are captured
+	// at once instead of when the order is collected
+	if intent.Status == payments.INTENT_REQUIRES_CAPTURE {
+		captured, err := s.paymentProvider.Capture(ctx, intent.Id, intent.Amount)
+		if err != nil {
+			intent.Status = payments.INTENT_FAILED
+			intent.FailureReason = err.Error()
+		} else {
+			intent = captured
+		}
+	}
+
+	code := http.StatusCreated
+	switch intent.Status {
+	case payments.INTENT_SUCCEEDED:
+		payment, order, err = internal.ApplyPaymentOutcome(ctx, s.Store, intent.Id, types.PAID, "")
+	case payments.INTENT_FAILED:
+		code = http.StatusPaymentRequired
+		payment, order, err = internal.ApplyPaymentOutcome(ctx, s.Store, intent.Id, types.REJECTED, intent.FailureReason)
+	default:
+		// settled later through the provider's webhook
+		code = http.StatusAccepted
+	}
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if code != http.StatusAccepted {
+		s.announceOrder(ctx, order)
+	}
+
+	result := struct {
+		Status string `json:"status"`
+		Data   struct {
+			Payment store.Payment `json:"payment"`
+			Order   store.Order   `json:"order"`
+		} `json:"data"`
+	}{Status: "success"}
+	if code == http.StatusPaymentRequired {
+		result.Status = "failed"
+	}
+	result.Data.Payment = payment
+	result.Data.Order = order
+	return internal.ResponseHandler(w, result, code)
+}
+
+// beginOrderPayment marks the order as awaiting payment, first releasing a
+// payment that was abandoned before it reached the provider.
+func (s *Server) beginOrderPayment(ctx context.Context, ordColl *mongo.Collection, order store.Order) (store.Order, error) {
+	started, err := internal.BeginOrderPayment(ctx, ordColl, order)
+	if !errors.Is(err, internal.ErrPaymentInProgress) {
+		return started, err
+	}
+
+	released, releaseErr := internal.ReleaseAbandonedPayment(ctx, s.Store, order, time.Now())
+	if releaseErr != nil {
+		return store.Order{}, releaseErr
+	}
+	if !released {
+		return store.Order{}, err
+	}
+	return internal.BeginOrderPayment(ctx, ordColl, order)
+}
+
+// rejectUnsentPayment answers a payment that failed before its intent was
+// stored. An intent the provider may have created is never captured, so the
+// customer may try again.
+func (s *Server) rejectUnsentPayment(ctx context.Context, w http.ResponseWriter, orderID, paymentID primitive.ObjectID, cause error, code int) error {
+	err := internal.RejectUnsentPayment(context.WithoutCancel(ctx), s.Store, orderID, paymentID, cause.Error())
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	return internal.ResponseHandler(w, internal.NewErrorResponse("failed", cause.Error()), code)
+}
+
+func (s *Server) GetOrderPaymentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+	payColl := s.Store.Collection(ctx, "coffeeshop", "payments")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if order.Owner != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to retrieve their own payments")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	cur, err := payColl.Find(ctx, bson.D{{Key: "order", Value: order.Id}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	records := []store.Payment{}
+	if err := cur.All(ctx, &records); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string          `json:"status"`
+		Results int32           `json:"results"`
+		Data    []store.Payment `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(records)),
+		Data:    records,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
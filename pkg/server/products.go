@@ -16,6 +16,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/hibiken/asynq"
 	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
 	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/types"
 	"github.com/silaselisha/coffee-api/workers"
@@ -99,7 +100,7 @@ func (s *Server) UpdateProductHandler(ctx context.Context, w http.ResponseWriter
 					return nil, err
 				}
 
-				price, err := strconv.ParseFloat(string(data), 64)
+				price, err := money.Parse(string(data), s.currency())
 				if err != nil {
 					return nil, err
 				}
@@ -113,6 +114,54 @@ func (s *Server) UpdateProductHandler(ctx context.Context, w http.ResponseWriter
 				}
 				updates[curr.FormName()] = strings.Split(string(data), ",")
 
+			case "stock":
+				data, err := io.ReadAll(curr)
+				if err != nil {
+					return nil, err
+				}
+
+				stock, err := strconv.ParseInt(string(data), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				updates[curr.FormName()] = stock
+
+			case "track_stock":
+				data, err := io.ReadAll(curr)
+				if err != nil {
+					return nil, err
+				}
+
+				trackStock, err := strconv.ParseBool(string(data))
+				if err != nil {
+					return nil, err
+				}
+				updates[curr.FormName()] = trackStock
+
+			case "modifier_groups":
+				data, err := io.ReadAll(curr)
+				if err != nil {
+					return nil, err
+				}
+
+				var params []types.ModifierGroupParams
+				err = json.Unmarshal(data, &params)
+				if err != nil {
+					return nil, err
+				}
+
+				for _, param := range params {
+					if err := s.vd.Struct(param); err != nil {
+						return nil, err
+					}
+				}
+
+				groups, err := internal.NormalizeModifierGroups(params, s.currency())
+				if err != nil {
+					return nil, err
+				}
+				updates[curr.FormName()] = groups
+
 			case "thumbnail":
 				data, err := io.ReadAll(curr)
 				if err != nil {
@@ -187,6 +236,10 @@ func (s *Server) UpdateProductHandler(ctx context.Context, w http.ResponseWriter
 			Description: updatedDocument.Description,
 			Ingridients: updatedDocument.Ingridients,
 			Ratings:     updatedDocument.Ratings,
+			ReviewCount: updatedDocument.ReviewCount,
+			TrackStock:  updatedDocument.TrackStock,
+			Stock:       updatedDocument.Stock,
+			Modifiers:   internal.ModifierGroupsToParams(updatedDocument.Modifiers),
 			CreatedAt:   updatedDocument.CreatedAt,
 			UpdatedAt:   updatedDocument.UpdatedAt,
 		}
@@ -233,37 +286,129 @@ func (s *Server) UpdateProductHandler(ctx context.Context, w http.ResponseWriter
 	return internal.ResponseHandler(w, result, http.StatusOK)
 }
 
+// productSortFields maps the values accepted by ?sort= to the product field
+// they order by. A leading "-" on the query value sorts descending.
+var productSortFields = map[string]string{
+	"price":      "price.amount",
+	"ratings":    "ratings",
+	"created_at": "created_at",
+}
+
+func productSortValue(item store.Item, field string) interface{} {
+	switch field {
+	case "price.amount":
+		return item.Price.Amount
+	case "ratings":
+		return item.Ratings
+	default:
+		return item.CreatedAt
+	}
+}
+
 func (s *Server) GetAllProductsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	collection := s.Store.Collection(ctx, "coffeeshop", "products")
+	queries := r.URL.Query()
 
-	var result types.ItemResponseListParams
-	type resp struct {
-		Status  string                       `json:"status"`
-		Results int32                        `json:"results"`
-		Data    types.ItemResponseListParams `json:"data"`
+	limit, err := internal.PageLimit(queries)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
 	}
 
-	productRes := resp{Status: "success", Results: 0, Data: result}
-	cur, err := collection.Find(ctx, bson.D{})
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return internal.ResponseHandler(w, productRes, http.StatusOK)
+	filter := bson.D{}
+	if q := strings.TrimSpace(queries.Get("q")); q != "" {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "name", Value: "text"}, {Key: "summary", Value: "text"}, {Key: "ingridients", Value: "text"}},
+			Options: options.Index().SetName("products_text").SetWeights(bson.D{
+				{Key: "name", Value: 10},
+				{Key: "summary", Value: 5},
+				{Key: "ingridients", Value: 2},
+			}),
+		})
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 		}
+		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: q}}})
+	}
+
+	if category := queries.Get("category"); category != "" {
+		filter = append(filter, bson.E{Key: "category", Value: category})
+	}
 
+	price := bson.D{}
+	for _, bound := range []struct{ param, operator string }{{"min_price", "$gte"}, {"max_price", "$lte"}} {
+		value := queries.Get(bound.param)
+		if value == "" {
+			continue
+		}
+
+		amount, err := money.Parse(value, s.currency())
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid %s %w", bound.param, err).Error()), http.StatusBadRequest)
+		}
+		price = append(price, bson.E{Key: bound.operator, Value: amount.Amount})
+	}
+	if len(price) > 0 {
+		filter = append(filter, bson.E{Key: "price.amount", Value: price})
+	}
+
+	if value := queries.Get("min_discount"); value != "" {
+		discount, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || discount > 100 {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Sprintf("invalid min_discount %q", value)), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "discount", Value: bson.D{{Key: "$gte", Value: discount}}})
+	}
+
+	sortParam := queries.Get("sort")
+	if sortParam == "" {
+		sortParam = "-created_at"
+	}
+	ascending := !strings.HasPrefix(sortParam, "-")
+	sortField, ok := productSortFields[strings.TrimPrefix(sortParam, "-")]
+	if !ok {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Sprintf("invalid sort %q", sortParam)), http.StatusBadRequest)
+	}
+	direction := -1
+	if ascending {
+		direction = 1
+	}
+
+	if cursor := queries.Get("cursor"); cursor != "" {
+		value, lastID, err := internal.DecodeCursor(cursor)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, internal.KeysetFilter(sortField, value, lastID, ascending))
+	}
+
+	// fetch one extra document to find out whether another page exists
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortField, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(limit + 1)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
 		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 	}
 	defer cur.Close(ctx)
 
-	for cur.Next(ctx) {
-		item := new(store.Item)
-		err := cur.Decode(&item)
+	items := []store.Item{}
+	if err := cur.All(ctx, &items); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var next string
+	if int64(len(items)) > limit {
+		items = items[:limit]
+		last := items[len(items)-1]
+		cursor, err := internal.EncodeCursor(productSortValue(last, sortField), last.Id)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 		}
+		next = internal.NextPageLink(r.URL, cursor)
+	}
 
+	result := types.ItemResponseListParams{}
+	for _, item := range items {
 		product := types.ItemResParams{
 			Id:          item.Id.Hex(),
 			Images:      item.Images,
@@ -277,13 +422,27 @@ func (s *Server) GetAllProductsHandler(ctx context.Context, w http.ResponseWrite
 			Description: item.Description,
 			Ingridients: item.Ingridients,
 			Ratings:     item.Ratings,
+			ReviewCount: item.ReviewCount,
+			TrackStock:  item.TrackStock,
+			Stock:       item.Stock,
+			Modifiers:   internal.ModifierGroupsToParams(item.Modifiers),
 			CreatedAt:   item.CreatedAt,
 			UpdatedAt:   item.UpdatedAt,
 		}
 		result = append(result, product)
 	}
 
-	productRes = resp{Status: "success", Results: int32(len(result)), Data: result}
+	productRes := struct {
+		Status  string                       `json:"status"`
+		Results int32                        `json:"results"`
+		Next    string                       `json:"next,omitempty"`
+		Data    types.ItemResponseListParams `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(result)),
+		Next:    next,
+		Data:    result,
+	}
 	return internal.ResponseHandler(w, productRes, http.StatusOK)
 }
 
@@ -321,6 +480,10 @@ func (s *Server) GetProductByIdHandler(ctx context.Context, w http.ResponseWrite
 		Description: item.Description,
 		Ingridients: item.Ingridients,
 		Ratings:     item.Ratings,
+		ReviewCount: item.ReviewCount,
+		TrackStock:  item.TrackStock,
+		Stock:       item.Stock,
+		Modifiers:   internal.ModifierGroupsToParams(item.Modifiers),
 		CreatedAt:   item.CreatedAt,
 		UpdatedAt:   item.UpdatedAt,
 	}
@@ -467,7 +630,7 @@ func (s *Server) CreateProductHandler(ctx context.Context, w http.ResponseWriter
 				if err != nil {
 					return nil, err
 				}
-				price, err := strconv.ParseFloat(string(data), 64)
+				price, err := money.Parse(string(data), s.currency())
 				if err != nil {
 					return nil, err
 				}
@@ -491,6 +654,49 @@ func (s *Server) CreateProductHandler(ctx context.Context, w http.ResponseWriter
 				ingridients := strings.Split(string(data), ",")
 				item.Ingridients = ingridients
 
+			case "stock":
+				data, err := io.ReadAll(curr)
+				if err != nil {
+					return nil, err
+				}
+				stock, err := strconv.ParseInt(string(data), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				item.Stock = stock
+
+			case "track_stock":
+				data, err := io.ReadAll(curr)
+				if err != nil {
+					return nil, err
+				}
+				trackStock, err := strconv.ParseBool(string(data))
+				if err != nil {
+					return nil, err
+				}
+				item.TrackStock = trackStock
+
+			case "modifier_groups":
+				data, err := io.ReadAll(curr)
+				if err != nil {
+					return nil, err
+				}
+				var params []types.ModifierGroupParams
+				err = json.Unmarshal(data, &params)
+				if err != nil {
+					return nil, err
+				}
+				for _, param := range params {
+					if err := s.vd.Struct(param); err != nil {
+						return nil, err
+					}
+				}
+				groups, err := internal.NormalizeModifierGroups(params, s.currency())
+				if err != nil {
+					return nil, err
+				}
+				item.Modifiers = groups
+
 			case "name":
 				data, err := io.ReadAll(curr)
 				if err != nil {
@@ -592,6 +798,9 @@ func (s *Server) CreateProductHandler(ctx context.Context, w http.ResponseWriter
 			Summary:     item.Summary,
 			Category:    item.Category,
 			Description: item.Description,
+			TrackStock:  item.TrackStock,
+			Stock:       item.Stock,
+			Modifiers:   internal.ModifierGroupsToParams(item.Modifiers),
 			CreatedAt:   item.CreatedAt,
 			UpdatedAt:   item.UpdatedAt,
 		}
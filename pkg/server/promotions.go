@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) CreatePromotionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	promColl := s.Store.Collection(ctx, "coffeeshop", "promotions")
+
+	promotionPayload, err := internal.ReadReqBody[types.PromotionParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	promotion, err := s.promotionFromParams(promotionPayload)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	_, err = promColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	promotion.Id = primitive.NewObjectID()
+	promotion.CreatedBy = userInfo.Id
+	promotion.CreatedAt = time.Now()
+	promotion.UpdatedAt = time.Now()
+
+	_, err = promColl.InsertOne(ctx, promotion)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			err := fmt.Errorf("promo code %q already exists", promotion.Code)
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string          `json:"status"`
+		Data   store.Promotion `json:"data"`
+	}{
+		Status: "success",
+		Data:   promotion,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetAllPromotionsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	promColl := s.Store.Collection(ctx, "coffeeshop", "promotions")
+
+	cur, err := promColl.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	promotions := []store.Promotion{}
+	if err := cur.All(ctx, &promotions); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string            `json:"status"`
+		Results int32             `json:"results"`
+		Data    []store.Promotion `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(promotions)),
+		Data:    promotions,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) GetPromotionByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	promotion, errRes, code := s.findPromotion(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	result := struct {
+		Status string          `json:"status"`
+		Data   store.Promotion `json:"data"`
+	}{
+		Status: "success",
+		Data:   promotion,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// UpdatePromotionHandler replaces a promotion's rules. How often it was
+// already used is kept.
+func (s *Server) UpdatePromotionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	promColl := s.Store.Collection(ctx, "coffeeshop", "promotions")
+
+	current, errRes, code := s.findPromotion(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	promotionPayload, err := internal.ReadReqBody[types.PromotionParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	promotion, err := s.promotionFromParams(promotionPayload)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+	promotion.Id = current.Id
+	promotion.UsedCount = current.UsedCount
+	promotion.CreatedBy = current.CreatedBy
+	promotion.CreatedAt = current.CreatedAt
+	promotion.UpdatedAt = time.Now()
+
+	_, err = promColl.ReplaceOne(ctx, bson.D{{Key: "_id", Value: current.Id}}, promotion)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			err := fmt.Errorf("promo code %q already exists", promotion.Code)
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string          `json:"status"`
+		Data   store.Promotion `json:"data"`
+	}{
+		Status: "success",
+		Data:   promotion,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) DeletePromotionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	promColl := s.Store.Collection(ctx, "coffeeshop", "promotions")
+
+	promotion, errRes, code := s.findPromotion(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	_, err := promColl.DeleteOne(ctx, bson.D{{Key: "_id", Value: promotion.Id}})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	return internal.ResponseHandler(w, "", http.StatusNoContent)
+}
+
+func (s *Server) findPromotion(ctx context.Context, r *http.Request) (store.Promotion, *types.ErrorResParams, int) {
+	promColl := s.Store.Collection(ctx, "coffeeshop", "promotions")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return store.Promotion{}, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest
+	}
+
+	var promotion store.Promotion
+	err = promColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&promotion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Promotion{}, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound
+		}
+		return store.Promotion{}, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError
+	}
+	return promotion, nil, http.StatusOK
+}
+
+// promotionFromParams turns the decimal amounts of params into money in the
+// shop's currency.
+func (s *Server) promotionFromParams(params types.PromotionParams) (store.Promotion, error) {
+	currency := s.currency()
+	promotion := store.Promotion{
+		Code:         internal.NormalizePromoCode(params.Code),
+		Description:  params.Description,
+		Kind:         params.Kind,
+		Amount:       money.Zero(currency),
+		MinOrder:     money.Zero(currency),
+		Categories:   params.Categories,
+		Products:     []primitive.ObjectID{},
+		StartsAt:     params.StartsAt,
+		EndsAt:       params.EndsAt,
+		UsageLimit:   params.UsageLimit,
+		PerUserLimit: params.PerUserLimit,
+	}
+	if promotion.Categories == nil {
+		promotion.Categories = []string{}
+	}
+
+	switch types.PromotionKind(params.Kind) {
+	case types.PROMOTION_PERCENTAGE:
+		promotion.Percent = params.Percent
+	case types.PROMOTION_FIXED:
+		amount, err := money.Parse(params.Amount, currency)
+		if err != nil {
+			return store.Promotion{}, err
+		}
+		if amount.Amount <= 0 {
+			return store.Promotion{}, fmt.Errorf("%w: promotion amount must be positive", money.ErrInvalidAmount)
+		}
+		promotion.Amount = amount
+	case types.PROMOTION_BUY_X_GET_Y:
+		promotion.BuyQuantity = params.BuyQuantity
+		promotion.GetQuantity = params.GetQuantity
+	}
+
+	if params.MinOrder != "" {
+		minOrder, err := money.Parse(params.MinOrder, currency)
+		if err != nil {
+			return store.Promotion{}, err
+		}
+		promotion.MinOrder = minOrder
+	}
+
+	for _, product := range params.Products {
+		id, err := primitive.ObjectIDFromHex(product)
+		if err != nil {
+			return store.Promotion{}, err
+		}
+		promotion.Products = append(promotion.Products, id)
+	}
+	return promotion, nil
+}
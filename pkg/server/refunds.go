@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/silaselisha/coffee-api/workers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) RefundOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+	payColl := s.Store.Collection(ctx, "coffeeshop", "payments")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	refundPayload, err := internal.ReadReqBody[types.RefundParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	items, amount, err := internal.PlanRefund(order, refundPayload.Items)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidRefund) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+	}
+
+	// orders paid in full with gift cards have no payment
+	var payment store.Payment
+	if !order.Payment.IsZero() {
+		err = payColl.FindOne(ctx, bson.D{{Key: "_id", Value: order.Payment}}).Decode(&payment)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	cancelled := order.Status == string(types.ORDER_CANCELLED) || order.Status == string(types.ORDER_REJECTED)
+	refund, err := internal.ClaimRefund(ctx, s.Store, order, store.Refund{
+		Id:         primitive.NewObjectID(),
+		Order:      order.Id,
+		Payment:    payment.Id,
+		Customer:   order.Owner,
+		Provider:   payment.Provider,
+		Items:      items,
+		Amount:     amount,
+		GiftCards:  internal.GiftCardRefunds(order, amount),
+		Reason:     refundPayload.Reason,
+		Status:     string(types.REFUND_PENDING),
+		RefundedBy: userInfo.Id,
+		Restock:    !cancelled,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	})
+	if err != nil {
+		if errors.Is(err, internal.ErrRefundConflict) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var providerRefundID string
+	charged := refund.Amount.Sub(internal.RefundGiftCardTotal(refund))
+	if charged.Amount > 0 {
+		providerRefund, err := s.paymentProvider.Refund(ctx, payment.IntentId, charged, refund.Reason)
+		if err != nil {
+			_, _, failErr := internal.FailRefund(ctx, s.Store, refund.Id, err.Error())
+			if failErr != nil {
+				return internal.ResponseHandler(w, internal.NewErrorResponse("failed", failErr.Error()), http.StatusInternalServerError)
+			}
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadGateway)
+		}
+		providerRefundID = providerRefund.Id
+	}
+
+	refund, order, err = internal.CompleteRefund(ctx, s.Store, refund.Id, providerRefundID)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	opts := []asynq.Option{
+		asynq.MaxRetry(10),
+		asynq.Queue(workers.DefaultQueue),
+	}
+	err = s.taskDistributor.RefundReceiptMailTask(ctx, &types.PayloadRefund{RefundId: refund.Id.Hex()}, opts...)
+	if err != nil {
+		// the money already moved; failing now would invite a second refund
+		log.Error().Err(err).Str("refund", refund.Id.Hex()).Msg("queueing refund receipt failed")
+	}
+
+	result := struct {
+		Status string `json:"status"`
+		Data   struct {
+			Refund store.Refund `json:"refund"`
+			Order  store.Order  `json:"order"`
+		} `json:"data"`
+	}{Status: "success"}
+	result.Data.Refund = refund
+	result.Data.Order = order
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetOrderRefundsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+	refColl := s.Store.Collection(ctx, "coffeeshop", "refunds")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	var order store.Order
+	err = ordColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if order.Owner != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to retrieve their own refunds")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	cur, err := refColl.Find(ctx, bson.D{{Key: "order", Value: order.Id}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	records := []store.Refund{}
+	if err := cur.All(ctx, &records); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string         `json:"status"`
+		Results int32          `json:"results"`
+		Data    []store.Refund `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(records)),
+		Data:    records,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hibiken/asynq"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/silaselisha/coffee-api/workers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reservationReminderLead is how long before a booking the reminder mail is
+// sent.
+const reservationReminderLead = 2 * time.Hour
+
+func (s *Server) CreateTableHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tabColl := s.Store.Collection(ctx, "coffeeshop", "tables")
+
+	tablePayload, err := internal.ReadReqBody[types.TableParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	_, err = tabColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	table := store.CoffeeDateTable{
+		Id:        primitive.NewObjectID(),
+		Name:      tablePayload.Name,
+		Capacity:  tablePayload.Capacity,
+		Location:  tablePayload.Location,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err = tabColl.InsertOne(ctx, table)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			err := fmt.Errorf("table %q already exists", table.Name)
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string                `json:"status"`
+		Data   store.CoffeeDateTable `json:"data"`
+	}{
+		Status: "success",
+		Data:   table,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetAllTablesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tabColl := s.Store.Collection(ctx, "coffeeshop", "tables")
+
+	filter := bson.D{}
+	if location := r.URL.Query().Get("location"); location != "" {
+		filter = append(filter, bson.E{Key: "location", Value: location})
+	}
+
+	cur, err := tabColl.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	tables := []store.CoffeeDateTable{}
+	if err := cur.All(ctx, &tables); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string                  `json:"status"`
+		Results int32                   `json:"results"`
+		Data    []store.CoffeeDateTable `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(tables)),
+		Data:    tables,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) UpdateTableHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tabColl := s.Store.Collection(ctx, "coffeeshop", "tables")
+	resColl := s.Store.Collection(ctx, "coffeeshop", "reservations")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	tablePayload, err := internal.ReadReqBody[types.TableParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	oversized, err := resColl.CountDocuments(ctx, bson.D{
+		{Key: "table", Value: id},
+		{Key: "status", Value: string(types.RESERVATION_CONFIRMED)},
+		{Key: "ends_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+		{Key: "party_size", Value: bson.D{{Key: "$gt", Value: tablePayload.Capacity}}},
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if oversized > 0 {
+		err := fmt.Errorf("table has %d upcoming reservation(s) larger than %d guests", oversized, tablePayload.Capacity)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+	}
+
+	var table store.CoffeeDateTable
+	err = tabColl.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: tablePayload.Name},
+		{Key: "capacity", Value: tablePayload.Capacity},
+		{Key: "location", Value: tablePayload.Location},
+		{Key: "updated_at", Value: time.Now()},
+	}}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&table)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		case mongo.IsDuplicateKeyError(err):
+			err := fmt.Errorf("table %q already exists", tablePayload.Name)
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		default:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	result := struct {
+		Status string                `json:"status"`
+		Data   store.CoffeeDateTable `json:"data"`
+	}{
+		Status: "success",
+		Data:   table,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) DeleteTableHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tabColl := s.Store.Collection(ctx, "coffeeshop", "tables")
+	resColl := s.Store.Collection(ctx, "coffeeshop", "reservations")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	upcoming, err := resColl.CountDocuments(ctx, bson.D{
+		{Key: "table", Value: id},
+		{Key: "status", Value: string(types.RESERVATION_CONFIRMED)},
+		{Key: "ends_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if upcoming > 0 {
+		err := fmt.Errorf("table has %d upcoming reservation(s)", upcoming)
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+	}
+
+	result, err := tabColl.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if result.DeletedCount == 0 {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", "document not found"), http.StatusNotFound)
+	}
+
+	return internal.ResponseHandler(w, "", http.StatusNoContent)
+}
+
+func (s *Server) CreateReservationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tabColl := s.Store.Collection(ctx, "coffeeshop", "tables")
+
+	reservationPayload, err := internal.ReadReqBody[types.ReservationParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	if !reservationPayload.StartsAt.After(time.Now()) {
+		err := errors.New("reservations must start in the future")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	duration := internal.DefaultReservationDuration
+	if reservationPayload.Duration > 0 {
+		duration = time.Duration(reservationPayload.Duration) * time.Minute
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	// smallest table that seats the party first, keeping larger ones free
+	filter := bson.D{{Key: "capacity", Value: bson.D{{Key: "$gte", Value: reservationPayload.PartySize}}}}
+	if reservationPayload.Table != "" {
+		tableID, err := primitive.ObjectIDFromHex(reservationPayload.Table)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: tableID})
+	}
+	cur, err := tabColl.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "capacity", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	var candidates []store.CoffeeDateTable
+	if err := cur.All(ctx, &candidates); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	startsAt := reservationPayload.StartsAt.UTC()
+	reservation := store.Reservation{
+		Id:        primitive.NewObjectID(),
+		Customer:  userInfo.Id,
+		PartySize: reservationPayload.PartySize,
+		StartsAt:  startsAt,
+		EndsAt:    startsAt.Add(duration),
+		Status:    string(types.RESERVATION_CONFIRMED),
+		Note:      reservationPayload.Note,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	session, err := s.Store.TxnStartSession(ctx)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer session.EndSession(ctx)
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		tabColl := s.Store.Collection(ctx, "coffeeshop", "tables")
+		resColl := s.Store.Collection(ctx, "coffeeshop", "reservations")
+
+		return internal.BookTable(ctx, tabColl, resColl, candidates, reservation)
+	}, &options.TransactionOptions{})
+	if err != nil {
+		if errors.Is(err, internal.ErrNoTableAvailable) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	reservation = response.(store.Reservation)
+
+	payload := &types.PayloadReservationNotification{ReservationId: reservation.Id.Hex(), Email: userInfo.Email}
+	opts := []asynq.Option{
+		asynq.MaxRetry(5),
+		asynq.ProcessIn(5 * time.Second),
+		asynq.Queue(workers.DefaultQueue),
+	}
+	err = s.taskDistributor.ReservationConfirmationMailTask(ctx, payload, opts...)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if remindAt := reservation.StartsAt.Add(-reservationReminderLead); remindAt.After(time.Now()) {
+		opts := []asynq.Option{
+			asynq.MaxRetry(3),
+			asynq.ProcessAt(remindAt),
+			asynq.Queue(workers.DefaultQueue),
+		}
+		err = s.taskDistributor.ReservationReminderMailTask(ctx, payload, opts...)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	result := struct {
+		Status string            `json:"status"`
+		Data   store.Reservation `json:"data"`
+	}{
+		Status: "success",
+		Data:   reservation,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetAllReservationsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	resColl := s.Store.Collection(ctx, "coffeeshop", "reservations")
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	queries := r.URL.Query()
+
+	limit, err := internal.PageLimit(queries)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	filter := bson.D{}
+	if status := queries.Get("status"); status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	if !isStaff(userInfo.Role) {
+		filter = append(filter, bson.E{Key: "customer", Value: userInfo.Id})
+	} else if table := queries.Get("table"); table != "" {
+		tableID, err := primitive.ObjectIDFromHex(table)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "table", Value: tableID})
+	}
+
+	startsAt := bson.D{}
+	for _, bound := range []struct{ param, operator string }{{"from", "$gte"}, {"to", "$lte"}} {
+		value := queries.Get(bound.param)
+		if value == "" {
+			continue
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid %s date %w", bound.param, err).Error()), http.StatusBadRequest)
+		}
+		startsAt = append(startsAt, bson.E{Key: bound.operator, Value: timestamp})
+	}
+	if len(startsAt) > 0 {
+		filter = append(filter, bson.E{Key: "starts_at", Value: startsAt})
+	}
+
+	if cursor := queries.Get("cursor"); cursor != "" {
+		lastID, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid cursor %w", err).Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: lastID}}})
+	}
+
+	// fetch one extra document to find out whether another page exists
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	cur, err := resColl.Find(ctx, filter, opts)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	reservations := []store.Reservation{}
+	if err := cur.All(ctx, &reservations); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var nextCursor string
+	if int64(len(reservations)) > limit {
+		reservations = reservations[:limit]
+		nextCursor = reservations[len(reservations)-1].Id.Hex()
+	}
+
+	result := struct {
+		Status     string              `json:"status"`
+		Results    int32               `json:"results"`
+		NextCursor string              `json:"next_cursor,omitempty"`
+		Data       []store.Reservation `json:"data"`
+	}{
+		Status:     "success",
+		Results:    int32(len(reservations)),
+		NextCursor: nextCursor,
+		Data:       reservations,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) GetReservationByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	reservation, res, code := s.findReservation(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	if reservation.Customer != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to retrieve their own reservations")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	result := struct {
+		Status string            `json:"status"`
+		Data   store.Reservation `json:"data"`
+	}{
+		Status: "success",
+		Data:   reservation,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) CancelReservationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	resColl := s.Store.Collection(ctx, "coffeeshop", "reservations")
+
+	reservation, res, code := s.findReservation(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	if reservation.Customer != userInfo.Id && !isStaff(userInfo.Role) {
+		err := errors.New("user only allowed to cancel their own reservations")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	if !reservation.StartsAt.After(time.Now()) {
+		err := errors.New("reservation has already started")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+	}
+
+	var updated store.Reservation
+	err := resColl.FindOneAndUpdate(ctx, bson.D{
+		{Key: "_id", Value: reservation.Id},
+		{Key: "status", Value: string(types.RESERVATION_CONFIRMED)},
+	}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: string(types.RESERVATION_CANCELLED)},
+		{Key: "updated_at", Value: time.Now()},
+	}}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			err := fmt.Errorf("reservation is %s and cannot be cancelled", reservation.Status)
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string            `json:"status"`
+		Data   store.Reservation `json:"data"`
+	}{
+		Status: "success",
+		Data:   updated,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// findReservation loads the reservation named by the {id} route variable.
+// When it fails the error response and status code to send are returned.
+func (s *Server) findReservation(ctx context.Context, r *http.Request) (store.Reservation, *types.ErrorResParams, int) {
+	resColl := s.Store.Collection(ctx, "coffeeshop", "reservations")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return store.Reservation{}, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest
+	}
+
+	var reservation store.Reservation
+	err = resColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&reservation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Reservation{}, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound
+		}
+		return store.Reservation{}, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError
+	}
+	return reservation, nil, http.StatusOK
+}
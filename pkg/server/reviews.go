@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) CreateReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	prodColl := s.Store.Collection(ctx, "coffeeshop", "products")
+	ordColl := s.Store.Collection(ctx, "coffeeshop", "orders")
+	revColl := s.Store.Collection(ctx, "coffeeshop", "reviews")
+
+	params := mux.Vars(r)
+	productID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	reviewPayload, err := internal.ReadReqBody[types.ReviewParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	err = prodColl.FindOne(ctx, bson.D{{Key: "_id", Value: productID}}).Err()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	completed, err := ordColl.CountDocuments(ctx, bson.D{
+		{Key: "owner", Value: userInfo.Id},
+		{Key: "status", Value: string(types.ORDER_COMPLETED)},
+		{Key: "items.product", Value: productID},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if completed == 0 {
+		err := errors.New("only customers with a completed order of this product can review it")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	// created outside the transaction, which cannot add indexes to a
+	// collection that already holds documents
+	_, err = revColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product", Value: 1}, {Key: "author", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	review, err := s.changeReview(ctx, productID, func(ctx mongo.SessionContext, revColl *mongo.Collection) (store.Review, error) {
+		review := store.Review{
+			Id:        primitive.NewObjectID(),
+			Product:   productID,
+			Author:    userInfo.Id,
+			Rating:    reviewPayload.Rating,
+			Comment:   reviewPayload.Comment,
+			Status:    string(types.REVIEW_PUBLISHED),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+
+		_, err := revColl.InsertOne(ctx, review)
+		return review, err
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			err := errors.New("product already reviewed, update the existing review instead")
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string       `json:"status"`
+		Data   store.Review `json:"data"`
+	}{
+		Status: "success",
+		Data:   review,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetProductReviewsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	revColl := s.Store.Collection(ctx, "coffeeshop", "reviews")
+
+	params := mux.Vars(r)
+	productID, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	queries := r.URL.Query()
+	limit, err := internal.PageLimit(queries)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	filter := bson.D{
+		{Key: "product", Value: productID},
+		{Key: "status", Value: string(types.REVIEW_PUBLISHED)},
+	}
+	if cursor := queries.Get("cursor"); cursor != "" {
+		lastID, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", fmt.Errorf("invalid cursor %w", err).Error()), http.StatusBadRequest)
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: lastID}}})
+	}
+
+	// fetch one extra document to find out whether another page exists
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	cur, err := revColl.Find(ctx, filter, opts)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	reviews := []store.Review{}
+	if err := cur.All(ctx, &reviews); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	var nextCursor string
+	if int64(len(reviews)) > limit {
+		reviews = reviews[:limit]
+		nextCursor = reviews[len(reviews)-1].Id.Hex()
+	}
+
+	result := struct {
+		Status     string         `json:"status"`
+		Results    int32          `json:"results"`
+		NextCursor string         `json:"next_cursor,omitempty"`
+		Data       []store.Review `json:"data"`
+	}{
+		Status:     "success",
+		Results:    int32(len(reviews)),
+		NextCursor: nextCursor,
+		Data:       reviews,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) UpdateReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	reviewPayload, err := internal.ReadReqBody[types.ReviewParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	review, res, code := s.findReview(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	if review.Author != userInfo.Id {
+		err := errors.New("user only allowed to update their own reviews")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	updated, err := s.changeReview(ctx, review.Product, func(ctx mongo.SessionContext, revColl *mongo.Collection) (store.Review, error) {
+		var updated store.Review
+		err := revColl.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: review.Id}}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "rating", Value: reviewPayload.Rating},
+			{Key: "comment", Value: reviewPayload.Comment},
+			{Key: "updated_at", Value: time.Now()},
+		}}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		return updated, err
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string       `json:"status"`
+		Data   store.Review `json:"data"`
+	}{
+		Status: "success",
+		Data:   updated,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) ModerateReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	moderationPayload, err := internal.ReadReqBody[types.ReviewModerationParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	review, res, code := s.findReview(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	updated, err := s.changeReview(ctx, review.Product, func(ctx mongo.SessionContext, revColl *mongo.Collection) (store.Review, error) {
+		var updated store.Review
+		err := revColl.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: review.Id}}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: moderationPayload.Status},
+			{Key: "moderated_by", Value: userInfo.Id},
+			{Key: "moderation_note", Value: moderationPayload.Note},
+			{Key: "updated_at", Value: time.Now()},
+		}}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		return updated, err
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string       `json:"status"`
+		Data   store.Review `json:"data"`
+	}{
+		Status: "success",
+		Data:   updated,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) DeleteReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	review, res, code := s.findReview(ctx, r)
+	if res != nil {
+		return internal.ResponseHandler(w, res, code)
+	}
+
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+	if review.Author != userInfo.Id && userInfo.Role != "admin" {
+		err := errors.New("user only allowed to delete their own reviews")
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusForbidden)
+	}
+
+	_, err := s.changeReview(ctx, review.Product, func(ctx mongo.SessionContext, revColl *mongo.Collection) (store.Review, error) {
+		_, err := revColl.DeleteOne(ctx, bson.D{{Key: "_id", Value: review.Id}})
+		return review, err
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	return internal.ResponseHandler(w, "", http.StatusNoContent)
+}
+
+// findReview loads the review named by the {id} route variable. When it
+// fails the error response and status code to send are returned instead.
+func (s *Server) findReview(ctx context.Context, r *http.Request) (store.Review, *types.ErrorResParams, int) {
+	revColl := s.Store.Collection(ctx, "coffeeshop", "reviews")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return store.Review{}, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest
+	}
+
+	var review store.Review
+	err = revColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&review)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Review{}, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound
+		}
+		return store.Review{}, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError
+	}
+	return review, nil, http.StatusOK
+}
+
+// changeReview applies change and recomputes the product's ratings in one
+// transaction, so the totals always match the reviews that produced them.
+func (s *Server) changeReview(ctx context.Context, product primitive.ObjectID, change func(ctx mongo.SessionContext, revColl *mongo.Collection) (store.Review, error)) (store.Review, error) {
+	session, err := s.Store.TxnStartSession(ctx)
+	if err != nil {
+		return store.Review{}, err
+	}
+	defer session.EndSession(ctx)
+
+	response, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		revColl := s.Store.Collection(ctx, "coffeeshop", "reviews")
+		prodColl := s.Store.Collection(ctx, "coffeeshop", "products")
+
+		review, err := change(ctx, revColl)
+		if err != nil {
+			return nil, err
+		}
+
+		err = internal.RecomputeProductRatings(ctx, revColl, prodColl, product)
+		if err != nil {
+			return nil, err
+		}
+		return review, nil
+	}, &options.TransactionOptions{})
+	if err != nil {
+		return store.Review{}, err
+	}
+
+	return response.(store.Review), nil
+}
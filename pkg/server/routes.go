@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/silaselisha/coffee-api/internal"
@@ -14,7 +15,7 @@ func productRoutes(gmux *mux.Router, srv *Server) {
 	deleteItemsRouter := gmux.Methods(http.MethodDelete).Subrouter()
 	updateItemsRouter := gmux.Methods(http.MethodPut).Subrouter()
 
-	postItemsRouter.Use(middleware.AuthMiddleware(srv.Token))
+	postItemsRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 	postProductsRouter := postItemsRouter.PathPrefix("/").Subrouter()
 	postProductsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
 	postProductsRouter.HandleFunc("/products", internal.HandleFuncDecorator(srv.CreateProductHandler))
@@ -22,12 +23,12 @@ func productRoutes(gmux *mux.Router, srv *Server) {
 	getItemsRouter.HandleFunc("/products", internal.HandleFuncDecorator(srv.GetAllProductsHandler))
 	getItemsRouter.HandleFunc("/products/{category}/{id}", internal.HandleFuncDecorator(srv.GetProductByIdHandler))
 
-	deleteItemsRouter.Use(middleware.AuthMiddleware(srv.Token))
+	deleteItemsRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 	deleteProductsRouter := deleteItemsRouter.PathPrefix("/products").Subrouter()
 	deleteProductsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
 	deleteProductsRouter.HandleFunc("/{id}", internal.HandleFuncDecorator(srv.DeleteProductByIdHandler))
 
-	updateItemsRouter.Use(middleware.AuthMiddleware(srv.Token))
+	updateItemsRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 	updateProductsRouter := updateItemsRouter.PathPrefix("/products").Subrouter()
 	updateProductsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
 	updateProductsRouter.HandleFunc("/{id}", internal.HandleFuncDecorator(srv.UpdateProductHandler))
@@ -42,7 +43,7 @@ func userRoutes(gmux *mux.Router, srv *Server) {
 	resetPasswordRouter := gmux.Methods(http.MethodPut).Subrouter()
 	deleteUserRouter := gmux.Methods(http.MethodDelete).Subrouter()
 
-	userGetRouter.Use(middleware.AuthMiddleware(srv.Token))
+	userGetRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 
 	getAllUsersRouter := userGetRouter.PathPrefix("/").Subrouter()
 	getAllUsersRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
@@ -54,12 +55,23 @@ func userRoutes(gmux *mux.Router, srv *Server) {
 
 	postUserRouter.HandleFunc("/signup", internal.HandleFuncDecorator(srv.CreateUserHandler))
 	postUserRouter.HandleFunc("/login", internal.HandleFuncDecorator(srv.LoginUserHandler))
+	postUserRouter.HandleFunc("/token/refresh", internal.HandleFuncDecorator(srv.RefreshTokenHandler))
 
-	updateUserRouter.Use(middleware.AuthMiddleware(srv.Token))
+	logoutRouter := gmux.Methods(http.MethodPost).Subrouter()
+	logoutRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	logoutRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	logoutRouter.HandleFunc("/logout", internal.HandleFuncDecorator(srv.LogoutHandler))
+
+	streamTicketRouter := gmux.Methods(http.MethodPost).Subrouter()
+	streamTicketRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	streamTicketRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	streamTicketRouter.HandleFunc("/streams/tickets", internal.HandleFuncDecorator(srv.StreamTicketHandler))
+
+	updateUserRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 	updateUserRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin", "user"))
 	updateUserRouter.HandleFunc("/users/{id}", internal.HandleFuncDecorator(srv.UpdateUserByIdHandler))
 
-	deleteUserRouter.Use(middleware.AuthMiddleware(srv.Token))
+	deleteUserRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 	deleteUserRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin", "user"))
 	deleteUserRouter.HandleFunc("/users/{id}", internal.HandleFuncDecorator(srv.DeleteUserByIdHandler))
 	forgotPasswordRouter.HandleFunc("/forgotpassword", internal.HandleFuncDecorator(srv.ForgotPasswordHandler))
@@ -68,7 +80,226 @@ func userRoutes(gmux *mux.Router, srv *Server) {
 
 func orderRoutes(gmux *mux.Router, srv *Server) {
 	orderRouter := gmux.Methods(http.MethodPost).Subrouter()
-	orderRouter.Use(middleware.AuthMiddleware(srv.Token))
+	orderRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
 	orderRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin"))
+	orderRouter.Use(middleware.IdempotencyMiddleware(srv.Store, 24*time.Hour))
 	orderRouter.HandleFunc("/products/orders", internal.HandleFuncDecorator(srv.CreateOrderHandler))
+	orderRouter.HandleFunc("/orders/{id}/cancel", internal.HandleFuncDecorator(srv.CancelOrderHandler))
+	orderRouter.HandleFunc("/orders/{id}/payments", internal.HandleFuncDecorator(srv.PayOrderHandler))
+
+	getOrdersRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getOrdersRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getOrdersRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	getOrdersRouter.HandleFunc("/orders", internal.HandleFuncDecorator(srv.GetAllOrdersHandler))
+	getOrdersRouter.HandleFunc("/orders/{id}", internal.HandleFuncDecorator(srv.GetOrderByIdHandler))
+	getOrdersRouter.HandleFunc("/orders/{id}/payments", internal.HandleFuncDecorator(srv.GetOrderPaymentsHandler))
+	getOrdersRouter.HandleFunc("/orders/{id}/refunds", internal.HandleFuncDecorator(srv.GetOrderRefundsHandler))
+
+	// browsers open the stream with a ticket from /streams/tickets
+	orderEventsRouter := gmux.Methods(http.MethodGet).Subrouter()
+	orderEventsRouter.Use(middleware.StreamAuthMiddleware(srv.Token, srv.Store))
+	orderEventsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	orderEventsRouter.HandleFunc("/orders/{id}/events", internal.HandleFuncDecorator(srv.OrderEventsHandler))
+
+	refundOrderRouter := gmux.Methods(http.MethodPost).Subrouter()
+	refundOrderRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	refundOrderRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	refundOrderRouter.Use(middleware.IdempotencyMiddleware(srv.Store, 24*time.Hour))
+	refundOrderRouter.HandleFunc("/orders/{id}/refunds", internal.HandleFuncDecorator(srv.RefundOrderHandler))
+
+	updateOrderRouter := gmux.Methods(http.MethodPut).Subrouter()
+	updateOrderRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	updateOrderRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin", "barista"))
+	updateOrderRouter.HandleFunc("/orders/{id}/status", internal.HandleFuncDecorator(srv.UpdateOrderStatusHandler))
+}
+
+func reviewRoutes(gmux *mux.Router, srv *Server) {
+	getReviewsRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getReviewsRouter.HandleFunc("/products/{id}/reviews", internal.HandleFuncDecorator(srv.GetProductReviewsHandler))
+
+	postReviewRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postReviewRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postReviewRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin"))
+	postReviewRouter.HandleFunc("/products/{id}/reviews", internal.HandleFuncDecorator(srv.CreateReviewHandler))
+
+	updateReviewRouter := gmux.Methods(http.MethodPut).Subrouter()
+	updateReviewRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	updateReviewRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin"))
+	updateReviewRouter.HandleFunc("/reviews/{id}", internal.HandleFuncDecorator(srv.UpdateReviewHandler))
+
+	moderateReviewRouter := gmux.Methods(http.MethodPut).Subrouter()
+	moderateReviewRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	moderateReviewRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	moderateReviewRouter.HandleFunc("/reviews/{id}/moderation", internal.HandleFuncDecorator(srv.ModerateReviewHandler))
+
+	deleteReviewRouter := gmux.Methods(http.MethodDelete).Subrouter()
+	deleteReviewRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	deleteReviewRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin"))
+	deleteReviewRouter.HandleFunc("/reviews/{id}", internal.HandleFuncDecorator(srv.DeleteReviewHandler))
+}
+
+func reservationRoutes(gmux *mux.Router, srv *Server) {
+	getTablesRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getTablesRouter.HandleFunc("/tables", internal.HandleFuncDecorator(srv.GetAllTablesHandler))
+
+	postTableRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postTableRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postTableRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	postTableRouter.HandleFunc("/tables", internal.HandleFuncDecorator(srv.CreateTableHandler))
+
+	updateTableRouter := gmux.Methods(http.MethodPut).Subrouter()
+	updateTableRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	updateTableRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	updateTableRouter.HandleFunc("/tables/{id}", internal.HandleFuncDecorator(srv.UpdateTableHandler))
+
+	deleteTableRouter := gmux.Methods(http.MethodDelete).Subrouter()
+	deleteTableRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	deleteTableRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	deleteTableRouter.HandleFunc("/tables/{id}", internal.HandleFuncDecorator(srv.DeleteTableHandler))
+
+	postReservationRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postReservationRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postReservationRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin"))
+	postReservationRouter.Use(middleware.IdempotencyMiddleware(srv.Store, 24*time.Hour))
+	postReservationRouter.HandleFunc("/reservations", internal.HandleFuncDecorator(srv.CreateReservationHandler))
+
+	getReservationsRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getReservationsRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getReservationsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	getReservationsRouter.HandleFunc("/reservations", internal.HandleFuncDecorator(srv.GetAllReservationsHandler))
+	getReservationsRouter.HandleFunc("/reservations/{id}", internal.HandleFuncDecorator(srv.GetReservationByIdHandler))
+
+	cancelReservationRouter := gmux.Methods(http.MethodPut).Subrouter()
+	cancelReservationRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	cancelReservationRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	cancelReservationRouter.HandleFunc("/reservations/{id}/cancel", internal.HandleFuncDecorator(srv.CancelReservationHandler))
+}
+
+func invoiceRoutes(gmux *mux.Router, srv *Server) {
+	getInvoicesRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getInvoicesRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getInvoicesRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	getInvoicesRouter.HandleFunc("/invoices/{id}", internal.HandleFuncDecorator(srv.GetInvoiceByIdHandler))
+	getInvoicesRouter.HandleFunc("/invoices/{id}/pdf", internal.HandleFuncDecorator(srv.DownloadInvoiceHandler))
+}
+
+func promotionRoutes(gmux *mux.Router, srv *Server) {
+	postPromotionRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postPromotionRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postPromotionRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	postPromotionRouter.HandleFunc("/promotions", internal.HandleFuncDecorator(srv.CreatePromotionHandler))
+
+	getPromotionsRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getPromotionsRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getPromotionsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	getPromotionsRouter.HandleFunc("/promotions", internal.HandleFuncDecorator(srv.GetAllPromotionsHandler))
+	getPromotionsRouter.HandleFunc("/promotions/{id}", internal.HandleFuncDecorator(srv.GetPromotionByIdHandler))
+
+	updatePromotionRouter := gmux.Methods(http.MethodPut).Subrouter()
+	updatePromotionRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	updatePromotionRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	updatePromotionRouter.HandleFunc("/promotions/{id}", internal.HandleFuncDecorator(srv.UpdatePromotionHandler))
+
+	deletePromotionRouter := gmux.Methods(http.MethodDelete).Subrouter()
+	deletePromotionRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	deletePromotionRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	deletePromotionRouter.HandleFunc("/promotions/{id}", internal.HandleFuncDecorator(srv.DeletePromotionHandler))
+}
+
+func taxRoutes(gmux *mux.Router, srv *Server) {
+	postTaxRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postTaxRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postTaxRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	postTaxRouter.HandleFunc("/taxes", internal.HandleFuncDecorator(srv.CreateTaxRuleHandler))
+
+	getTaxesRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getTaxesRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getTaxesRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	getTaxesRouter.HandleFunc("/taxes", internal.HandleFuncDecorator(srv.GetAllTaxRulesHandler))
+
+	updateTaxRouter := gmux.Methods(http.MethodPut).Subrouter()
+	updateTaxRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	updateTaxRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	updateTaxRouter.HandleFunc("/taxes/{id}", internal.HandleFuncDecorator(srv.UpdateTaxRuleHandler))
+
+	deleteTaxRouter := gmux.Methods(http.MethodDelete).Subrouter()
+	deleteTaxRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	deleteTaxRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	deleteTaxRouter.HandleFunc("/taxes/{id}", internal.HandleFuncDecorator(srv.DeleteTaxRuleHandler))
+}
+
+func locationRoutes(gmux *mux.Router, srv *Server) {
+	getLocationsRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getLocationsRouter.HandleFunc("/locations", internal.HandleFuncDecorator(srv.GetAllLocationsHandler))
+	getLocationsRouter.HandleFunc("/locations/{id}", internal.HandleFuncDecorator(srv.GetLocationByIdHandler))
+	getLocationsRouter.HandleFunc("/locations/{id}/menu", internal.HandleFuncDecorator(srv.GetLocationMenuHandler))
+	getLocationsRouter.HandleFunc("/locations/{id}/pickup-slots", internal.HandleFuncDecorator(srv.GetPickupSlotsHandler))
+
+	postLocationRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postLocationRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postLocationRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	postLocationRouter.HandleFunc("/locations", internal.HandleFuncDecorator(srv.CreateLocationHandler))
+
+	updateLocationRouter := gmux.Methods(http.MethodPut).Subrouter()
+	updateLocationRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	updateLocationRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	updateLocationRouter.HandleFunc("/locations/{id}", internal.HandleFuncDecorator(srv.UpdateLocationHandler))
+	updateLocationRouter.HandleFunc("/locations/{id}/products/{product}", internal.HandleFuncDecorator(srv.SetLocationProductHandler))
+
+	deleteLocationRouter := gmux.Methods(http.MethodDelete).Subrouter()
+	deleteLocationRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	deleteLocationRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	deleteLocationRouter.HandleFunc("/locations/{id}", internal.HandleFuncDecorator(srv.DeleteLocationHandler))
+	deleteLocationRouter.HandleFunc("/locations/{id}/products/{product}", internal.HandleFuncDecorator(srv.DeleteLocationProductHandler))
+}
+
+func loyaltyRoutes(gmux *mux.Router, srv *Server) {
+	getLoyaltyRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getLoyaltyRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getLoyaltyRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	getLoyaltyRouter.HandleFunc("/loyalty", internal.HandleFuncDecorator(srv.GetLoyaltyBalanceHandler))
+	getLoyaltyRouter.HandleFunc("/loyalty/transactions", internal.HandleFuncDecorator(srv.GetLoyaltyTransactionsHandler))
+}
+
+func giftCardRoutes(gmux *mux.Router, srv *Server) {
+	postGiftCardRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postGiftCardRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postGiftCardRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	postGiftCardRouter.Use(middleware.IdempotencyMiddleware(srv.Store, 24*time.Hour))
+	postGiftCardRouter.HandleFunc("/giftcards", internal.HandleFuncDecorator(srv.CreateGiftCardHandler))
+
+	getGiftCardsRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getGiftCardsRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getGiftCardsRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin"))
+	getGiftCardsRouter.HandleFunc("/giftcards", internal.HandleFuncDecorator(srv.GetAllGiftCardsHandler))
+
+	giftCardBalanceRouter := gmux.Methods(http.MethodGet).Subrouter()
+	giftCardBalanceRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	giftCardBalanceRouter.Use(middleware.RestrictToMiddleware(srv.Store, "user", "admin", "barista"))
+	giftCardBalanceRouter.HandleFunc("/giftcards/{code}/balance", internal.HandleFuncDecorator(srv.GetGiftCardBalanceHandler))
+}
+
+func kitchenRoutes(gmux *mux.Router, srv *Server) {
+	getKitchenRouter := gmux.Methods(http.MethodGet).Subrouter()
+	getKitchenRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	getKitchenRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin", "barista"))
+	getKitchenRouter.HandleFunc("/kitchen/queue", internal.HandleFuncDecorator(srv.GetKitchenQueueHandler))
+
+	// browsers open the feed with a ticket from /streams/tickets
+	kitchenFeedRouter := gmux.Methods(http.MethodGet).Subrouter()
+	kitchenFeedRouter.Use(middleware.StreamAuthMiddleware(srv.Token, srv.Store))
+	kitchenFeedRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin", "barista"))
+	kitchenFeedRouter.HandleFunc("/kitchen/feed", internal.HandleFuncDecorator(srv.KitchenFeedHandler))
+
+	postKitchenRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postKitchenRouter.Use(middleware.AuthMiddleware(srv.Token, srv.Store))
+	postKitchenRouter.Use(middleware.RestrictToMiddleware(srv.Store, "admin", "barista"))
+	postKitchenRouter.HandleFunc("/kitchen/orders/{id}/bump", internal.HandleFuncDecorator(srv.BumpKitchenTicketHandler))
+	postKitchenRouter.HandleFunc("/kitchen/orders/{id}/recall", internal.HandleFuncDecorator(srv.RecallKitchenTicketHandler))
+}
+
+func webhookRoutes(gmux *mux.Router, srv *Server) {
+	// providers authenticate with a signature on the body, not a token
+	postWebhookRouter := gmux.Methods(http.MethodPost).Subrouter()
+	postWebhookRouter.HandleFunc("/webhooks/payments/{provider}", internal.HandleFuncDecorator(srv.PaymentWebhookHandler))
 }
@@ -4,14 +4,19 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
 	"github.com/silaselisha/coffee-api/internal"
 	"github.com/silaselisha/coffee-api/internal/aws"
 	"github.com/silaselisha/coffee-api/pkg/client"
+	"github.com/silaselisha/coffee-api/pkg/events"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/payments"
 	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/types"
 	"github.com/silaselisha/coffee-api/pkg/token"
@@ -27,6 +32,8 @@ type Server struct {
 	envs               *types.Config
 	Token              token.Token
 	taskDistributor    workers.TaskDistributor
+	paymentProvider    payments.Provider
+	events             events.Broker
 }
 
 func NewServer(ctx context.Context, envs *types.Config, mongoClient *mongo.Client, distributor workers.TaskDistributor, templQueries client.Querier, fileServer func() http.Handler) store.Querier {
@@ -39,9 +46,21 @@ func NewServer(ctx context.Context, envs *types.Config, mongoClient *mongo.Clien
 	render(router, templQueries, fileServer) // serve static files
 
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
+	// registered before products so /products/{id}/reviews is not taken
+	// for /products/{category}/{id}
+	reviewRoutes(apiRouter, server)
 	productRoutes(apiRouter, server)
 	userRoutes(apiRouter, server)
 	orderRoutes(apiRouter, server)
+	reservationRoutes(apiRouter, server)
+	invoiceRoutes(apiRouter, server)
+	promotionRoutes(apiRouter, server)
+	taxRoutes(apiRouter, server)
+	locationRoutes(apiRouter, server)
+	loyaltyRoutes(apiRouter, server)
+	giftCardRoutes(apiRouter, server)
+	kitchenRoutes(apiRouter, server)
+	webhookRoutes(apiRouter, server)
 
 	server.Router = router
 	return server
@@ -57,18 +76,33 @@ func newServerHelper(ctx context.Context, envs *types.Config, mongoClient *mongo
 		o.Region = "us-east-1"
 	})
 
-	tkn := token.NewToken(envs.SECRET_ACCESS_KEY)
+	paymentProvider, err := payments.NewProvider(envs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tkn := token.NewToken(envs.SECRET_ACCESS_KEY, token.NewRedisDenylist(&redis.Options{Addr: envs.REDIS_SERVER_ADDRESS}))
 	store := store.NewMongoClient(mongoClient)
 	server.coffeeShopS3Bucket = coffeShopS3Bucket
 	server.Store = store
 	server.envs = envs
 	server.Token = tkn
 	server.taskDistributor = distributor
+	server.paymentProvider = paymentProvider
+	server.events = events.NewRedisBroker(&redis.Options{Addr: envs.REDIS_SERVER_ADDRESS})
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	server.vd = validate
 }
 
+// currency is the ISO 4217 code new prices and orders are recorded in.
+func (s *Server) currency() string {
+	if s.envs.DEFAULT_CURRENCY == "" {
+		return money.DefaultCurrency
+	}
+	return strings.ToUpper(s.envs.DEFAULT_CURRENCY)
+}
+
 func render(router *mux.Router, templQueries client.Querier, fileServer func() http.Handler) {
 	router.PathPrefix("/public/").Handler(fileServer())
 	router.HandleFunc("/", internal.HandleFuncDecorator(templQueries.RenderHomePageHandler))
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *Server) CreateTaxRuleHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	taxColl := s.Store.Collection(ctx, "coffeeshop", "tax_rules")
+
+	taxPayload, err := internal.ReadReqBody[types.TaxRuleParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	rule, err := taxRuleFromParams(taxPayload)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	_, err = taxColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}, {Key: "category", Value: 1}, {Key: "location", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	rule.Id = primitive.NewObjectID()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	_, err = taxColl.InsertOne(ctx, rule)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", errDuplicateTaxRule(rule).Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string        `json:"status"`
+		Data   store.TaxRule `json:"data"`
+	}{
+		Status: "success",
+		Data:   rule,
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+func (s *Server) GetAllTaxRulesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	taxColl := s.Store.Collection(ctx, "coffeeshop", "tax_rules")
+
+	cur, err := taxColl.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	defer cur.Close(ctx)
+
+	rules := []store.TaxRule{}
+	if err := cur.All(ctx, &rules); err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status  string          `json:"status"`
+		Results int32           `json:"results"`
+		Data    []store.TaxRule `json:"data"`
+	}{
+		Status:  "success",
+		Results: int32(len(rules)),
+		Data:    rules,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) UpdateTaxRuleHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	taxColl := s.Store.Collection(ctx, "coffeeshop", "tax_rules")
+
+	current, errRes, code := s.findTaxRule(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	taxPayload, err := internal.ReadReqBody[types.TaxRuleParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	rule, err := taxRuleFromParams(taxPayload)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+	rule.Id = current.Id
+	rule.CreatedAt = current.CreatedAt
+	rule.UpdatedAt = time.Now()
+
+	_, err = taxColl.ReplaceOne(ctx, bson.D{{Key: "_id", Value: current.Id}}, rule)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", errDuplicateTaxRule(rule).Error()), http.StatusConflict)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string        `json:"status"`
+		Data   store.TaxRule `json:"data"`
+	}{
+		Status: "success",
+		Data:   rule,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+func (s *Server) DeleteTaxRuleHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	taxColl := s.Store.Collection(ctx, "coffeeshop", "tax_rules")
+
+	rule, errRes, code := s.findTaxRule(ctx, r)
+	if errRes != nil {
+		return internal.ResponseHandler(w, errRes, code)
+	}
+
+	_, err := taxColl.DeleteOne(ctx, bson.D{{Key: "_id", Value: rule.Id}})
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	return internal.ResponseHandler(w, "", http.StatusNoContent)
+}
+
+func (s *Server) findTaxRule(ctx context.Context, r *http.Request) (store.TaxRule, *types.ErrorResParams, int) {
+	taxColl := s.Store.Collection(ctx, "coffeeshop", "tax_rules")
+
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		return store.TaxRule{}, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest
+	}
+
+	var rule store.TaxRule
+	err = taxColl.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.TaxRule{}, internal.NewErrorResponse("failed", fmt.Errorf("document not found %w", err).Error()), http.StatusNotFound
+		}
+		return store.TaxRule{}, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError
+	}
+	return rule, nil, http.StatusOK
+}
+
+func taxRuleFromParams(params types.TaxRuleParams) (store.TaxRule, error) {
+	rate, err := internal.ParseTaxRate(params.Rate)
+	if err != nil {
+		return store.TaxRule{}, err
+	}
+	return store.TaxRule{
+		Name:      params.Name,
+		Rate:      rate,
+		Inclusive: params.Inclusive,
+		Category:  params.Category,
+		Location:  params.Location,
+	}, nil
+}
+
+func errDuplicateTaxRule(rule store.TaxRule) error {
+	return fmt.Errorf("a %s rule for category %q at location %q already exists", rule.Name, rule.Category, rule.Location)
+}
@@ -0,0 +1,201 @@
+package api__test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// readEvent reads the next event that carries data, skipping comments and
+// retry hints.
+func readEvent(t *testing.T, reader *bufio.Reader) (testEvent, error) {
+	var event testEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if event.data != "" {
+				return event, nil
+			}
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func openOrderEvents(t *testing.T, url, lastEventID string) *http.Response {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	return response
+}
+
+func setTestOrderStatus(t *testing.T, id primitive.ObjectID, status types.OrderStatus) {
+	data, err := json.Marshal(map[string]interface{}{"status": status})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/api/v1/orders/%s/status", id.Hex()), bytes.NewReader(data))
+	require.NoError(t, err)
+	request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+
+	server.Router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestOrderEvents(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	httpServer := httptest.NewServer(server.Router)
+	defer httpServer.Close()
+	url := fmt.Sprintf("%s/api/v1/orders/%s/events", httpServer.URL, order.Id.Hex())
+
+	response := openOrderEvents(t, url, "")
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+
+	setTestOrderStatus(t, order.Id, types.ORDER_ACCEPTED)
+	event, err := readEvent(t, reader)
+	require.NoError(t, err)
+	require.Equal(t, "1", event.id)
+	require.Equal(t, "status", event.name)
+
+	var data types.OrderEventResParams
+	require.NoError(t, json.Unmarshal([]byte(event.data), &data))
+	require.Equal(t, order.Id.Hex(), data.Order)
+	require.Equal(t, string(types.ORDER_PENDING), data.From)
+	require.Equal(t, string(types.ORDER_ACCEPTED), data.Status)
+
+	setTestOrderStatus(t, order.Id, types.ORDER_CANCELLED)
+	event, err = readEvent(t, reader)
+	require.NoError(t, err)
+	require.Equal(t, "2", event.id)
+	require.NoError(t, json.Unmarshal([]byte(event.data), &data))
+	require.Equal(t, string(types.ORDER_CANCELLED), data.Status)
+
+	// the stream ends with the order
+	_, err = readEvent(t, reader)
+	require.Error(t, err)
+
+	// a client resuming gets only what it missed
+	resumed := openOrderEvents(t, url, "1")
+	defer resumed.Body.Close()
+	reader = bufio.NewReader(resumed.Body)
+
+	event, err = readEvent(t, reader)
+	require.NoError(t, err)
+	require.Equal(t, "2", event.id)
+	_, err = readEvent(t, reader)
+	require.Error(t, err)
+}
+
+// createTestStreamTicket gets the admin a ticket opening the stream at path.
+func createTestStreamTicket(t *testing.T, path string) string {
+	data, err := json.Marshal(map[string]interface{}{"path": path})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest(http.MethodPost, "/api/v1/streams/tickets", bytes.NewReader(data))
+	require.NoError(t, err)
+	request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+	server.Router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+
+	var res struct {
+		Status string
+		Data   types.StreamTicketResParams
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	require.NotEmpty(t, res.Data.Ticket)
+	return res.Data.Ticket
+}
+
+func TestStreamTickets(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_CANCELLED)
+	other := createTestOrder(t, ownerID, types.ORDER_CANCELLED)
+	path := fmt.Sprintf("/api/v1/orders/%s/events", order.Id.Hex())
+
+	ticket := createTestStreamTicket(t, path)
+
+	testCases := []struct {
+		name   string
+		url    string
+		bearer bool
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name: "open the stream with a ticket | status 200",
+			url:  fmt.Sprintf("%s?ticket=%s", path, ticket),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+			},
+		},
+		{
+			name: "ticket for another stream | status 403",
+			url:  fmt.Sprintf("/api/v1/orders/%s/events?ticket=%s", other.Id.Hex(), ticket),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "ticket as an access token | status 403",
+			url:    fmt.Sprintf("/api/v1/orders/%s", order.Id.Hex()),
+			bearer: true,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			require.NoError(t, err)
+			if tc.bearer {
+				request.Header.Set("authorization", fmt.Sprintf("Bearer %s", ticket))
+			}
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
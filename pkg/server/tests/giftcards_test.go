@@ -0,0 +1,176 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGiftCards(t *testing.T) {
+	item := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Mocha %s", primitive.NewObjectID().Hex()),
+		Price:     money.New(400, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+	require.NoError(t, err)
+
+	serve := func(t *testing.T, method, url, token string, body interface{}) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest(method, url, bytes.NewReader(data))
+		require.NoError(t, err)
+		request.Header.Set("authorization", fmt.Sprintf("Bearer %s", token))
+		server.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	issue := func(t *testing.T, amount string) store.GiftCard {
+		recorder := serve(t, http.MethodPost, "/api/v1/giftcards", adminTestToken, map[string]interface{}{"amount": amount})
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		var res struct {
+			Status string
+			Data   store.GiftCard
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res.Data
+	}
+
+	balance := func(t *testing.T, code string) int64 {
+		recorder := serve(t, http.MethodGet, fmt.Sprintf("/api/v1/giftcards/%s/balance", code), userTestToken, nil)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var res struct {
+			Status string
+			Data   struct {
+				Balance money.Money
+			}
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res.Data.Balance.Amount
+	}
+
+	location := createTestLocation(t, fmt.Sprintf("Lavington %s", item.Id.Hex()[18:])).Id.Hex()
+
+	order := func(t *testing.T, quantity uint32, codes ...string) *httptest.ResponseRecorder {
+		return serve(t, http.MethodPost, "/api/v1/products/orders", adminTestToken, map[string]interface{}{
+			"items":      []map[string]interface{}{{"product": item.Id.Hex(), "quantity": quantity}},
+			"gift_cards": codes,
+			"location":   location,
+		})
+	}
+
+	refund := func(t *testing.T, id primitive.ObjectID) (store.Refund, store.Order) {
+		recorder := serve(t, http.MethodPost, fmt.Sprintf("/api/v1/orders/%s/refunds", id.Hex()), adminTestToken, map[string]interface{}{"reason": "order mixed up"})
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		var res struct {
+			Status string
+			Data   struct {
+				Refund store.Refund
+				Order  store.Order
+			}
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res.Data.Refund, res.Data.Order
+	}
+
+	t.Run("issue as a customer | status 403", func(t *testing.T) {
+		recorder := serve(t, http.MethodPost, "/api/v1/giftcards", userTestToken, map[string]interface{}{"amount": "5.00"})
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+	})
+
+	t.Run("pay part of an order and cancel it | status 201", func(t *testing.T) {
+		card := issue(t, "5.00")
+		require.Equal(t, int64(500), balance(t, card.Code))
+
+		recorder := order(t, 2, card.Code)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		var placed store.Order
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &placed))
+		require.Equal(t, int64(500), placed.GiftCardTotal.Amount)
+		require.Len(t, placed.GiftCards, 1)
+		require.NotEqual(t, card.Code, placed.GiftCards[0].Code)
+		require.Empty(t, placed.PaymentStatus)
+		require.Equal(t, int64(0), balance(t, card.Code))
+
+		recorder = order(t, 1, card.Code)
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+		recorder = serve(t, http.MethodPost, fmt.Sprintf("/api/v1/orders/%s/cancel", placed.Id.Hex()), adminTestToken, map[string]interface{}{"reason": "changed my mind"})
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, int64(500), balance(t, card.Code))
+	})
+
+	t.Run("pay a whole order with two cards | status 201", func(t *testing.T) {
+		first := issue(t, "3.00")
+		second := issue(t, "20.00")
+
+		recorder := order(t, 2, first.Code, second.Code)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		var placed store.Order
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &placed))
+		require.Equal(t, placed.TotalAmount, placed.GiftCardTotal)
+		require.Equal(t, types.PAID.String(), placed.PaymentStatus)
+		require.Equal(t, string(types.ORDER_ACCEPTED), placed.Status)
+		require.Equal(t, placed.TotalAmount, placed.PaidTotal)
+		require.Equal(t, int64(0), balance(t, first.Code))
+		require.Equal(t, int64(1500), balance(t, second.Code))
+
+		refunded, updated := refund(t, placed.Id)
+		require.Equal(t, string(types.REFUND_SUCCEEDED), refunded.Status)
+		require.Equal(t, placed.TotalAmount, refunded.Amount)
+		require.Len(t, refunded.GiftCards, 2)
+		require.Equal(t, types.REFUNDED.String(), updated.PaymentStatus)
+		require.Equal(t, int64(300), balance(t, first.Code))
+		require.Equal(t, int64(2000), balance(t, second.Code))
+	})
+
+	t.Run("refund an order paid partly with a card | status 201", func(t *testing.T) {
+		card := issue(t, "5.00")
+
+		recorder := order(t, 2, card.Code)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		var placed store.Order
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &placed))
+
+		recorder = serve(t, http.MethodPost, fmt.Sprintf("/api/v1/orders/%s/payments", placed.Id.Hex()), adminTestToken, map[string]interface{}{"payment_method": "fake_card_visa"})
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		var res struct {
+			Status string
+			Data   struct {
+				Payment store.Payment
+				Order   store.Order
+			}
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		require.Equal(t, placed.TotalAmount.Sub(placed.GiftCardTotal), res.Data.Payment.Amount)
+		require.Equal(t, placed.TotalAmount, res.Data.Order.PaidTotal)
+
+		refunded, updated := refund(t, placed.Id)
+		require.Equal(t, placed.TotalAmount, refunded.Amount)
+		require.Len(t, refunded.GiftCards, 1)
+		require.Equal(t, int64(500), refunded.GiftCards[0].Amount.Amount)
+		require.Equal(t, types.REFUNDED.String(), updated.PaymentStatus)
+		require.Equal(t, int64(500), balance(t, card.Code))
+	})
+
+	t.Run("unknown card | status 404", func(t *testing.T) {
+		recorder := order(t, 1, "AAAA-BBBB-CCCC-DDDD")
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
@@ -0,0 +1,97 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrderInvoice(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_READY)
+
+	body, err := json.Marshal(map[string]interface{}{"status": "completed"})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/api/v1/orders/%s/status", order.Id.Hex()), bytes.NewReader(body))
+	require.NoError(t, err)
+	request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+	server.Router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var completed struct {
+		Status string
+		Data   store.Order
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &completed))
+	require.False(t, completed.Data.Invoice.IsZero())
+
+	var invoice store.Invoice
+	invoices := mongoClient.Database("coffeeshop").Collection("invoices")
+	err = invoices.FindOne(context.Background(), bson.D{{Key: "_id", Value: completed.Data.Invoice}}).Decode(&invoice)
+	require.NoError(t, err)
+	require.Equal(t, order.Id, invoice.Order)
+
+	previous, err := invoices.CountDocuments(context.Background(), bson.D{
+		{Key: "year", Value: invoice.Year},
+		{Key: "sequence", Value: invoice.Sequence - 1},
+	})
+	require.NoError(t, err)
+	if invoice.Sequence > 1 {
+		require.Equal(t, int64(1), previous)
+	}
+
+	testCases := []struct {
+		name  string
+		url   string
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name: "get invoice | status 200",
+			url:  fmt.Sprintf("/api/v1/invoices/%s", invoice.Id.Hex()),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name: "download invoice pdf | status 200",
+			url:  fmt.Sprintf("/api/v1/invoices/%s/pdf", invoice.Id.Hex()),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, "application/pdf", recorder.Header().Get("Content-Type"))
+				require.True(t, bytes.HasPrefix(recorder.Body.Bytes(), []byte("%PDF-")))
+			},
+		},
+		{
+			name: "unknown invoice | status 404",
+			url:  fmt.Sprintf("/api/v1/invoices/%s", primitive.NewObjectID().Hex()),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
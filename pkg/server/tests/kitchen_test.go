@@ -0,0 +1,253 @@
+package api__test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func createTestTicket(t *testing.T, location primitive.ObjectID, product store.Item, queuedAt time.Time) store.Order {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+
+	order := store.Order{
+		Id:    primitive.NewObjectID(),
+		Owner: ownerID,
+		Items: []store.OrderItem{{
+			Product:   product.Id,
+			Quantity:  2,
+			Modifiers: []store.SelectedModifier{{Group: "milk", Option: "oat", PriceDelta: money.Zero(money.DefaultCurrency)}},
+			UnitPrice: product.Price,
+			Amount:    product.Price.Mul(2),
+		}},
+		Status:    string(types.ORDER_PENDING),
+		Location:  location,
+		QueuedAt:  queuedAt,
+		CreatedAt: queuedAt,
+		UpdatedAt: queuedAt,
+	}
+
+	collection := mongoClient.Database("coffeeshop").Collection("orders")
+	_, err = collection.InsertOne(context.Background(), order)
+	require.NoError(t, err)
+	return order
+}
+
+func TestKitchenQueue(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	location := createTestLocation(t, fmt.Sprintf("Westlands %s", suffix))
+
+	item := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Flat white %s", suffix),
+		Price:     money.New(300, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+	require.NoError(t, err)
+
+	older := createTestTicket(t, location.Id, item, time.Now().Add(-10*time.Minute))
+	newer := createTestTicket(t, location.Id, item, time.Now().Add(-5*time.Minute))
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	// placed ahead and not yet due in the kitchen
+	scheduled := createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	ticket := func(t *testing.T, recorder *httptest.ResponseRecorder) types.KitchenTicketResParams {
+		var res struct {
+			Status string
+			Data   types.KitchenTicketResParams
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res.Data
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "customers cannot see the queue | status 403",
+			method: http.MethodGet,
+			url:    "/api/v1/kitchen/queue",
+			token:  userTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "queue oldest first | status 200",
+			method: http.MethodGet,
+			url:    fmt.Sprintf("/api/v1/kitchen/queue?location=%s", location.Id.Hex()),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var res struct {
+					Status  string
+					Results int32
+					Data    []types.KitchenTicketResParams
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Equal(t, int32(2), res.Results)
+				require.Equal(t, older.Id.Hex(), res.Data[0].Order)
+				require.Equal(t, newer.Id.Hex(), res.Data[1].Order)
+				require.Equal(t, item.Name, res.Data[0].Items[0].Name)
+				require.Equal(t, uint32(2), res.Data[0].Items[0].Quantity)
+				require.Equal(t, []string{"milk: oat"}, res.Data[0].Items[0].Modifiers)
+			},
+		},
+		{
+			name:   "bump a pending ticket | status 200",
+			method: http.MethodPost,
+			url:    fmt.Sprintf("/api/v1/kitchen/orders/%s/bump", older.Id.Hex()),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, string(types.ORDER_ACCEPTED), ticket(t, recorder).Status)
+			},
+		},
+		{
+			name:   "recall an accepted ticket | status 409",
+			method: http.MethodPost,
+			url:    fmt.Sprintf("/api/v1/kitchen/orders/%s/recall", older.Id.Hex()),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "bump into preparation | status 200",
+			method: http.MethodPost,
+			url:    fmt.Sprintf("/api/v1/kitchen/orders/%s/bump", older.Id.Hex()),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, string(types.ORDER_PREPARING), ticket(t, recorder).Status)
+			},
+		},
+		{
+			name:   "recall a ticket being prepared | status 200",
+			method: http.MethodPost,
+			url:    fmt.Sprintf("/api/v1/kitchen/orders/%s/recall", older.Id.Hex()),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, string(types.ORDER_ACCEPTED), ticket(t, recorder).Status)
+			},
+		},
+		{
+			name:   "bump an order not in the kitchen | status 409",
+			method: http.MethodPost,
+			url:    fmt.Sprintf("/api/v1/kitchen/orders/%s/bump", scheduled.Id.Hex()),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url, nil)
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestKitchenFeed(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	location := createTestLocation(t, fmt.Sprintf("Karen %s", suffix))
+
+	item := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Mocha %s", suffix),
+		Price:     money.New(400, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+	require.NoError(t, err)
+	order := createTestTicket(t, location.Id, item, time.Now())
+
+	httpServer := httptest.NewServer(server.Router)
+	defer httpServer.Close()
+
+	url := fmt.Sprintf("ws%s/api/v1/kitchen/feed?location=%s", strings.TrimPrefix(httpServer.URL, "http"), location.Id.Hex())
+	header := http.Header{}
+	header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var message types.KitchenFeedMessage
+	require.NoError(t, conn.ReadJSON(&message))
+	require.Equal(t, "queue", message.Type)
+	require.Len(t, message.Tickets, 1)
+	require.Equal(t, order.Id.Hex(), message.Tickets[0].Order)
+
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/kitchen/orders/%s/bump", order.Id.Hex()), nil)
+	require.NoError(t, err)
+	request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+	server.Router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	message = types.KitchenFeedMessage{}
+	require.NoError(t, conn.ReadJSON(&message))
+	require.Equal(t, "ticket", message.Type)
+	require.Equal(t, order.Id.Hex(), message.Ticket.Order)
+	require.Equal(t, string(types.ORDER_ACCEPTED), message.Ticket.Status)
+}
+
+func TestKitchenFeedTicket(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	location := createTestLocation(t, fmt.Sprintf("Kilimani %s", suffix))
+
+	httpServer := httptest.NewServer(server.Router)
+	defer httpServer.Close()
+	feed := fmt.Sprintf("ws%s/api/v1/kitchen/feed?location=%s", strings.TrimPrefix(httpServer.URL, "http"), location.Id.Hex())
+
+	t.Run("open the feed with a ticket", func(t *testing.T) {
+		ticket := createTestStreamTicket(t, "/api/v1/kitchen/feed")
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s&ticket=%s", feed, ticket), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		var message types.KitchenFeedMessage
+		require.NoError(t, conn.ReadJSON(&message))
+		require.Equal(t, "queue", message.Type)
+		require.Empty(t, message.Tickets)
+	})
+
+	t.Run("ticket for another stream | status 403", func(t *testing.T) {
+		ticket := createTestStreamTicket(t, "/api/v1/kitchen/queue")
+		_, response, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s&ticket=%s", feed, ticket), nil)
+		require.Error(t, err)
+		require.Equal(t, http.StatusForbidden, response.StatusCode)
+	})
+}
@@ -0,0 +1,253 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createTestLocation stores a location that is open around the clock.
+func createTestLocation(t *testing.T, name string) store.Location {
+	location := store.Location{
+		Id:        primitive.NewObjectID(),
+		Name:      name,
+		Address:   store.Address{Street: "Kenyatta Avenue", City: "Nairobi", Country: "Kenya"},
+		Timezone:  "UTC",
+		Closures:  []store.Closure{},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	for day := uint8(0); day < 7; day++ {
+		location.OpeningHours = append(location.OpeningHours, store.OpeningHours{Day: day, Opens: "00:00", Closes: "24:00"})
+	}
+
+	_, err := mongoClient.Database("coffeeshop").Collection("locations").InsertOne(context.Background(), location)
+	require.NoError(t, err)
+	return location
+}
+
+func TestLocations(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	open := createTestLocation(t, fmt.Sprintf("Westlands %s", suffix))
+
+	latte := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Latte %s", suffix),
+		Price:     money.New(450, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	scone := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Scone %s", suffix),
+		Price:     money.New(250, money.DefaultCurrency),
+		Category:  "snacks",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertMany(context.Background(), []interface{}{latte, scone})
+	require.NoError(t, err)
+
+	hours := []map[string]interface{}{}
+	for day := 0; day < 7; day++ {
+		hours = append(hours, map[string]interface{}{"day": day, "opens": "00:00", "closes": "24:00"})
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+
+	var closedID string
+	testCases := []struct {
+		name   string
+		method string
+		url    func() string
+		token  string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "unknown timezone | status 400",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/locations" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"name": "Nowhere", "timezone": "Mars/Olympus", "opening_hours": hours,
+				"address": map[string]interface{}{"street": "Moi Avenue", "city": "Nairobi", "country": "Kenya"},
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "closed for the holiday | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/locations" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"name": fmt.Sprintf("Karen %s", suffix), "timezone": "UTC", "opening_hours": hours,
+				"address":  map[string]interface{}{"street": "Karen Road", "city": "Nairobi", "country": "Kenya"},
+				"closures": []map[string]interface{}{{"date": today, "reason": "public holiday"}},
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res struct {
+					Status string
+					Data   store.Location
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Len(t, res.Data.OpeningHours, 7)
+				closedID = res.Data.Id.Hex()
+			},
+		},
+		{
+			name:   "closed location is not open | status 200",
+			method: http.MethodGet,
+			url:    func() string { return fmt.Sprintf("/api/v1/locations/%s", closedID) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var res struct {
+					Status string
+					Open   bool
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.False(t, res.Open)
+			},
+		},
+		{
+			name:   "order at a closed location | status 422",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/products/orders" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items":    []map[string]interface{}{{"product": latte.Id.Hex(), "quantity": 1}},
+				"location": closedID,
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+			},
+		},
+		{
+			name:   "local latte price | status 200",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/locations/%s/products/%s", open.Id.Hex(), latte.Id.Hex()) },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"available": true, "price": "5.00"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:   "scones sold out locally | status 200",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/locations/%s/products/%s", open.Id.Hex(), scone.Id.Hex()) },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"available": false},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:   "menu of the location | status 200",
+			method: http.MethodGet,
+			url:    func() string { return fmt.Sprintf("/api/v1/locations/%s/menu", open.Id.Hex()) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var res struct {
+					Status string
+					Data   []store.Item
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				var found bool
+				for _, item := range res.Data {
+					require.NotEqual(t, scone.Id, item.Id)
+					if item.Id == latte.Id {
+						found = true
+						require.Equal(t, int64(500), item.Price.Amount)
+					}
+				}
+				require.True(t, found)
+			},
+		},
+		{
+			name:   "order an unavailable product | status 422",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/products/orders" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items":    []map[string]interface{}{{"product": scone.Id.Hex(), "quantity": 1}},
+				"location": open.Id.Hex(),
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+			},
+		},
+		{
+			name:   "order at the local price | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/products/orders" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items":    []map[string]interface{}{{"product": latte.Id.Hex(), "quantity": 2}},
+				"location": open.Id.Hex(),
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res store.Order
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Equal(t, open.Id, res.Location)
+				require.Equal(t, int64(500), res.Items[0].UnitPrice.Amount)
+			},
+		},
+		{
+			name:   "unknown location | status 404",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/products/orders" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items":    []map[string]interface{}{{"product": latte.Id.Hex(), "quantity": 1}},
+				"location": primitive.NewObjectID().Hex(),
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+		{
+			name:   "order without a location | status 400",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/products/orders" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items": []map[string]interface{}{{"product": latte.Id.Hex(), "quantity": 1}},
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url(), bytes.NewReader(data))
+			require.NoError(t, err)
+			if tc.token != "" {
+				request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+			}
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
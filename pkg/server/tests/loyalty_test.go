@@ -0,0 +1,109 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLoyalty(t *testing.T) {
+	envs, err := internal.LoadEnvs("../../..")
+	require.NoError(t, err)
+	rules, err := internal.ParseLoyaltyRules(envs)
+	require.NoError(t, err)
+
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+
+	serve := func(t *testing.T, method, url string, body interface{}) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest(method, url, bytes.NewReader(data))
+		require.NoError(t, err)
+		request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+		server.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	balance := func(t *testing.T) int64 {
+		recorder := serve(t, http.MethodGet, "/api/v1/loyalty", nil)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var res struct {
+			Status string
+			Data   struct {
+				Balance int64
+			}
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res.Data.Balance
+	}
+
+	t.Run("earn points on a completed order | status 200", func(t *testing.T) {
+		before := balance(t)
+
+		order := store.Order{
+			Id:          primitive.NewObjectID(),
+			TotalAmount: money.New(1250, money.DefaultCurrency),
+			Owner:       ownerID,
+			Status:      string(types.ORDER_PENDING),
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		_, err := mongoClient.Database("coffeeshop").Collection("orders").InsertOne(context.Background(), order)
+		require.NoError(t, err)
+
+		for _, status := range []types.OrderStatus{types.ORDER_ACCEPTED, types.ORDER_PREPARING, types.ORDER_READY, types.ORDER_COMPLETED} {
+			recorder := serve(t, http.MethodPut, fmt.Sprintf("/api/v1/orders/%s/status", order.Id.Hex()), map[string]interface{}{"status": status})
+			require.Equal(t, http.StatusOK, recorder.Code)
+		}
+
+		earned := rules.PointsEarned(order.TotalAmount)
+		require.Equal(t, before+earned, balance(t))
+
+		recorder := serve(t, http.MethodGet, "/api/v1/loyalty/transactions?limit=1", nil)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var res struct {
+			Status string
+			Data   []store.LoyaltyTransaction
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		require.Len(t, res.Data, 1)
+		require.Equal(t, string(types.LOYALTY_EARN), res.Data[0].Kind)
+		require.Equal(t, earned, res.Data[0].Points)
+		require.Equal(t, order.Id, res.Data[0].Order)
+	})
+
+	t.Run("redeem more items than the balance covers | status 422", func(t *testing.T) {
+		item := store.Item{
+			Id:        primitive.NewObjectID(),
+			Name:      fmt.Sprintf("Cortado %s", primitive.NewObjectID().Hex()),
+			Price:     money.New(350, money.DefaultCurrency),
+			Category:  "beverages",
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		_, err := mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+		require.NoError(t, err)
+
+		units := uint32(balance(t)/rules.PointsPerFreeItem + 1)
+		recorder := serve(t, http.MethodPost, "/api/v1/products/orders", map[string]interface{}{
+			"items":    []map[string]interface{}{{"product": item.Id.Hex(), "quantity": units, "redeem": units}},
+			"location": createTestLocation(t, fmt.Sprintf("Gigiri %s", item.Id.Hex()[18:])).Id.Hex(),
+		})
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+}
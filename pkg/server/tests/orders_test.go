@@ -0,0 +1,439 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func createTestOrder(t *testing.T, owner primitive.ObjectID, status types.OrderStatus) store.Order {
+	order := store.Order{
+		Id:        primitive.NewObjectID(),
+		Owner:     owner,
+		Status:    string(status),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	collection := mongoClient.Database("coffeeshop").Collection("orders")
+	_, err := collection.InsertOne(context.Background(), order)
+	require.NoError(t, err)
+	return order
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	testCases := []struct {
+		name  string
+		id    string
+		token string
+		body  map[string]interface{}
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:  "accept a pending order | status 200",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{"status": "accepted", "note": "on it"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				var result struct {
+					Status string
+					Data   store.Order
+				}
+				err := json.Unmarshal(recorder.Body.Bytes(), &result)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, string(types.ORDER_ACCEPTED), result.Data.Status)
+				require.Len(t, result.Data.StatusHistory, 1)
+			},
+		},
+		{
+			name:  "skip straight to completed | status 409",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{"status": "completed"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:  "unknown status | status 400",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{"status": "brewing"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "invalid order id | status 400",
+			id:    "1234",
+			token: adminTestToken,
+			body:  map[string]interface{}{"status": "accepted"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "order not found | status 404",
+			id:    "65bcc06cbc92379c5b6fe79b",
+			token: adminTestToken,
+			body:  map[string]interface{}{"status": "accepted"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+		{
+			name:  "missing token | status 403",
+			id:    order.Id.Hex(),
+			token: "",
+			body:  map[string]interface{}{"status": "preparing"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			url := fmt.Sprintf("/api/v1/orders/%s/status", tc.id)
+			body, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestGetAllOrders(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	createTestOrder(t, ownerID, types.ORDER_PENDING)
+	createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	testCases := []struct {
+		name  string
+		query string
+		token string
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:  "list orders with pagination | status 200",
+			query: fmt.Sprintf("?owner=%s&status=pending&limit=1", adminID),
+			token: adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				var result struct {
+					Status     string
+					Results    int32
+					NextCursor string `json:"next_cursor"`
+				}
+				err := json.Unmarshal(recorder.Body.Bytes(), &result)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, int32(1), result.Results)
+				require.NotEmpty(t, result.NextCursor)
+			},
+		},
+		{
+			name:  "invalid limit | status 400",
+			query: "?limit=zero",
+			token: adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "invalid date range | status 400",
+			query: "?from=yesterday",
+			token: adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			url := fmt.Sprintf("/api/v1/orders%s", tc.query)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, url, nil)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestGetOrderById(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	testCases := []struct {
+		name  string
+		id    string
+		token string
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:  "get order by id | status 200",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:  "get order by invalid id | status 400",
+			id:    "1234",
+			token: adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "get missing order | status 404",
+			id:    "65bcc06cbc92379c5b6fe79b",
+			token: adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			url := fmt.Sprintf("/api/v1/orders/%s", tc.id)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, url, nil)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+	otherOrder := createTestOrder(t, primitive.NewObjectID(), types.ORDER_PENDING)
+
+	testCases := []struct {
+		name  string
+		id    string
+		token string
+		body  map[string]interface{}
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:  "cancel a pending order | status 200",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{"reason": "ordered by mistake"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:  "cancel an already cancelled order | status 409",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{"reason": "ordered by mistake"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:  "cancel without a reason | status 400",
+			id:    order.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "cancel someone else's order | status 403",
+			id:    otherOrder.Id.Hex(),
+			token: adminTestToken,
+			body:  map[string]interface{}{"reason": "ordered by mistake"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			url := fmt.Sprintf("/api/v1/orders/%s/cancel", tc.id)
+			body, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestOrderIdempotencyKey(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+	key := primitive.NewObjectID().Hex()
+
+	testCases := []struct {
+		name  string
+		body  map[string]interface{}
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name: "first request | status 200",
+			body: map[string]interface{}{"reason": "ordered by mistake"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Empty(t, recorder.Header().Get("Idempotent-Replayed"))
+			},
+		},
+		{
+			name: "retried request is replayed | status 200",
+			body: map[string]interface{}{"reason": "ordered by mistake"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
+			},
+		},
+		{
+			name: "key reused with a different body | status 422",
+			body: map[string]interface{}{"reason": "changed my mind"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			url := fmt.Sprintf("/api/v1/orders/%s/cancel", order.Id.Hex())
+			body, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+			request.Header.Set("Idempotency-Key", key)
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestCreateOrderIdempotencyKey(t *testing.T) {
+	item := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Cortado %s", primitive.NewObjectID().Hex()),
+		Price:     money.New(350, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+	require.NoError(t, err)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"items":    []map[string]interface{}{{"product": item.Id.Hex(), "quantity": 1}},
+		"location": createTestLocation(t, fmt.Sprintf("Runda %s", item.Id.Hex()[18:])).Id.Hex(),
+	})
+	require.NoError(t, err)
+
+	keys := mongoClient.Database("coffeeshop").Collection("idempotency_keys")
+	url := "/api/v1/products/orders"
+	serve := func(key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+		request.Header.Set("Idempotency-Key", key)
+		server.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	placed := func(t *testing.T, recorder *httptest.ResponseRecorder) store.Order {
+		var order store.Order
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &order))
+		return order
+	}
+
+	t.Run("retried order is placed once | status 201", func(t *testing.T) {
+		key := primitive.NewObjectID().Hex()
+
+		recorder := serve(key)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		require.Empty(t, recorder.Header().Get("Idempotent-Replayed"))
+		first := placed(t, recorder)
+
+		recorder = serve(key)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		require.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
+		require.Equal(t, first.Id, placed(t, recorder).Id)
+
+		count, err := mongoClient.Database("coffeeshop").Collection("orders").CountDocuments(context.Background(),
+			bson.D{{Key: "items.product", Value: item.Id}})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+	})
+
+	t.Run("request in flight | status 409", func(t *testing.T) {
+		key := primitive.NewObjectID().Hex()
+		id := fmt.Sprintf("%s:%s:%s:%s", adminID, http.MethodPost, url, key)
+		_, err := keys.InsertOne(context.Background(), store.IdempotencyRecord{
+			Id:          id,
+			RequestHash: fmt.Sprintf("%x", sha256.Sum256(body)),
+			LockedUntil: time.Now().Add(time.Minute),
+			CreatedAt:   time.Now(),
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusConflict, serve(key).Code)
+
+		// the request holding the key died before its lock ran out
+		_, err = keys.UpdateOne(context.Background(), bson.D{{Key: "_id", Value: id}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "locked_until", Value: time.Now().Add(-time.Second)}}}})
+		require.NoError(t, err)
+
+		recorder := serve(key)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		require.Empty(t, recorder.Header().Get("Idempotent-Replayed"))
+	})
+}
@@ -0,0 +1,248 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/payments"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPayOrder(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+	delayed := createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	// payments that never reached the provider, e.g. after a crash
+	unsent := func(startedAt time.Time) store.Order {
+		order := createTestOrder(t, ownerID, types.ORDER_PENDING)
+		_, err := mongoClient.Database("coffeeshop").Collection("orders").UpdateByID(context.Background(), order.Id,
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "payment_status", Value: types.PENDING.String()},
+				{Key: "updated_at", Value: startedAt},
+			}}})
+		require.NoError(t, err)
+		_, err = mongoClient.Database("coffeeshop").Collection("payments").InsertOne(context.Background(), store.Payment{
+			Id:        primitive.NewObjectID(),
+			Order:     order.Id,
+			Customer:  ownerID,
+			Provider:  payments.FakeProviderName,
+			Status:    types.PENDING.String(),
+			CreatedAt: startedAt,
+			UpdatedAt: startedAt,
+		})
+		require.NoError(t, err)
+		return order
+	}
+	abandoned := unsent(time.Now().Add(-time.Hour))
+	starting := unsent(time.Now())
+
+	type paymentRes struct {
+		Status string
+		Data   struct {
+			Payment store.Payment
+			Order   store.Order
+		}
+	}
+	decode := func(t *testing.T, recorder *httptest.ResponseRecorder) paymentRes {
+		var res paymentRes
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		id     string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "declined card | status 402",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			body:   map[string]interface{}{"payment_method": payments.FakeMethodDeclined},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusPaymentRequired, recorder.Code)
+				res := decode(t, recorder)
+				require.Equal(t, types.REJECTED.String(), res.Data.Payment.Status)
+				require.Equal(t, types.REJECTED.String(), res.Data.Order.PaymentStatus)
+				require.Equal(t, string(types.ORDER_PENDING), res.Data.Order.Status)
+			},
+		},
+		{
+			name:   "retry with a working card | status 201",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			body:   map[string]interface{}{"payment_method": "fake_card_visa"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				res := decode(t, recorder)
+				require.Equal(t, types.PAID.String(), res.Data.Payment.Status)
+				require.Equal(t, types.PAID.String(), res.Data.Order.PaymentStatus)
+				require.Equal(t, string(types.ORDER_ACCEPTED), res.Data.Order.Status)
+				require.Equal(t, res.Data.Payment.Id, res.Data.Order.Payment)
+			},
+		},
+		{
+			name:   "pay twice | status 409",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			body:   map[string]interface{}{"payment_method": "fake_card_visa"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "payment settled later | status 202",
+			method: http.MethodPost,
+			id:     delayed.Id.Hex(),
+			body:   map[string]interface{}{"payment_method": payments.FakeMethodDelayed},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusAccepted, recorder.Code)
+				res := decode(t, recorder)
+				require.Equal(t, types.PENDING.String(), res.Data.Payment.Status)
+			},
+		},
+		{
+			name:   "payment abandoned before the provider | status 201",
+			method: http.MethodPost,
+			id:     abandoned.Id.Hex(),
+			body:   map[string]interface{}{"payment_method": "fake_card_visa"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				res := decode(t, recorder)
+				require.Equal(t, types.PAID.String(), res.Data.Order.PaymentStatus)
+				require.NotEmpty(t, res.Data.Payment.IntentId)
+			},
+		},
+		{
+			name:   "payment on its way to the provider | status 409",
+			method: http.MethodPost,
+			id:     starting.Id.Hex(),
+			body:   map[string]interface{}{"payment_method": "fake_card_visa"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "list order payments | status 200",
+			method: http.MethodGet,
+			id:     order.Id.Hex(),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var res struct {
+					Status string
+					Data   []store.Payment
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Len(t, res.Data, 2)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, fmt.Sprintf("/api/v1/orders/%s/payments", tc.id), bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	envs, err := internal.LoadEnvs("../../..")
+	require.NoError(t, err)
+	signer := payments.NewFakeProvider(envs.PAYMENT_WEBHOOK_SECRET)
+
+	event := payments.Event{
+		Id:        fmt.Sprintf("evt_%s", primitive.NewObjectID().Hex()),
+		Type:      payments.EVENT_PAYMENT_SUCCEEDED,
+		Intent:    "fake_pi_webhook",
+		CreatedAt: time.Now(),
+	}
+	payload, signature, err := signer.SignWebhook(event, time.Now())
+	require.NoError(t, err)
+	_, staleSignature, err := signer.SignWebhook(event, time.Now().Add(-payments.WebhookTolerance-time.Minute))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		provider  string
+		signature string
+		check     func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:      "unknown provider | status 404",
+			provider:  "acme",
+			signature: signature,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+		{
+			name:      "invalid signature | status 401",
+			provider:  payments.FakeProviderName,
+			signature: "t=1,v1=00",
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnauthorized, recorder.Code)
+			},
+		},
+		{
+			name:      "stale timestamp | status 401",
+			provider:  payments.FakeProviderName,
+			signature: staleSignature,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnauthorized, recorder.Code)
+			},
+		},
+		{
+			name:      "signed event | status 200",
+			provider:  payments.FakeProviderName,
+			signature: signature,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:      "replayed event | status 200",
+			provider:  payments.FakeProviderName,
+			signature: signature,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Contains(t, recorder.Body.String(), "already received")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/webhooks/payments/%s", tc.provider), bytes.NewReader(payload))
+			require.NoError(t, err)
+			request.Header.Set(payments.FakeSignatureHeader, tc.signature)
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPickupOrders(t *testing.T) {
+	envs, err := internal.LoadEnvs("../../..")
+	require.NoError(t, err)
+	rules, err := internal.ParsePickupRules(envs)
+	require.NoError(t, err)
+
+	suffix := primitive.NewObjectID().Hex()[18:]
+	location := createTestLocation(t, fmt.Sprintf("Kilimani %s", suffix))
+
+	item := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Cortado %s", suffix),
+		Price:     money.New(350, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err = mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+	require.NoError(t, err)
+
+	pickupAt := time.Now().Add(2 * time.Hour).UTC()
+	fullAt := time.Now().Add(4 * time.Hour).UTC()
+	fullSlot, err := internal.PickupSlotAt(location, fullAt, rules, time.Now())
+	require.NoError(t, err)
+	_, err = mongoClient.Database("coffeeshop").Collection("pickup_slots").InsertOne(context.Background(), store.PickupSlot{
+		Id:       internal.PickupSlotId(location.Id, fullSlot),
+		Location: location.Id,
+		StartsAt: fullSlot,
+		Booked:   rules.SlotCapacity,
+	})
+	require.NoError(t, err)
+
+	order := func(pickup time.Time, location string) map[string]interface{} {
+		return map[string]interface{}{
+			"items":     []map[string]interface{}{{"product": item.Id.Hex(), "quantity": 1}},
+			"location":  location,
+			"pickup_at": pickup,
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		url    string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "pickup without a location | status 400",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			body:   order(pickupAt, ""),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "no time to prepare | status 422",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			body:   order(time.Now().Add(time.Minute), location.Id.Hex()),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+			},
+		},
+		{
+			name:   "fully booked slot | status 409",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			body:   order(fullAt, location.Id.Hex()),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "order ahead | status 201",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			body:   order(pickupAt, location.Id.Hex()),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res store.Order
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Equal(t, string(types.ORDER_PENDING), res.Status)
+				require.NotEmpty(t, res.PickupSlot)
+				require.WithinDuration(t, pickupAt, res.PickupAt, time.Second)
+				// it reaches the kitchen shortly before pickup
+				require.True(t, res.QueuedAt.IsZero())
+				require.WithinDuration(t, pickupAt.Add(-rules.PrepTime), res.DueAt, time.Second)
+			},
+		},
+		{
+			name:   "slots of the day | status 200",
+			method: http.MethodGet,
+			url:    fmt.Sprintf("/api/v1/locations/%s/pickup-slots?date=%s", location.Id.Hex(), fullAt.Format("2006-01-02")),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var res struct {
+					Status string
+					Data   []types.PickupSlotResParams
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.NotEmpty(t, res.Data)
+				for _, slot := range res.Data {
+					if slot.StartsAt.Equal(fullSlot) {
+						require.Zero(t, slot.Available)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url, bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestDueOrders(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	orders := mongoClient.Database("coffeeshop").Collection("orders")
+
+	due := func(status types.OrderStatus, dueAt time.Time) store.Order {
+		order := createTestOrder(t, ownerID, status)
+		_, err := orders.UpdateByID(context.Background(), order.Id, bson.D{{Key: "$set", Value: bson.D{{Key: "due_at", Value: dueAt}}}})
+		require.NoError(t, err)
+		return order
+	}
+	missed := due(types.ORDER_ACCEPTED, time.Now().Add(-time.Minute))
+	later := due(types.ORDER_PENDING, time.Now().Add(time.Hour))
+	cancelled := due(types.ORDER_CANCELLED, time.Now().Add(-time.Minute))
+
+	ids, err := internal.DueOrders(context.Background(), orders, time.Now())
+	require.NoError(t, err)
+	require.Contains(t, ids, missed.Id)
+	require.NotContains(t, ids, later.Id)
+	require.NotContains(t, ids, cancelled.Id)
+
+	queued, err := internal.QueueOrder(context.Background(), orders, missed.Id, time.Now())
+	require.NoError(t, err)
+	require.True(t, queued)
+
+	ids, err = internal.DueOrders(context.Background(), orders, time.Now())
+	require.NoError(t, err)
+	require.NotContains(t, ids, missed.Id)
+}
@@ -11,10 +11,11 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
-	"strconv"
+	"net/url"
 	"strings"
 	"testing"
 
+	"github.com/silaselisha/coffee-api/internal"
 	"github.com/silaselisha/coffee-api/types"
 	"github.com/stretchr/testify/require"
 )
@@ -35,12 +36,16 @@ func TestCreateProduct(t *testing.T) {
 				writer := multipart.NewWriter(body)
 
 				writer.WriteField("name", product.Name)
-				writer.WriteField("price", strconv.FormatFloat(product.Price, 'E', -1, 64))
+				writer.WriteField("price", product.Price.Decimal())
 				writer.WriteField("summary", product.Summary)
 				writer.WriteField("category", product.Category)
 				writer.WriteField("description", product.Description)
 				writer.WriteField("ingridients", strings.Join(product.Ingridients, " "))
 
+				modifiers, err := json.Marshal(internal.ModifierGroupsToParams(product.Modifiers))
+				require.NoError(t, err)
+				writer.WriteField("modifier_groups", string(modifiers))
+
 				palette := []color.Color{color.Black, color.White}
 
 				w, err := writer.CreateFormFile("thumbnail", "thumbnail.jpeg")
@@ -68,6 +73,7 @@ func TestCreateProduct(t *testing.T) {
 				productID = result.Data.Id
 
 				require.NotEmpty(t, productID)
+				require.Len(t, result.Data.Modifiers, len(product.Modifiers))
 				require.Equal(t, http.StatusCreated, recorder.Code)
 			},
 		},
@@ -79,7 +85,7 @@ func TestCreateProduct(t *testing.T) {
 				writer := multipart.NewWriter(body)
 
 				writer.WriteField("name", product.Name)
-				writer.WriteField("price", strconv.FormatFloat(product.Price, 'E', -1, 64))
+				writer.WriteField("price", product.Price.Decimal())
 				writer.WriteField("summary", product.Summary)
 				writer.WriteField("category", product.Category)
 				writer.WriteField("description", product.Description)
@@ -110,7 +116,7 @@ func TestCreateProduct(t *testing.T) {
 				writer := multipart.NewWriter(body)
 
 				writer.WriteField("name", product.Name)
-				writer.WriteField("price", strconv.FormatFloat(product.Price, 'E', -1, 64))
+				writer.WriteField("price", product.Price.Decimal())
 				writer.WriteField("summary", product.Summary)
 				writer.WriteField("category", product.Category)
 				writer.WriteField("description", product.Description)
@@ -201,8 +207,22 @@ func TestUpdateProduct(t *testing.T) {
 }
 
 func TestGetAllProduct(t *testing.T) {
+	type listRes struct {
+		Status  string                       `json:"status"`
+		Results int32                        `json:"results"`
+		Next    string                       `json:"next"`
+		Data    types.ItemResponseListParams `json:"data"`
+	}
+
+	decode := func(t *testing.T, recorder *httptest.ResponseRecorder) listRes {
+		var res listRes
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res
+	}
+
 	testCases := []struct {
 		name  string
+		query string
 		check func(t *testing.T, recorder *httptest.ResponseRecorder)
 	}{
 		{
@@ -211,12 +231,80 @@ func TestGetAllProduct(t *testing.T) {
 				require.Equal(t, http.StatusOK, recorder.Code)
 			},
 		},
+		{
+			name:  "paginate with limit",
+			query: "?limit=1",
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				res := decode(t, recorder)
+				require.LessOrEqual(t, len(res.Data), 1)
+				if res.Next == "" {
+					return
+				}
+
+				next := httptest.NewRecorder()
+				request, err := http.NewRequest(http.MethodGet, res.Next, nil)
+				require.NoError(t, err)
+				server.Router.ServeHTTP(next, request)
+				require.Equal(t, http.StatusOK, next.Code)
+
+				page := decode(t, next)
+				for _, item := range page.Data {
+					require.NotEqual(t, res.Data[0].Id, item.Id)
+				}
+			},
+		},
+		{
+			name:  "sort by price ascending",
+			query: "?sort=price",
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				res := decode(t, recorder)
+				for i := 1; i < len(res.Data); i++ {
+					require.LessOrEqual(t, res.Data[i-1].Price.Amount, res.Data[i].Price.Amount)
+				}
+			},
+		},
+		{
+			name:  "filter by category and price range",
+			query: "?category=beverages&min_price=1.00&max_price=10.00",
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				res := decode(t, recorder)
+				for _, item := range res.Data {
+					require.Equal(t, "beverages", item.Category)
+					require.GreaterOrEqual(t, item.Price.Amount, int64(100))
+					require.LessOrEqual(t, item.Price.Amount, int64(1000))
+				}
+			},
+		},
+		{
+			name:  "full text search",
+			query: "?q=" + url.QueryEscape(product.Name),
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:  "invalid sort",
+			query: "?sort=name",
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "invalid cursor",
+			query: "?cursor=not-a-cursor",
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 
-			url := "/api/v1/products"
+			url := "/api/v1/products" + tc.query
 			recorder := httptest.NewRecorder()
 			request, err := http.NewRequest(http.MethodGet, url, nil)
 			require.NoError(t, err)
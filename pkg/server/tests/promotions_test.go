@@ -0,0 +1,200 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPromotions(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	bogo := fmt.Sprintf("BOGO%s", suffix)
+	bigSpender := fmt.Sprintf("BIG%s", suffix)
+
+	item := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Flat White %s", suffix),
+		Price:     money.New(400, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertOne(context.Background(), item)
+	require.NoError(t, err)
+
+	location := createTestLocation(t, fmt.Sprintf("Parklands %s", suffix)).Id.Hex()
+
+	order := map[string]interface{}{
+		"items":      []map[string]interface{}{{"product": item.Id.Hex(), "quantity": 3}},
+		"promo_code": bogo,
+		"location":   location,
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "create as a customer | status 403",
+			method: http.MethodPost,
+			url:    "/api/v1/promotions",
+			token:  userTestToken,
+			body:   map[string]interface{}{"code": bogo, "kind": "bxgy", "buy_quantity": 1, "get_quantity": 1, "starts_at": time.Now().Add(-time.Hour)},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "unknown kind | status 400",
+			method: http.MethodPost,
+			url:    "/api/v1/promotions",
+			token:  adminTestToken,
+			body:   map[string]interface{}{"code": bogo, "kind": "freebie", "starts_at": time.Now().Add(-time.Hour)},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "buy one get one | status 201",
+			method: http.MethodPost,
+			url:    "/api/v1/promotions",
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"code": bogo, "kind": "bxgy", "buy_quantity": 1, "get_quantity": 1,
+				"categories": []string{"beverages"}, "per_user_limit": 1, "starts_at": time.Now().Add(-time.Hour),
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res struct {
+					Status string
+					Data   store.Promotion
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Equal(t, bogo, res.Data.Code)
+			},
+		},
+		{
+			name:   "duplicate code | status 409",
+			method: http.MethodPost,
+			url:    "/api/v1/promotions",
+			token:  adminTestToken,
+			body:   map[string]interface{}{"code": bogo, "kind": "percentage", "percent": 10, "starts_at": time.Now()},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "fixed amount with a minimum | status 201",
+			method: http.MethodPost,
+			url:    "/api/v1/promotions",
+			token:  adminTestToken,
+			body:   map[string]interface{}{"code": bigSpender, "kind": "fixed", "amount": "5.00", "min_order": "100.00", "starts_at": time.Now().Add(-time.Hour)},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+			},
+		},
+		{
+			name:   "order below the minimum | status 422",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items":      []map[string]interface{}{{"product": item.Id.Hex(), "quantity": 1}},
+				"promo_code": bigSpender,
+				"location":   location,
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+			},
+		},
+		{
+			name:   "zero quantity line | status 400",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items": []map[string]interface{}{
+					{"product": item.Id.Hex(), "quantity": 0},
+					{"product": item.Id.Hex(), "quantity": 3},
+				},
+				"promo_code": bogo,
+				"location":   location,
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "order with buy one get one | status 201",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			token:  adminTestToken,
+			body:   order,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res store.Order
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.NotNil(t, res.Promotion)
+				require.Equal(t, bogo, res.Promotion.Code)
+				require.Equal(t, int64(400), res.Promotion.Discount.Amount)
+				require.Len(t, res.Promotion.Lines, 1)
+				require.Equal(t, uint32(1), res.Promotion.Lines[0].Quantity)
+				require.Equal(t, int64(800), res.TotalAmount.Amount)
+				require.Equal(t, int64(400), res.Items[0].Discount.Amount)
+			},
+		},
+		{
+			name:   "use the code twice | status 409",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			token:  adminTestToken,
+			body:   order,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "unknown code | status 404",
+			method: http.MethodPost,
+			url:    "/api/v1/products/orders",
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items":      []map[string]interface{}{{"product": item.Id.Hex(), "quantity": 1}},
+				"promo_code": "NOSUCHCODE",
+				"location":   location,
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url, bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createPaidTestOrder places an order for three units of product at 1.00
+// each, 0.10 off, and pays it through the fake provider.
+func createPaidTestOrder(t *testing.T, owner primitive.ObjectID) store.Order {
+	order := store.Order{
+		Id: primitive.NewObjectID(),
+		Items: []store.OrderItem{{
+			Product:   product.Id,
+			Quantity:  3,
+			UnitPrice: money.New(100, "USD"),
+			Amount:    money.New(300, "USD"),
+			Discount:  money.New(10, "USD"),
+		}},
+		TotalAmount:   money.New(290, "USD"),
+		TotalDiscount: money.New(10, "USD"),
+		Owner:         owner,
+		Status:        string(types.ORDER_PENDING),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("orders").InsertOne(context.Background(), order)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(map[string]interface{}{"payment_method": "fake_card_visa"})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/orders/%s/payments", order.Id.Hex()), bytes.NewReader(data))
+	require.NoError(t, err)
+	request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+	server.Router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+
+	var res struct {
+		Status string
+		Data   struct {
+			Order store.Order
+		}
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	return res.Data.Order
+}
+
+func TestRefundOrder(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+	order := createPaidTestOrder(t, ownerID)
+	unpaid := createTestOrder(t, ownerID, types.ORDER_PENDING)
+
+	type refundRes struct {
+		Status string
+		Data   struct {
+			Refund store.Refund
+			Order  store.Order
+		}
+	}
+	decode := func(t *testing.T, recorder *httptest.ResponseRecorder) refundRes {
+		var res refundRes
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		id     string
+		token  string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "refund as a customer | status 403",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			token:  userTestToken,
+			body:   map[string]interface{}{"reason": "cold coffee"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "refund an unpaid order | status 409",
+			method: http.MethodPost,
+			id:     unpaid.Id.Hex(),
+			token:  adminTestToken,
+			body:   map[string]interface{}{"reason": "cold coffee"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "refund more units than ordered | status 400",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			token:  adminTestToken,
+			body:   map[string]interface{}{"reason": "cold coffee", "items": []map[string]interface{}{{"line": 0, "quantity": 4}}},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "refund one unit | status 201",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			token:  adminTestToken,
+			body:   map[string]interface{}{"reason": "cold coffee", "items": []map[string]interface{}{{"line": 0, "quantity": 1}}},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				res := decode(t, recorder)
+				require.Equal(t, string(types.REFUND_SUCCEEDED), res.Data.Refund.Status)
+				require.Equal(t, int64(97), res.Data.Refund.Amount.Amount)
+				require.Equal(t, uint32(1), res.Data.Order.Items[0].RefundedQuantity)
+				require.Equal(t, int64(97), res.Data.Order.RefundedTotal.Amount)
+				require.Equal(t, types.PAID.String(), res.Data.Order.PaymentStatus)
+			},
+		},
+		{
+			name:   "refund the rest | status 201",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			token:  adminTestToken,
+			body:   map[string]interface{}{"reason": "order mixed up"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				res := decode(t, recorder)
+				require.Equal(t, int64(193), res.Data.Refund.Amount.Amount)
+				require.Equal(t, res.Data.Order.PaidTotal, res.Data.Order.RefundedTotal)
+				require.Equal(t, types.REFUNDED.String(), res.Data.Order.PaymentStatus)
+			},
+		},
+		{
+			name:   "nothing left to refund | status 409",
+			method: http.MethodPost,
+			id:     order.Id.Hex(),
+			token:  adminTestToken,
+			body:   map[string]interface{}{"reason": "again"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "list order refunds | status 200",
+			method: http.MethodGet,
+			id:     order.Id.Hex(),
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var res struct {
+					Status string
+					Data   []store.Refund
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Len(t, res.Data, 2)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, fmt.Sprintf("/api/v1/orders/%s/refunds", tc.id), bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package api__test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReservations(t *testing.T) {
+	var table store.CoffeeDateTable
+	var reservation store.Reservation
+	tableName := fmt.Sprintf("T-%s", primitive.NewObjectID().Hex())
+	startsAt := time.Now().Add(72 * time.Hour).Truncate(time.Minute).UTC()
+
+	decode := func(t *testing.T, recorder *httptest.ResponseRecorder, data interface{}) {
+		result := struct {
+			Status string
+			Data   interface{}
+		}{Data: data}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		url    func() string
+		body   func() map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "create a table | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/tables" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"name": tableName, "capacity": 2, "location": "window"}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				decode(t, recorder, &table)
+				require.Equal(t, uint32(2), table.Capacity)
+			},
+		},
+		{
+			name:   "duplicate table name | status 409",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/tables" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"name": tableName, "capacity": 4, "location": "patio"}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "reservation in the past | status 400",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/reservations" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"party_size": 2, "starts_at": time.Now().Add(-time.Hour), "table": table.Id.Hex()}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "party larger than the table | status 409",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/reservations" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"party_size": 3, "starts_at": startsAt, "table": table.Id.Hex()}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "book the table | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/reservations" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"party_size": 2, "starts_at": startsAt, "duration": 60, "table": table.Id.Hex()}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				decode(t, recorder, &reservation)
+				require.Equal(t, table.Id, reservation.Table)
+				require.Equal(t, startsAt.Add(time.Hour), reservation.EndsAt)
+			},
+		},
+		{
+			name:   "overlapping booking | status 409",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/reservations" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"party_size": 1, "starts_at": startsAt.Add(30 * time.Minute), "table": table.Id.Hex()}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "back to back booking | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/reservations" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"party_size": 2, "starts_at": startsAt.Add(time.Hour), "duration": 60, "table": table.Id.Hex()}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+			},
+		},
+		{
+			name:   "delete a table with upcoming bookings | status 409",
+			method: http.MethodDelete,
+			url:    func() string { return fmt.Sprintf("/api/v1/tables/%s", table.Id.Hex()) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "cancel a reservation | status 200",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/reservations/%s/cancel", reservation.Id.Hex()) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var cancelled store.Reservation
+				decode(t, recorder, &cancelled)
+				require.Equal(t, string(types.RESERVATION_CANCELLED), cancelled.Status)
+			},
+		},
+		{
+			name:   "cancel twice | status 409",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/reservations/%s/cancel", reservation.Id.Hex()) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "rebook a cancelled slot | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/reservations" },
+			body: func() map[string]interface{} {
+				return map[string]interface{}{"party_size": 1, "starts_at": startsAt, "duration": 60, "table": table.Id.Hex()}
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body map[string]interface{}
+			if tc.body != nil {
+				body = tc.body()
+			}
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url(), bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
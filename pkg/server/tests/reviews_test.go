@@ -0,0 +1,180 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func createTestReviewProduct(t *testing.T) store.Item {
+	item := internal.CreateNewProduct()
+	item.Id = primitive.NewObjectID()
+	item.Name = fmt.Sprintf("%s %s", item.Name, item.Id.Hex())
+	item.CreatedAt = time.Now()
+	item.UpdatedAt = time.Now()
+
+	collection := mongoClient.Database("coffeeshop").Collection("products")
+	_, err := collection.InsertOne(context.Background(), item)
+	require.NoError(t, err)
+	return item
+}
+
+func TestProductReviews(t *testing.T) {
+	ownerID, err := primitive.ObjectIDFromHex(adminID)
+	require.NoError(t, err)
+
+	item := createTestReviewProduct(t)
+	unreviewed := createTestReviewProduct(t)
+
+	order := store.Order{
+		Id:        primitive.NewObjectID(),
+		Owner:     ownerID,
+		Items:     []store.OrderItem{{Product: item.Id, Quantity: 1}},
+		Status:    string(types.ORDER_COMPLETED),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err = mongoClient.Database("coffeeshop").Collection("orders").InsertOne(context.Background(), order)
+	require.NoError(t, err)
+
+	var review store.Review
+	decodeReview := func(t *testing.T, recorder *httptest.ResponseRecorder) store.Review {
+		var result struct {
+			Status string
+			Data   store.Review
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+		return result.Data
+	}
+
+	productRatings := func(t *testing.T, id primitive.ObjectID) store.Item {
+		var product store.Item
+		err := mongoClient.Database("coffeeshop").Collection("products").FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&product)
+		require.NoError(t, err)
+		return product
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		url    func() string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "review without a completed order | status 403",
+			method: http.MethodPost,
+			url:    func() string { return fmt.Sprintf("/api/v1/products/%s/reviews", unreviewed.Id.Hex()) },
+			body:   map[string]interface{}{"rating": 5, "comment": "lovely"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "rating out of range | status 400",
+			method: http.MethodPost,
+			url:    func() string { return fmt.Sprintf("/api/v1/products/%s/reviews", item.Id.Hex()) },
+			body:   map[string]interface{}{"rating": 6, "comment": "lovely"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "review a completed order's product | status 201",
+			method: http.MethodPost,
+			url:    func() string { return fmt.Sprintf("/api/v1/products/%s/reviews", item.Id.Hex()) },
+			body:   map[string]interface{}{"rating": 4, "comment": "smooth and creamy"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				review = decodeReview(t, recorder)
+				require.Equal(t, uint32(4), review.Rating)
+
+				product := productRatings(t, item.Id)
+				require.Equal(t, 4.0, product.Ratings)
+				require.Equal(t, int64(1), product.ReviewCount)
+			},
+		},
+		{
+			name:   "review the same product twice | status 409",
+			method: http.MethodPost,
+			url:    func() string { return fmt.Sprintf("/api/v1/products/%s/reviews", item.Id.Hex()) },
+			body:   map[string]interface{}{"rating": 2, "comment": "changed my mind"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "update own review | status 200",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/reviews/%s", review.Id.Hex()) },
+			body:   map[string]interface{}{"rating": 2, "comment": "changed my mind"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, 2.0, productRatings(t, item.Id).Ratings)
+			},
+		},
+		{
+			name:   "list product reviews | status 200",
+			method: http.MethodGet,
+			url:    func() string { return fmt.Sprintf("/api/v1/products/%s/reviews", item.Id.Hex()) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				var result struct {
+					Status string
+					Data   []store.Review
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+				require.Len(t, result.Data, 1)
+			},
+		},
+		{
+			name:   "hide a review | status 200",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/reviews/%s/moderation", review.Id.Hex()) },
+			body:   map[string]interface{}{"status": "hidden", "note": "off topic"},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.Equal(t, string(types.REVIEW_HIDDEN), decodeReview(t, recorder).Status)
+
+				product := productRatings(t, item.Id)
+				require.Equal(t, 0.0, product.Ratings)
+				require.Equal(t, int64(0), product.ReviewCount)
+			},
+		},
+		{
+			name:   "delete a review | status 204",
+			method: http.MethodDelete,
+			url:    func() string { return fmt.Sprintf("/api/v1/reviews/%s", review.Id.Hex()) },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNoContent, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url(), bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", adminTestToken))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
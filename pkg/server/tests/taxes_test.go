@@ -0,0 +1,181 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTaxes(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	location := createTestLocation(t, fmt.Sprintf("Downtown %s", suffix)).Id.Hex()
+
+	coffee := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Americano %s", suffix),
+		Price:     money.New(400, money.DefaultCurrency),
+		Category:  "beverages",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	muffin := store.Item{
+		Id:        primitive.NewObjectID(),
+		Name:      fmt.Sprintf("Muffin %s", suffix),
+		Price:     money.New(200, money.DefaultCurrency),
+		Category:  "snacks",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := mongoClient.Database("coffeeshop").Collection("products").InsertMany(context.Background(), []interface{}{coffee, muffin})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, err := mongoClient.Database("coffeeshop").Collection("tax_rules").DeleteMany(context.Background(), bson.D{{Key: "location", Value: location}})
+		require.NoError(t, err)
+	})
+
+	var ruleID string
+	testCases := []struct {
+		name   string
+		method string
+		url    func() string
+		token  string
+		body   map[string]interface{}
+		check  func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "create as a customer | status 403",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/taxes" },
+			token:  userTestToken,
+			body:   map[string]interface{}{"name": "City tax", "rate": "10", "location": location},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "invalid rate | status 400",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/taxes" },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"name": "City tax", "rate": "ten", "location": location},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "exclusive tax at a location | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/taxes" },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"name": "City tax", "rate": "10", "location": location},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res struct {
+					Status string
+					Data   store.TaxRule
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Equal(t, uint32(1000), res.Data.Rate)
+				require.False(t, res.Data.Inclusive)
+			},
+		},
+		{
+			name:   "duplicate rule | status 409",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/taxes" },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"name": "City tax", "rate": "8", "location": location},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:   "lower rate for snacks | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/taxes" },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"name": "City tax", "rate": "2", "category": "snacks", "location": location},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res struct {
+					Status string
+					Data   store.TaxRule
+				}
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				ruleID = res.Data.Id.Hex()
+			},
+		},
+		{
+			name:   "update the snacks rate | status 200",
+			method: http.MethodPut,
+			url:    func() string { return fmt.Sprintf("/api/v1/taxes/%s", ruleID) },
+			token:  adminTestToken,
+			body:   map[string]interface{}{"name": "City tax", "rate": "5", "category": "snacks", "location": location},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:   "order at the location | status 201",
+			method: http.MethodPost,
+			url:    func() string { return "/api/v1/products/orders" },
+			token:  adminTestToken,
+			body: map[string]interface{}{
+				"items": []map[string]interface{}{
+					{"product": coffee.Id.Hex(), "quantity": 1},
+					{"product": muffin.Id.Hex(), "quantity": 2},
+				},
+				"location": location,
+			},
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, recorder.Code)
+				var res store.Order
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+				require.Len(t, res.Taxes, 2)
+				require.Equal(t, uint32(1000), res.Taxes[0].Rate)
+				require.Equal(t, int64(40), res.Taxes[0].Amount.Amount)
+				require.Equal(t, uint32(500), res.Taxes[1].Rate)
+				require.Equal(t, int64(20), res.Taxes[1].Amount.Amount)
+				require.Equal(t, int64(60), res.TaxTotal.Amount)
+				require.Equal(t, int64(20), res.Items[1].Tax.Amount)
+				require.Equal(t, int64(860), res.TotalAmount.Amount)
+			},
+		},
+		{
+			name:   "delete the snacks rule | status 204",
+			method: http.MethodDelete,
+			url:    func() string { return fmt.Sprintf("/api/v1/taxes/%s", ruleID) },
+			token:  adminTestToken,
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNoContent, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(tc.method, tc.url(), bytes.NewReader(data))
+			require.NoError(t, err)
+			request.Header.Set("authorization", fmt.Sprintf("Bearer %s", tc.token))
+
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package api__test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testTokens struct {
+	Status       string
+	Token        string
+	RefreshToken string `json:"refresh_token"`
+}
+
+func loginTestAdmin(t *testing.T) testTokens {
+	data, err := json.Marshal(map[string]interface{}{"email": "admin@aws.ac.uk", "password": "Abstract$87"})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/login", bytes.NewReader(data))
+	server.Router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var res testTokens
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+	require.NotEmpty(t, res.Token)
+	require.NotEmpty(t, res.RefreshToken)
+	return res
+}
+
+func TestRefreshToken(t *testing.T) {
+	login := loginTestAdmin(t)
+	var rotated testTokens
+
+	testCases := []struct {
+		name  string
+		body  func() map[string]interface{}
+		check func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name: "missing refresh token | status 400",
+			body: func() map[string]interface{} { return map[string]interface{}{} },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name: "unknown refresh token | status 401",
+			body: func() map[string]interface{} { return map[string]interface{}{"refresh_token": "not-a-token"} },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnauthorized, recorder.Code)
+			},
+		},
+		{
+			name: "rotate refresh token | status 200",
+			body: func() map[string]interface{} { return map[string]interface{}{"refresh_token": login.RefreshToken} },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rotated))
+				require.NotEmpty(t, rotated.Token)
+				require.NotEqual(t, login.RefreshToken, rotated.RefreshToken)
+			},
+		},
+		{
+			name: "reuse a spent refresh token | status 401",
+			body: func() map[string]interface{} { return map[string]interface{}{"refresh_token": login.RefreshToken} },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnauthorized, recorder.Code)
+			},
+		},
+		{
+			name: "family revoked after reuse | status 401",
+			body: func() map[string]interface{} { return map[string]interface{}{"refresh_token": rotated.RefreshToken} },
+			check: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnauthorized, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.body())
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/token/refresh", bytes.NewReader(data))
+			server.Router.ServeHTTP(recorder, request)
+			tc.check(t, recorder)
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	password, err := internal.HashPassword("Abstract$87")
+	require.NoError(t, err)
+	user := store.User{
+		Id:          primitive.NewObjectID(),
+		UserName:    fmt.Sprintf("logout%s", suffix),
+		Role:        "user",
+		Email:       fmt.Sprintf("logout%s@aws.ac.uk", suffix),
+		PhoneNumber: fmt.Sprintf("+2547%s", suffix),
+		Password:    password,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	users := mongoClient.Database("coffeeshop").Collection("users")
+	_, err = users.InsertOne(context.Background(), user)
+	require.NoError(t, err)
+
+	login := func(t *testing.T) testTokens {
+		data, err := json.Marshal(map[string]interface{}{"email": user.Email, "password": "Abstract$87"})
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/login", bytes.NewReader(data))
+		server.Router.ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var res testTokens
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		return res
+	}
+
+	send := func(method, url, token string, body interface{}) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, url, bytes.NewReader(data))
+		request.Header.Set("authorization", fmt.Sprintf("Bearer %s", token))
+		server.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	profile := fmt.Sprintf("/api/v1/users/%s", user.Id.Hex())
+
+	t.Run("logout revokes both tokens | status 204", func(t *testing.T) {
+		tokens := login(t)
+		require.Equal(t, http.StatusOK, send(http.MethodGet, profile, tokens.Token, nil).Code)
+
+		recorder := send(http.MethodPost, "/api/v1/logout", tokens.Token, map[string]interface{}{"refresh_token": tokens.RefreshToken})
+		require.Equal(t, http.StatusNoContent, recorder.Code)
+
+		require.Equal(t, http.StatusForbidden, send(http.MethodGet, profile, tokens.Token, nil).Code)
+		recorder = send(http.MethodPost, "/api/v1/token/refresh", "", map[string]interface{}{"refresh_token": tokens.RefreshToken})
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("tokens issued before a password change | status 403", func(t *testing.T) {
+		tokens := login(t)
+		require.Equal(t, http.StatusOK, send(http.MethodGet, profile, tokens.Token, nil).Code)
+
+		_, err := users.UpdateOne(context.Background(), bson.D{{Key: "_id", Value: user.Id}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "password_changed_at", Value: time.Now().Add(time.Second)}}}})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusForbidden, send(http.MethodGet, profile, tokens.Token, nil).Code)
+	})
+
+	t.Run("token issued before token ids | status 200", func(t *testing.T) {
+		envs, err := internal.LoadEnvs("../../..")
+		require.NoError(t, err)
+
+		legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"Email":     user.Email,
+			"Id":        user.Id.Hex(),
+			"IssuedAt":  time.Now().Add(2 * time.Second),
+			"ExpiredAt": time.Now().Add(time.Hour),
+		}).SignedString([]byte(envs.SECRET_ACCESS_KEY))
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, send(http.MethodGet, profile, legacy, nil).Code)
+	})
+}
@@ -2,6 +2,8 @@ package api__test
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"image"
@@ -11,11 +13,17 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/types"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var user = internal.CreateNewUser("johndoe@test.com", "doe", "+1(571)360-6677", "user")
@@ -175,6 +183,62 @@ func TestUserLogin(t *testing.T) {
 	}
 }
 
+func TestLegacyPasswordRehash(t *testing.T) {
+	suffix := primitive.NewObjectID().Hex()[18:]
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("Abstract$87"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		hash string
+	}{
+		{
+			name: "unsalted sha-256 | upgraded to argon2id",
+			hash: fmt.Sprintf("%x", sha256.New().Sum([]byte("Abstract$87"))),
+		},
+		{
+			name: "bcrypt | upgraded to argon2id",
+			hash: string(bcryptHash),
+		},
+	}
+
+	users := mongoClient.Database("coffeeshop").Collection("users")
+	for idx, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			legacy := store.User{
+				Id:          primitive.NewObjectID(),
+				UserName:    fmt.Sprintf("legacy%d%s", idx, suffix),
+				Role:        "user",
+				Email:       fmt.Sprintf("legacy%d%s@aws.ac.uk", idx, suffix),
+				PhoneNumber: fmt.Sprintf("+2547%d%s", idx, suffix),
+				Password:    tc.hash,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+			}
+			_, err := users.InsertOne(context.Background(), legacy)
+			require.NoError(t, err)
+
+			userCred, err := json.Marshal(map[string]interface{}{"email": legacy.Email, "password": "Abstract$87"})
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/login", bytes.NewReader(userCred))
+			server.Router.ServeHTTP(recorder, request)
+			require.Equal(t, http.StatusOK, recorder.Code)
+
+			var stored store.User
+			err = users.FindOne(context.Background(), bson.D{{Key: "_id", Value: legacy.Id}}).Decode(&stored)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+
+			matched, rehash, err := internal.VerifyPassword("Abstract$87", stored.Password)
+			require.NoError(t, err)
+			require.True(t, matched)
+			require.False(t, rehash)
+		})
+	}
+}
+
 func TestGetAllUsers(t *testing.T) {
 	testCases := []struct {
 		name  string
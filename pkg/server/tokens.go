@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/pkg/token"
+	"github.com/silaselisha/coffee-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RefreshTokenHandler trades a refresh token for a new access token and a
+// new refresh token. Presenting a refresh token that was already traded in
+// revokes its whole family, logging out both the thief and the user.
+func (s *Server) RefreshTokenHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tokenColl := s.Store.Collection(ctx, "coffeeshop", "refresh_tokens")
+	userColl := s.Store.Collection(ctx, "coffeeshop", "users")
+
+	payload, err := internal.ReadReqBody[types.RefreshTokenParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	lifetimes, err := internal.ParseTokenLifetimes(s.envs)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	now := time.Now()
+	refreshToken, refresh, err := internal.RotateRefreshToken(ctx, s.Store, payload.RefreshToken, lifetimes.Refresh, now)
+	if err != nil {
+		switch {
+		case errors.Is(err, internal.ErrRefreshTokenReused):
+			if err := internal.RevokeRefreshTokenFamily(ctx, tokenColl, refresh.User, refresh.Family, now); err != nil {
+				return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+			}
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusUnauthorized)
+		case errors.Is(err, internal.ErrRefreshTokenInvalid):
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusUnauthorized)
+		default:
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	var user store.User
+	err = userColl.FindOne(ctx, bson.D{{Key: "_id", Value: refresh.User}}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", internal.ErrRefreshTokenInvalid.Error()), http.StatusUnauthorized)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	accessToken, err := s.Token.CreateToken(ctx, lifetimes.Access, user.Id.Hex(), user.Email)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status       string `json:"status"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}{
+		Status:       "success",
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
+
+// LogoutHandler revokes the access token the request was made with and, when
+// one is given, the refresh token of the same login.
+func (s *Server) LogoutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	tokenColl := s.Store.Collection(ctx, "coffeeshop", "refresh_tokens")
+
+	var payload types.LogoutParams
+	if r.ContentLength != 0 {
+		var err error
+		payload, err = internal.ReadReqBody[types.LogoutParams](r.Body, s.vd)
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+		}
+	}
+
+	authPayload := ctx.Value(types.AuthPayloadKey{}).(*token.Payload)
+	userInfo := ctx.Value(types.AuthUserInfoKey{}).(*types.UserInfo)
+
+	err := s.Token.RevokeToken(ctx, authPayload)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	if payload.RefreshToken != "" {
+		err = internal.RevokeRefreshToken(ctx, tokenColl, userInfo.Id, payload.RefreshToken, time.Now())
+		if err != nil {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// streamTicketLifetime is how long a client has to open a stream with its
+// ticket. EventSource reconnects with the same URL, so a client whose ticket
+// ran out fetches a new one.
+const streamTicketLifetime = time.Minute
+
+// StreamTicketHandler issues a ticket opening the stream at the given path
+// for browsers, which cannot send the access token with EventSource and
+// WebSocket requests. Whether the user may see the stream is still checked
+// when it is opened.
+func (s *Server) StreamTicketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	payload, err := internal.ReadReqBody[types.StreamTicketParams](r.Body, s.vd)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	authPayload := ctx.Value(types.AuthPayloadKey{}).(*token.Payload)
+	ticket, err := s.Token.CreateTicket(ctx, streamTicketLifetime, authPayload.Id, authPayload.Email, payload.Path)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	result := struct {
+		Status string                      `json:"status"`
+		Data   types.StreamTicketResParams `json:"data"`
+	}{
+		Status: "success",
+		Data: types.StreamTicketResParams{
+			Ticket:    ticket,
+			ExpiresAt: time.Now().Add(streamTicketLifetime),
+		},
+	}
+	return internal.ResponseHandler(w, result, http.StatusCreated)
+}
+
+// issueTokens starts a login: a short lived access token and a refresh token
+// heading a new family.
+func (s *Server) issueTokens(ctx context.Context, id primitive.ObjectID, email string) (accessToken, refreshToken string, err error) {
+	lifetimes, err := internal.ParseTokenLifetimes(s.envs)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err = s.Token.CreateToken(ctx, lifetimes.Access, id.Hex(), email)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, _, err = internal.IssueRefreshToken(ctx, s.Store, id, primitive.NilObjectID, lifetimes.Refresh, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
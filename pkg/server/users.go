@@ -12,6 +12,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
 	"github.com/silaselisha/coffee-api/internal"
 	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/pkg/token"
@@ -43,36 +44,42 @@ func (s *Server) LoginUserHandler(ctx context.Context, w http.ResponseWriter, r
 		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 	}
 
-	if !internal.ComparePasswordEncryption(credentials.Password, user.Password) {
+	matched, rehash, err := internal.VerifyPassword(credentials.Password, user.Password)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+	if !matched {
 		err := errors.New("invalid user password or email address")
 		response := internal.NewErrorResponse("failed", err.Error())
 		return internal.ResponseHandler(w, response, http.StatusBadRequest)
 	}
 
-	jwtToken := token.NewToken(s.envs.SECRET_ACCESS_KEY)
-	days, err := strconv.Atoi(s.envs.JWT_EXPIRES_AT)
-	if err != nil {
-		return internal.ResponseHandler(w, err, http.StatusInternalServerError)
-	}
-
-	hrs := fmt.Sprintf("%dh", (days * 24))
-	duration, err := time.ParseDuration(hrs)
-	if err != nil {
-		response := internal.NewErrorResponse("failed", err.Error())
-		return internal.ResponseHandler(w, response, http.StatusInternalServerError)
+	if rehash {
+		// upgrade hashes from before argon2id, or with weaker parameters, while
+		// the password is at hand; the login goes ahead if this fails
+		hashedPassword, err := internal.HashPassword(credentials.Password)
+		if err == nil {
+			_, err = collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: user.Id}, {Key: "password", Value: user.Password}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}})
+		}
+		if err != nil {
+			log.Error().Err(err).Str("user", user.Id.Hex()).Msg("rehashing password failed")
+		}
 	}
 
-	token, err := jwtToken.CreateToken(ctx, duration, user.Id.Hex(), user.Email)
+	token, refreshToken, err := s.issueTokens(ctx, user.Id, user.Email)
 	if err != nil {
 		response := internal.NewErrorResponse("failed", err.Error())
 		return internal.ResponseHandler(w, response, http.StatusInternalServerError)
 	}
 	res := struct {
-		Status string `json:"status"`
-		Token  string `json:"token"`
+		Status       string `json:"status"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
-		Status: "success",
-		Token:  token,
+		Status:       "success",
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 	return internal.ResponseHandler(w, res, http.StatusOK)
 }
@@ -106,7 +113,10 @@ func (s *Server) CreateUserHandler(ctx context.Context, w http.ResponseWriter, r
 			return nil, err
 		}
 
-		hashedPassword := internal.PasswordEncryption([]byte(signupData.Password))
+		hashedPassword, err := internal.HashPassword(signupData.Password)
+		if err != nil {
+			return nil, err
+		}
 		// TODO: implement enums for user roles
 		user := store.User{
 			Id:          primitive.NewObjectID(),
@@ -173,31 +183,26 @@ func (s *Server) CreateUserHandler(ctx context.Context, w http.ResponseWriter, r
 		}
 	}
 
-	jwtoken := token.NewToken(s.envs.SECRET_ACCESS_KEY)
-	days, err := strconv.Atoi(s.envs.JWT_EXPIRES_AT)
-	if err != nil {
-		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
-	}
-
-	hrs := fmt.Sprintf("%dh", (days * 24))
-	duration, err := time.ParseDuration(hrs)
+	user := response.(*types.UserResParams)
+	id, err := primitive.ObjectIDFromHex(user.Id)
 	if err != nil {
 		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 	}
-	user := response.(*types.UserResParams)
-	token, err := jwtoken.CreateToken(ctx, duration, user.Id, user.Email)
+	token, refreshToken, err := s.issueTokens(ctx, id, user.Email)
 	if err != nil {
 		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 	}
 
 	result := struct {
-		Status string               `json:"status"`
-		Token  string               `json:"token"`
-		Data   *types.UserResParams `json:"data"`
+		Status       string               `json:"status"`
+		Token        string               `json:"token"`
+		RefreshToken string               `json:"refresh_token"`
+		Data         *types.UserResParams `json:"data"`
 	}{
-		Status: "success",
-		Token:  token,
-		Data:   user,
+		Status:       "success",
+		Token:        token,
+		RefreshToken: refreshToken,
+		Data:         user,
 	}
 	return internal.ResponseHandler(w, result, http.StatusCreated)
 }
@@ -540,7 +545,10 @@ func (s *Server) ResetPasswordHandler(ctx context.Context, w http.ResponseWriter
 		return internal.ResponseHandler(w, res, http.StatusBadRequest)
 	}
 
-	password := internal.PasswordEncryption([]byte(passwordResetData.Password))
+	password, err := internal.HashPassword(passwordResetData.Password)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
 	updatedAt := time.Now()
 	passwordChangedAt := time.Now()
 
@@ -557,6 +565,12 @@ func (s *Server) ResetPasswordHandler(ctx context.Context, w http.ResponseWriter
 		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
 	}
 
+	// access tokens issued before the change are rejected by AuthMiddleware
+	err = internal.RevokeUserRefreshTokens(ctx, s.Store.Collection(ctx, "coffeeshop", "refresh_tokens"), id, passwordChangedAt)
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
 	result := struct {
 		Status string `json:"status"`
 	}{
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hibiken/asynq"
+	"github.com/silaselisha/coffee-api/internal"
+	"github.com/silaselisha/coffee-api/pkg/payments"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"github.com/silaselisha/coffee-api/types"
+	"github.com/silaselisha/coffee-api/workers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxWebhookBody bounds what is read from an unauthenticated caller before
+// the signature has been checked.
+const maxWebhookBody = 1 << 20
+
+// PaymentWebhookHandler verifies a provider notification, records it and
+// leaves the work to the task processor so the provider gets a quick answer.
+// Only notifications that fail verification are refused; one received before
+// is answered with 200 and not processed again.
+func (s *Server) PaymentWebhookHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	evtColl := s.Store.Collection(ctx, "coffeeshop", "payment_events")
+
+	params := mux.Vars(r)
+	if params["provider"] != s.paymentProvider.Name() {
+		err := fmt.Errorf("unknown payment provider %s", params["provider"])
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusNotFound)
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	event, err := s.paymentProvider.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		if errors.Is(err, payments.ErrInvalidSignature) || errors.Is(err, payments.ErrStaleWebhook) {
+			return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusUnauthorized)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusBadRequest)
+	}
+
+	record := store.PaymentEvent{
+		Id:         fmt.Sprintf("%s:%s", s.paymentProvider.Name(), event.Id),
+		Provider:   s.paymentProvider.Name(),
+		EventId:    event.Id,
+		Type:       string(event.Type),
+		Intent:     event.Intent,
+		Payload:    payload,
+		ReceivedAt: time.Now(),
+	}
+	result := struct {
+		Status string `json:"status"`
+		Data   string `json:"data"`
+	}{
+		Status: "success",
+		Data:   event.Id,
+	}
+
+	_, err = evtColl.InsertOne(ctx, record)
+	if err != nil {
+		// a redelivery of an event already stored is acknowledged, or the
+		// provider would keep retrying it
+		if mongo.IsDuplicateKeyError(err) {
+			result.Data = fmt.Sprintf("event %s was already received", event.Id)
+			return internal.ResponseHandler(w, result, http.StatusOK)
+		}
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	opts := []asynq.Option{
+		asynq.MaxRetry(10),
+		asynq.Queue(workers.CriticalQueue),
+	}
+	err = s.taskDistributor.PaymentEventTask(ctx, &types.PayloadPaymentEvent{EventId: record.Id}, opts...)
+	if err != nil {
+		// forget the event so the provider's redelivery is not refused
+		_, _ = evtColl.DeleteOne(ctx, bson.D{{Key: "_id", Value: record.Id}})
+		return internal.ResponseHandler(w, internal.NewErrorResponse("failed", err.Error()), http.StatusInternalServerError)
+	}
+
+	return internal.ResponseHandler(w, result, http.StatusOK)
+}
@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/silaselisha/coffee-api/pkg/money"
+	"github.com/silaselisha/coffee-api/pkg/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// toMoney builds an aggregation expression converting the float64 major unit
+// amount expr into a money.Money document. Halves are rounded away from zero
+// to match money.Money.Percent; $round would round them to even.
+func toMoney(expr interface{}, currency string) bson.D {
+	cents := bson.D{{Key: "$multiply", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{expr, 0}}}, money.Factor(currency)}}}
+	rounded := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$gte", Value: bson.A{cents, 0}}},
+		bson.D{{Key: "$floor", Value: bson.D{{Key: "$add", Value: bson.A{cents, 0.5}}}}},
+		bson.D{{Key: "$ceil", Value: bson.D{{Key: "$subtract", Value: bson.A{cents, 0.5}}}}},
+	}}}
+
+	return bson.D{
+		{Key: "amount", Value: bson.D{{Key: "$toLong", Value: rounded}}},
+		{Key: "currency", Value: currency},
+	}
+}
+
+// mapArray applies in to every element of the array at field, exposed to in
+// as $$as.
+func mapArray(field, as string, in interface{}) bson.D {
+	return bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{field, bson.A{}}}}},
+		{Key: "as", Value: as},
+		{Key: "in", Value: in},
+	}}}
+}
+
+func merge(base string, fields bson.D) bson.D {
+	return bson.D{{Key: "$mergeObjects", Value: bson.A{base, fields}}}
+}
+
+// MoneyMinorUnits converts the float64 prices and amounts written before
+// money.Money existed into integer minor units of currency. Only documents
+// whose amounts are still plain numbers are touched, so it is safe to run
+// more than once.
+func MoneyMinorUnits(ctx context.Context, str store.Mongo, currency string) error {
+	products := str.Collection(ctx, "coffeeshop", "products")
+	productsPipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "price", Value: toMoney("$price", currency)},
+			{Key: "modifier_groups", Value: mapArray("$modifier_groups", "group", merge("$$group", bson.D{
+				{Key: "options", Value: mapArray("$$group.options", "option", merge("$$option", bson.D{
+					{Key: "price_delta", Value: toMoney("$$option.price_delta", currency)},
+				}))},
+			}))},
+		}}},
+	}
+	result, err := products.UpdateMany(ctx, bson.D{{Key: "price", Value: bson.D{{Key: "$type", Value: "number"}}}}, productsPipeline)
+	if err != nil {
+		return fmt.Errorf("migrating product prices %w", err)
+	}
+	fmt.Printf("migrated %d product(s) to %s minor units\n", result.ModifiedCount, currency)
+
+	orders := str.Collection(ctx, "coffeeshop", "orders")
+	ordersPipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "total_amount", Value: toMoney("$total_amount", currency)},
+			{Key: "total_discount", Value: toMoney("$total_discount", currency)},
+			{Key: "items", Value: mapArray("$items", "item", merge("$$item", bson.D{
+				// orders placed before modifiers existed have no unit price
+				{Key: "unit_price", Value: toMoney(bson.D{{Key: "$ifNull", Value: bson.A{
+					"$$item.unit_price",
+					bson.D{{Key: "$cond", Value: bson.A{
+						bson.D{{Key: "$gt", Value: bson.A{"$$item.quantity", 0}}},
+						bson.D{{Key: "$divide", Value: bson.A{"$$item.amount", "$$item.quantity"}}},
+						0,
+					}}},
+				}}}, currency)},
+				{Key: "amount", Value: toMoney("$$item.amount", currency)},
+				{Key: "discount", Value: toMoney("$$item.discount", currency)},
+				{Key: "modifiers", Value: mapArray("$$item.modifiers", "modifier", merge("$$modifier", bson.D{
+					{Key: "price_delta", Value: toMoney("$$modifier.price_delta", currency)},
+				}))},
+			}))},
+		}}},
+	}
+	result, err = orders.UpdateMany(ctx, bson.D{{Key: "total_amount", Value: bson.D{{Key: "$type", Value: "number"}}}}, ordersPipeline)
+	if err != nil {
+		return fmt.Errorf("migrating order amounts %w", err)
+	}
+	fmt.Printf("migrated %d order(s) to %s minor units\n", result.ModifiedCount, currency)
+
+	return nil
+}
@@ -11,6 +11,17 @@ type Querier interface {
 	UsersQueries
 	OrdersQueries
 	ProductsQueries
+	ReviewsQueries
+	ReservationsQueries
+	InvoicesQueries
+	PaymentsQueries
+	WebhooksQueries
+	PromotionsQueries
+	TaxesQueries
+	LocationsQueries
+	LoyaltyQueries
+	GiftCardsQueries
+	KitchenQueries
 }
 
 type UsersQueries interface {
@@ -19,6 +30,9 @@ type UsersQueries interface {
 	GetUserByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 	DeleteUserByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 	LoginUserHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	RefreshTokenHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	LogoutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	StreamTicketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 	ForgotPasswordHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 	ResetPasswordHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 }
@@ -34,4 +48,89 @@ type ProductsQueries interface {
 
 type OrdersQueries interface {
 	CreateOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	UpdateOrderStatusHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllOrdersHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetOrderByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	CancelOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	OrderEventsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type ReviewsQueries interface {
+	CreateReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetProductReviewsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	UpdateReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	ModerateReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DeleteReviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type ReservationsQueries interface {
+	CreateTableHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllTablesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	UpdateTableHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DeleteTableHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	CreateReservationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllReservationsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetReservationByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	CancelReservationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type InvoicesQueries interface {
+	GetInvoiceByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DownloadInvoiceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type PaymentsQueries interface {
+	PayOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetOrderPaymentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	RefundOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetOrderRefundsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type WebhooksQueries interface {
+	PaymentWebhookHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type TaxesQueries interface {
+	CreateTaxRuleHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllTaxRulesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	UpdateTaxRuleHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DeleteTaxRuleHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type LocationsQueries interface {
+	CreateLocationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllLocationsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetLocationByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	UpdateLocationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DeleteLocationHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	SetLocationProductHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DeleteLocationProductHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetLocationMenuHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetPickupSlotsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type PromotionsQueries interface {
+	CreatePromotionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllPromotionsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetPromotionByIdHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	UpdatePromotionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	DeletePromotionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type LoyaltyQueries interface {
+	GetLoyaltyBalanceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetLoyaltyTransactionsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type GiftCardsQueries interface {
+	CreateGiftCardHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetAllGiftCardsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	GetGiftCardBalanceHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+}
+
+type KitchenQueries interface {
+	GetKitchenQueueHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	BumpKitchenTicketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	RecallKitchenTicketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
+	KitchenFeedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 }
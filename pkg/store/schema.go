@@ -3,14 +3,28 @@ package store
 import (
 	"time"
 
+	"github.com/silaselisha/coffee-api/pkg/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type ModifierOption struct {
+	Name       string      `bson:"name"`
+	PriceDelta money.Money `bson:"price_delta"`
+}
+
+type ModifierGroup struct {
+	Name          string           `bson:"name"`
+	Required      bool             `bson:"required"`
+	MinSelections uint32           `bson:"min_selections"`
+	MaxSelections uint32           `bson:"max_selections"`
+	Options       []ModifierOption `bson:"options"`
+}
+
 type Item struct {
 	Id          primitive.ObjectID `bson:"_id"`
 	Images      []string           `bson:"images"`
 	Name        string             `bson:"name" validate:"required"`
-	Price       float64            `bson:"price" validate:"required"`
+	Price       money.Money        `bson:"price" validate:"required"`
 	Summary     string             `bson:"summary" validate:"required"`
 	Category    string             `bson:"category" validate:"required,oneof=beverages snacks"`
 	Discount    uint32             `bson:"discount"`
@@ -19,6 +33,10 @@ type Item struct {
 	Description string             `bson:"description" validate:"required"`
 	Ingridients []string           `bson:"ingridients" validate:"required"`
 	Ratings     float64            `bson:"ratings"`
+	ReviewCount int64              `bson:"review_count"`
+	TrackStock  bool               `bson:"track_stock"`
+	Stock       int64              `bson:"stock"`
+	Modifiers   []ModifierGroup    `bson:"modifier_groups"`
 	CreatedAt   time.Time          `bson:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at"`
 }
@@ -37,32 +55,433 @@ type User struct {
 	UpdatedAt         time.Time          `bson:"updated_at"`
 }
 
+// RefreshToken renews a login. Only a hash of the token is stored. Each
+// refresh spends the token and issues its successor in the same family, so
+// a spent token coming back means it was stolen.
+type RefreshToken struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Hash      string             `bson:"hash"`
+	User      primitive.ObjectID `bson:"user"`
+	Family    primitive.ObjectID `bson:"family"`
+	UsedAt    time.Time          `bson:"used_at,omitempty"`
+	RevokedAt time.Time          `bson:"revoked_at,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Reservation books a table for [StartsAt, EndsAt). Only confirmed
+// reservations block the table for other parties.
 type Reservation struct {
 	Id        primitive.ObjectID `bson:"_id"`
+	Table     primitive.ObjectID `bson:"table"`
+	Customer  primitive.ObjectID `bson:"customer"`
+	PartySize uint32             `bson:"party_size"`
+	StartsAt  time.Time          `bson:"starts_at"`
+	EndsAt    time.Time          `bson:"ends_at"`
+	Status    string             `bson:"status"`
+	Note      string             `bson:"note,omitempty"`
 	CreatedAt time.Time          `bson:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at"`
 }
 
+type SelectedModifier struct {
+	Group      string      `bson:"group"`
+	Option     string      `bson:"option"`
+	PriceDelta money.Money `bson:"price_delta"`
+}
+
 type OrderItem struct {
-	Product  primitive.ObjectID `bson:"product"`
-	Quantity uint32             `bson:"quantity"`
-	Amount   float64            `bson:"amount"`
-	Discount float64            `bson:"discount"`
+	Product   primitive.ObjectID `bson:"product"`
+	Quantity  uint32             `bson:"quantity"`
+	Modifiers []SelectedModifier `bson:"modifiers,omitempty"`
+	UnitPrice money.Money        `bson:"unit_price"`
+	Amount    money.Money        `bson:"amount"`
+	// product discount plus the line's share of the order's promotion
+	Discount money.Money `bson:"discount"`
+	// tax added on top of the line's price by exclusive tax rules
+	Tax money.Money `bson:"tax"`
+	// units of this line already refunded
+	RefundedQuantity uint32 `bson:"refunded_quantity,omitempty"`
+}
+
+type OrderStatusChange struct {
+	From      string             `bson:"from"`
+	To        string             `bson:"to"`
+	ChangedBy primitive.ObjectID `bson:"changed_by"`
+	Role      string             `bson:"role"`
+	Note      string             `bson:"note,omitempty"`
+	ChangedAt time.Time          `bson:"changed_at"`
 }
 
 type Order struct {
+	Id            primitive.ObjectID  `bson:"_id"`
+	Items         []OrderItem         `bson:"items"`
+	TotalAmount   money.Money         `bson:"total_amount"`
+	Owner         primitive.ObjectID  `bson:"owner"`
+	Status        string              `bson:"status"`
+	StatusHistory []OrderStatusChange `bson:"status_history"`
+	CancelReason  string              `bson:"cancel_reason,omitempty"`
+	Invoice       primitive.ObjectID  `bson:"invoice,omitempty"`
+	PaymentStatus string              `bson:"payment_status,omitempty"`
+	Payment       primitive.ObjectID  `bson:"payment,omitempty"`
+	Promotion     *AppliedPromotion   `bson:"promotion,omitempty"`
+	Loyalty       *AppliedLoyalty     `bson:"loyalty,omitempty"`
+	Location      primitive.ObjectID  `bson:"location,omitempty"`
+	PickupAt      time.Time           `bson:"pickup_at,omitempty"`
+	PickupSlot    string              `bson:"pickup_slot,omitempty"`
+	// when the order was sent to the kitchen; orders placed ahead are sent
+	// at DueAt, their pickup time less the preparation time
+	QueuedAt  time.Time         `bson:"queued_at,omitempty"`
+	DueAt     time.Time         `bson:"due_at,omitempty"`
+	Taxes     []TaxLine         `bson:"taxes,omitempty"`
+	TaxTotal  money.Money       `bson:"tax_total"`
+	GiftCards []AppliedGiftCard `bson:"gift_cards,omitempty"`
+	// part of TotalAmount paid with gift cards, the rest is charged
+	GiftCardTotal money.Money `bson:"gift_card_total"`
+	PaidTotal     money.Money `bson:"paid_total"`
+	RefundedTotal money.Money `bson:"refunded_total"`
+	TotalDiscount money.Money `bson:"total_discount"`
+	CreatedAt     time.Time   `bson:"created_at"`
+	UpdatedAt     time.Time   `bson:"updated_at"`
+}
+
+// IdempotencyRecord stores the first response produced for an Idempotency-Key
+// so that retries of the same request can be answered without re-running it.
+// Until it is completed the record is held by the request in progress, for
+// no longer than LockedUntil.
+type IdempotencyRecord struct {
+	Id          string    `bson:"_id"`
+	RequestHash string    `bson:"request_hash"`
+	Completed   bool      `bson:"completed"`
+	LockedUntil time.Time `bson:"locked_until,omitempty"`
+	StatusCode  int       `bson:"status_code,omitempty"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+// Review is a customer's rating of a product. Only published reviews count
+// towards the product's Ratings and ReviewCount.
+type Review struct {
+	Id             primitive.ObjectID `bson:"_id"`
+	Product        primitive.ObjectID `bson:"product"`
+	Author         primitive.ObjectID `bson:"author"`
+	Rating         uint32             `bson:"rating"`
+	Comment        string             `bson:"comment"`
+	Status         string             `bson:"status"`
+	ModeratedBy    primitive.ObjectID `bson:"moderated_by,omitempty"`
+	ModerationNote string             `bson:"moderation_note,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at"`
+}
+
+type CoffeeDateTable struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Capacity  uint32             `bson:"capacity"`
+	Location  string             `bson:"location"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// Payment records one attempt to charge an order through a payment
+// provider. IntentId is the provider's reference for the charge, empty
+// until the provider created it.
+type Payment struct {
 	Id            primitive.ObjectID `bson:"_id"`
-	Items         []OrderItem        `bson:"items"`
-	TotalAmount   float64            `bson:"total_amount"`
-	Owner         primitive.ObjectID `bson:"owner"`
+	Order         primitive.ObjectID `bson:"order"`
+	Customer      primitive.ObjectID `bson:"customer"`
+	Provider      string             `bson:"provider"`
+	IntentId      string             `bson:"intent_id,omitempty"`
+	Amount        money.Money        `bson:"amount"`
 	Status        string             `bson:"status"`
-	TotalDiscount float64            `bson:"total_discount"`
+	FailureReason string             `bson:"failure_reason,omitempty"`
 	CreatedAt     time.Time          `bson:"created_at"`
 	UpdatedAt     time.Time          `bson:"updated_at"`
 }
 
-type CoffeeDateTable struct{}
-type Invoice struct{}
+// Promotion is a promo code customers enter at checkout. UsedCount counts
+// the orders that redeemed it and is bounded by UsageLimit when that is set.
+type Promotion struct {
+	Id           primitive.ObjectID   `bson:"_id"`
+	Code         string               `bson:"code"`
+	Description  string               `bson:"description"`
+	Kind         string               `bson:"kind"`
+	Percent      uint32               `bson:"percent,omitempty"`
+	Amount       money.Money          `bson:"amount"`
+	BuyQuantity  uint32               `bson:"buy_quantity,omitempty"`
+	GetQuantity  uint32               `bson:"get_quantity,omitempty"`
+	MinOrder     money.Money          `bson:"min_order"`
+	Categories   []string             `bson:"categories"`
+	Products     []primitive.ObjectID `bson:"products"`
+	StartsAt     time.Time            `bson:"starts_at"`
+	EndsAt       time.Time            `bson:"ends_at,omitempty"`
+	UsageLimit   uint32               `bson:"usage_limit"`
+	PerUserLimit uint32               `bson:"per_user_limit"`
+	UsedCount    uint32               `bson:"used_count"`
+	CreatedBy    primitive.ObjectID   `bson:"created_by"`
+	CreatedAt    time.Time            `bson:"created_at"`
+	UpdatedAt    time.Time            `bson:"updated_at"`
+}
+
+// PromotionRedemption records an order that used a promotion, for the
+// per-customer limit.
+type PromotionRedemption struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Promotion primitive.ObjectID `bson:"promotion"`
+	Customer  primitive.ObjectID `bson:"customer"`
+	Order     primitive.ObjectID `bson:"order"`
+	Discount  money.Money        `bson:"discount"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// AppliedPromotion is the promotion an order was placed with and what it
+// took off each line. The line discounts are also part of the lines'
+// Discount.
+type AppliedPromotion struct {
+	Promotion primitive.ObjectID     `bson:"promotion"`
+	Code      string                 `bson:"code"`
+	Kind      string                 `bson:"kind"`
+	Discount  money.Money            `bson:"discount"`
+	Lines     []AppliedPromotionLine `bson:"lines"`
+}
+
+type AppliedPromotionLine struct {
+	Line    int                `bson:"line"`
+	Product primitive.ObjectID `bson:"product"`
+	// units of the line the promotion was applied to
+	Quantity uint32      `bson:"quantity"`
+	Discount money.Money `bson:"discount"`
+}
+
+// AppliedLoyalty is what an order's customer paid with loyalty points. The
+// line discounts are also part of the lines' Discount.
+type AppliedLoyalty struct {
+	Points   int64                `bson:"points"`
+	Discount money.Money          `bson:"discount"`
+	Lines    []AppliedLoyaltyLine `bson:"lines"`
+}
+
+type AppliedLoyaltyLine struct {
+	Line     int                `bson:"line"`
+	Product  primitive.ObjectID `bson:"product"`
+	Quantity uint32             `bson:"quantity"`
+	Discount money.Money        `bson:"discount"`
+}
+
+// GiftCard is a prepaid balance customers spend at checkout by entering its
+// code.
+type GiftCard struct {
+	Id             primitive.ObjectID `bson:"_id"`
+	Code           string             `bson:"code"`
+	InitialBalance money.Money        `bson:"initial_balance"`
+	Balance        money.Money        `bson:"balance"`
+	Note           string             `bson:"note,omitempty"`
+	IssuedBy       primitive.ObjectID `bson:"issued_by"`
+	ExpiresAt      time.Time          `bson:"expires_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at"`
+}
+
+// GiftCardTransaction logs every change of a gift card's balance. Amount is
+// negative when the card is spent; Balance is the card's balance right after.
+type GiftCardTransaction struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Card      primitive.ObjectID `bson:"card"`
+	Kind      string             `bson:"kind"`
+	Amount    money.Money        `bson:"amount"`
+	Balance   money.Money        `bson:"balance"`
+	Order     primitive.ObjectID `bson:"order,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// AppliedGiftCard is what one gift card paid towards an order. Code is
+// masked so the order does not reveal a spendable code.
+type AppliedGiftCard struct {
+	Card   primitive.ObjectID `bson:"card"`
+	Code   string             `bson:"code"`
+	Amount money.Money        `bson:"amount"`
+	// part of Amount given back by refunds or the order's cancellation;
+	// pending refunds count towards it like they do for RefundedTotal
+	Refunded money.Money `bson:"refunded"`
+}
+
+// LoyaltyAccount holds a customer's current points balance. It is keyed by
+// the user id and always equals the sum of the user's LoyaltyTransactions.
+type LoyaltyAccount struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Balance   int64              `bson:"balance"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// LoyaltyTransaction is an entry of the append-only points ledger. Points
+// are negative for redemptions and expiries; Balance is the account balance
+// right after the entry.
+type LoyaltyTransaction struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	User      primitive.ObjectID `bson:"user"`
+	Kind      string             `bson:"kind"`
+	Points    int64              `bson:"points"`
+	Balance   int64              `bson:"balance"`
+	Order     primitive.ObjectID `bson:"order,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Refund gives back part or all of an order's payment. Pending refunds
+// already count towards the order's RefundedTotal so that two refunds cannot
+// return the same money; a failed refund is taken off again.
+type Refund struct {
+	Id               primitive.ObjectID `bson:"_id"`
+	Order            primitive.ObjectID `bson:"order"`
+	Payment          primitive.ObjectID `bson:"payment"`
+	Customer         primitive.ObjectID `bson:"customer"`
+	Provider         string             `bson:"provider"`
+	ProviderRefundId string             `bson:"provider_refund_id,omitempty"`
+	Items            []RefundItem       `bson:"items"`
+	Amount           money.Money        `bson:"amount"`
+	// part of Amount put back on the gift cards the order was paid with,
+	// the provider refunds the rest
+	GiftCards     []AppliedGiftCard  `bson:"gift_cards,omitempty"`
+	Reason        string             `bson:"reason"`
+	Status        string             `bson:"status"`
+	FailureReason string             `bson:"failure_reason,omitempty"`
+	RefundedBy    primitive.ObjectID `bson:"refunded_by"`
+	// whether the units go back to stock, false when the order's
+	// cancellation already returned them
+	Restock   bool      `bson:"restock"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+type RefundItem struct {
+	Line     int                `bson:"line"`
+	Product  primitive.ObjectID `bson:"product"`
+	Quantity uint32             `bson:"quantity"`
+	Amount   money.Money        `bson:"amount"`
+}
+
+// PaymentEvent is a verified webhook delivery. Id combines the provider name
+// with the provider's event id so a replayed delivery collides on insert.
+type PaymentEvent struct {
+	Id          string    `bson:"_id"`
+	Provider    string    `bson:"provider"`
+	EventId     string    `bson:"event_id"`
+	Type        string    `bson:"type"`
+	Intent      string    `bson:"intent"`
+	Payload     []byte    `bson:"payload"`
+	ReceivedAt  time.Time `bson:"received_at"`
+	ProcessedAt time.Time `bson:"processed_at,omitempty"`
+}
+
+type Address struct {
+	Street     string `bson:"street"`
+	City       string `bson:"city"`
+	PostalCode string `bson:"postal_code"`
+	Country    string `bson:"country"`
+}
+
+// OpeningHours opens a location on Day, 0 being Sunday, from Opens until
+// Closes, clock times like "07:30" in the location's timezone.
+type OpeningHours struct {
+	Day    uint8  `bson:"day"`
+	Opens  string `bson:"opens"`
+	Closes string `bson:"closes"`
+}
+
+// Closure closes a location for a whole day, e.g. "2026-12-25".
+type Closure struct {
+	Date   string `bson:"date"`
+	Reason string `bson:"reason,omitempty"`
+}
+
+type Location struct {
+	Id           primitive.ObjectID `bson:"_id"`
+	Name         string             `bson:"name"`
+	Address      Address            `bson:"address"`
+	Timezone     string             `bson:"timezone"`
+	OpeningHours []OpeningHours     `bson:"opening_hours"`
+	Closures     []Closure          `bson:"closures"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at"`
+}
+
+// PickupSlot counts the orders to be picked up at a location in the slot
+// starting at StartsAt. Id is the location's hex id and the slot's unix time.
+type PickupSlot struct {
+	Id       string             `bson:"_id"`
+	Location primitive.ObjectID `bson:"location"`
+	StartsAt time.Time          `bson:"starts_at"`
+	Booked   int64              `bson:"booked"`
+}
+
+// LocationProduct overrides a product at one location. A Price without a
+// currency leaves the product's own price.
+type LocationProduct struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Location  primitive.ObjectID `bson:"location"`
+	Product   primitive.ObjectID `bson:"product"`
+	Available bool               `bson:"available"`
+	Price     money.Money        `bson:"price"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// TaxRule is a configured tax. Rate is in basis points and Location the hex
+// id of a location. Empty Category or Location make the rule apply to all of
+// them.
+type TaxRule struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Rate      uint32             `bson:"rate"`
+	Inclusive bool               `bson:"inclusive"`
+	Category  string             `bson:"category"`
+	Location  string             `bson:"location"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// TaxLine is the tax charged at one rate. Rate is in basis points and
+// Taxable is the amount the rate was applied to.
+type TaxLine struct {
+	Name      string      `bson:"name"`
+	Rate      uint32      `bson:"rate"`
+	Inclusive bool        `bson:"inclusive"`
+	Taxable   money.Money `bson:"taxable"`
+	Amount    money.Money `bson:"amount"`
+}
+
+type InvoiceLine struct {
+	Product   primitive.ObjectID `bson:"product"`
+	Name      string             `bson:"name"`
+	Quantity  uint32             `bson:"quantity"`
+	Modifiers []SelectedModifier `bson:"modifiers,omitempty"`
+	UnitPrice money.Money        `bson:"unit_price"`
+	Amount    money.Money        `bson:"amount"`
+	Discount  money.Money        `bson:"discount"`
+	Total     money.Money        `bson:"total"`
+}
+
+// Invoice is issued once per completed order. Number is sequential and
+// gap-free within Year, e.g. 2024-000042.
+type Invoice struct {
+	Id            primitive.ObjectID `bson:"_id"`
+	Number        string             `bson:"number"`
+	Year          int                `bson:"year"`
+	Sequence      int64              `bson:"sequence"`
+	Order         primitive.ObjectID `bson:"order"`
+	Customer      primitive.ObjectID `bson:"customer"`
+	CustomerName  string             `bson:"customer_name"`
+	CustomerEmail string             `bson:"customer_email"`
+	Lines         []InvoiceLine      `bson:"lines"`
+	Subtotal      money.Money        `bson:"subtotal"`
+	Discount      money.Money        `bson:"discount"`
+	Taxes         []TaxLine          `bson:"taxes"`
+	TaxTotal      money.Money        `bson:"tax_total"`
+	Total         money.Money        `bson:"total"`
+	ObjectKey     string             `bson:"object_key,omitempty"`
+	IssuedAt      time.Time          `bson:"issued_at"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at"`
+}
 
 type ItemList []Item
 type UserList []User
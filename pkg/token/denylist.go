@@ -0,0 +1,51 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrDenylistUnavailable is returned when a token could not be checked
+// against the denylist. It is not the token's fault, so callers should not
+// treat it as an invalid token.
+var ErrDenylistUnavailable = errors.New("token denylist unavailable")
+
+// Denylist holds tokens revoked before they expire.
+type Denylist interface {
+	Revoke(ctx context.Context, payload *Payload) error
+	Revoked(ctx context.Context, payload *Payload) (bool, error)
+}
+
+type RedisDenylist struct {
+	client *redis.Client
+}
+
+func NewRedisDenylist(opts *redis.Options) Denylist {
+	return &RedisDenylist{client: redis.NewClient(opts)}
+}
+
+// Revoke keeps a token on the list until it would have expired anyway.
+// Tokens without an id cannot be listed and are left alone.
+func (denylist *RedisDenylist) Revoke(ctx context.Context, payload *Payload) error {
+	remaining := time.Until(payload.ExpiredAt)
+	if remaining <= 0 || payload.TokenId == "" {
+		return nil
+	}
+	return denylist.client.Set(ctx, revokedKey(payload.TokenId), 1, remaining).Err()
+}
+
+func (denylist *RedisDenylist) Revoked(ctx context.Context, payload *Payload) (bool, error) {
+	count, err := denylist.client.Exists(ctx, revokedKey(payload.TokenId)).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func revokedKey(tokenId string) string {
+	return fmt.Sprintf("tokens:revoked:%s", tokenId)
+}
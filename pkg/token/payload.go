@@ -3,9 +3,16 @@ package token
 import (
 	"errors"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Payload struct {
+	// TokenId names the token so it can be revoked before it expires
+	TokenId string `json:"jti"`
+	// Scope is the path of the only stream a ticket opens, empty for
+	// access tokens
+	Scope     string `json:"scope,omitempty"`
 	Email     string
 	Id        string
 	IssuedAt  time.Time
@@ -13,7 +20,13 @@ type Payload struct {
 }
 
 func createNewPayload(duration time.Duration, id, email string) (*Payload, error) {
+	tokenId, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Payload{
+		TokenId:   tokenId.String(),
 		Email:     email,
 		Id:        id,
 		IssuedAt:  time.Now(),
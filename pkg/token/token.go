@@ -11,16 +11,20 @@ import (
 
 type Token interface {
 	CreateToken(ctx context.Context, duration time.Duration, id, email string) (string, error)
+	CreateTicket(ctx context.Context, duration time.Duration, id, email, scope string) (string, error)
 	VerifyToken(ctx context.Context, token string) (*Payload, error)
+	RevokeToken(ctx context.Context, payload *Payload) error
 }
 
 type JWToken struct {
-	secret string
+	secret   string
+	denylist Denylist
 }
 
-func NewToken(secret string) Token {
+func NewToken(secret string, denylist Denylist) Token {
 	return &JWToken{
-		secret: secret,
+		secret:   secret,
+		denylist: denylist,
 	}
 }
 
@@ -38,6 +42,25 @@ func (tkn *JWToken) CreateToken(ctx context.Context, duration time.Duration, id
 	return tokenString, nil
 }
 
+// CreateTicket creates a token that only opens the stream at the path scope.
+// Browsers cannot set an Authorization header on EventSource and WebSocket
+// requests, so tickets are sent in the query string instead and are kept
+// short lived since URLs end up in logs.
+func (tkn *JWToken) CreateTicket(ctx context.Context, duration time.Duration, id, email, scope string) (string, error) {
+	payload, err := createNewPayload(duration, id, email)
+	if err != nil {
+		return "", err
+	}
+	payload.Scope = scope
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
+
+	tokenString, err := token.SignedString([]byte(tkn.secret))
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return tokenString, nil
+}
+
 func (tkn *JWToken) VerifyToken(ctx context.Context, tok string) (*Payload, error) {
 	keyFunc := func(token *jwt.Token) (interface{}, error) {
 		_, ok := token.Method.(*jwt.SigningMethodHMAC)
@@ -61,5 +84,24 @@ func (tkn *JWToken) VerifyToken(ctx context.Context, tok string) (*Payload, erro
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	// tokens without an id were issued before revocation existed; they are
+	// accepted until they expire, but logging out cannot revoke them
+	if payload.TokenId == "" {
+		return payload, nil
+	}
+
+	revoked, err := tkn.denylist.Revoked(ctx, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDenylistUnavailable, err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("revoked token")
+	}
+
 	return payload, nil
 }
+
+// RevokeToken rejects a token from now on, even though it has not expired.
+func (tkn *JWToken) RevokeToken(ctx context.Context, payload *Payload) error {
+	return tkn.denylist.Revoke(ctx, payload)
+}
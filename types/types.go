@@ -3,6 +3,7 @@ package types
 import (
 	"time"
 
+	"github.com/silaselisha/coffee-api/pkg/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -13,13 +14,94 @@ const (
 	PAID PaymentStatus = iota
 	PENDING
 	REJECTED
+	// every paid amount has been given back
+	REFUNDED
 )
 
+func (ps PaymentStatus) String() string {
+	switch ps {
+	case PAID:
+		return "paid"
+	case PENDING:
+		return "pending"
+	case REJECTED:
+		return "rejected"
+	case REFUNDED:
+		return "refunded"
+	default:
+		return "unknown"
+	}
+}
+
 const (
 	USER UserRole = iota
 	ADMIN
 )
 
+type OrderStatus string
+
+const (
+	ORDER_PENDING   OrderStatus = "pending"
+	ORDER_ACCEPTED  OrderStatus = "accepted"
+	ORDER_PREPARING OrderStatus = "preparing"
+	ORDER_READY     OrderStatus = "ready"
+	ORDER_COMPLETED OrderStatus = "completed"
+	ORDER_CANCELLED OrderStatus = "cancelled"
+	ORDER_REJECTED  OrderStatus = "rejected"
+)
+
+type ReviewStatus string
+
+const (
+	REVIEW_PUBLISHED ReviewStatus = "published"
+	REVIEW_HIDDEN    ReviewStatus = "hidden"
+)
+
+type PromotionKind string
+
+const (
+	PROMOTION_PERCENTAGE PromotionKind = "percentage"
+	PROMOTION_FIXED      PromotionKind = "fixed"
+	// every BuyQuantity eligible units bought get the next GetQuantity free
+	PROMOTION_BUY_X_GET_Y PromotionKind = "bxgy"
+)
+
+type LoyaltyKind string
+
+const (
+	LOYALTY_EARN   LoyaltyKind = "earn"
+	LOYALTY_REDEEM LoyaltyKind = "redeem"
+	// points of a cancelled or rejected order's redemption given back
+	LOYALTY_RETURN LoyaltyKind = "return"
+	LOYALTY_EXPIRE LoyaltyKind = "expire"
+)
+
+type GiftCardKind string
+
+const (
+	GIFT_CARD_ISSUE  GiftCardKind = "issue"
+	GIFT_CARD_REDEEM GiftCardKind = "redeem"
+	// balance of a cancelled or rejected order given back
+	GIFT_CARD_RETURN GiftCardKind = "return"
+	// share of an order's refund put back on the card
+	GIFT_CARD_REFUND GiftCardKind = "refund"
+)
+
+type RefundStatus string
+
+const (
+	REFUND_PENDING   RefundStatus = "pending"
+	REFUND_SUCCEEDED RefundStatus = "succeeded"
+	REFUND_FAILED    RefundStatus = "failed"
+)
+
+type ReservationStatus string
+
+const (
+	RESERVATION_CONFIRMED ReservationStatus = "confirmed"
+	RESERVATION_CANCELLED ReservationStatus = "cancelled"
+)
+
 type FileMetadata struct {
 	ContetntType string
 }
@@ -34,6 +116,32 @@ type PayloadSendMail struct {
 	Email string `json:"email"`
 }
 
+type PayloadOrderNotification struct {
+	OrderId string `json:"orderId"`
+	Email   string `json:"email"`
+}
+
+type PayloadReservationNotification struct {
+	ReservationId string `json:"reservationId"`
+	Email         string `json:"email"`
+}
+
+type PayloadInvoice struct {
+	InvoiceId string `json:"invoiceId"`
+}
+
+type PayloadRefund struct {
+	RefundId string `json:"refundId"`
+}
+
+type PayloadPaymentEvent struct {
+	EventId string `json:"eventId"`
+}
+
+type PayloadScheduledOrder struct {
+	OrderId string `json:"orderId"`
+}
+
 type UserReqParams struct {
 	UserName    string `bson:"username" validate:"required"`
 	Email       string `bson:"email" validate:"required"`
@@ -55,21 +163,38 @@ type UserResParams struct {
 
 type UserResListParams []UserResParams
 
+type ModifierOptionParams struct {
+	Name       string      `json:"name" validate:"required"`
+	PriceDelta money.Money `json:"price_delta"`
+}
+
+type ModifierGroupParams struct {
+	Name          string                 `json:"name" validate:"required"`
+	Required      bool                   `json:"required"`
+	MinSelections uint32                 `json:"min_selections"`
+	MaxSelections uint32                 `json:"max_selections"`
+	Options       []ModifierOptionParams `json:"options" validate:"required,min=1,dive"`
+}
+
 type ItemResParams struct {
-	Id          string             `json:"_id"`
-	Images      []string           `json:"images"`
-	Name        string             `json:"name"`
-	Author      primitive.ObjectID `json:"author"`
-	Price       float64            `json:"price"`
-	Discount    uint32             `json:"discount"`
-	Summary     string             `json:"summary"`
-	Category    string             `json:"category"`
-	Thumbnail   string             `json:"thumbnail"`
-	Description string             `json:"description"`
-	Ingridients []string           `json:"ingridients"`
-	Ratings     float64            `json:"ratings"`
-	CreatedAt   time.Time          `json:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at"`
+	Id          string                `json:"_id"`
+	Images      []string              `json:"images"`
+	Name        string                `json:"name"`
+	Author      primitive.ObjectID    `json:"author"`
+	Price       money.Money           `json:"price"`
+	Discount    uint32                `json:"discount"`
+	Summary     string                `json:"summary"`
+	Category    string                `json:"category"`
+	Thumbnail   string                `json:"thumbnail"`
+	Description string                `json:"description"`
+	Ingridients []string              `json:"ingridients"`
+	Ratings     float64               `json:"ratings"`
+	ReviewCount int64                 `json:"review_count"`
+	TrackStock  bool                  `json:"track_stock"`
+	Stock       int64                 `json:"stock"`
+	Modifiers   []ModifierGroupParams `json:"modifier_groups"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
 }
 
 type ItemResponseListParams []ItemResParams
@@ -92,6 +217,26 @@ type PasswordResetParams struct {
 	Password        string `bson:"password" validate:"required"`
 	ConfirmPassword string `bson:"confirmPassword" validate:"required"`
 }
+type RefreshTokenParams struct {
+	RefreshToken string `json:"refresh_token" bson:"refresh_token" validate:"required"`
+}
+
+// LogoutParams ends the login of RefreshToken too when it is given.
+type LogoutParams struct {
+	RefreshToken string `json:"refresh_token" bson:"refresh_token" validate:"omitempty"`
+}
+
+// StreamTicketParams names the stream a ticket is wanted for, e.g.
+// /api/v1/orders/{id}/events.
+type StreamTicketParams struct {
+	Path string `json:"path" bson:"path" validate:"required,startswith=/api/v1/"`
+}
+
+type StreamTicketResParams struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 type ForgotPasswordParams struct {
 	Email string `bson:"email" validate:"required"`
 }
@@ -101,15 +246,214 @@ type ErrorResParams struct {
 	Error  string `json:"error"`
 }
 
+type OrderModifierParams struct {
+	Group  string `bson:"group" validate:"required"`
+	Option string `bson:"option" validate:"required"`
+}
+
 type OrderItemParams struct {
-	Product  string  `bson:"product"`
-	Quantity uint32  `bson:"quantity"`
-	Amount   float64 `bson:"amount"`
-	Discount float64 `bson:"discount"`
+	Product   string                `bson:"product"`
+	Quantity  uint32                `bson:"quantity" validate:"required,gt=0"`
+	Modifiers []OrderModifierParams `bson:"modifiers" validate:"dive"`
+	// units of this line paid for with loyalty points
+	Redeem uint32 `json:"redeem" bson:"redeem" validate:"ltefield=Quantity"`
 }
 
 type OrderParams struct {
-	Items []OrderItemParams `bson:"items" validate:"required"`
+	Items     []OrderItemParams `bson:"items" validate:"required,dive"`
+	PromoCode string            `json:"promo_code" bson:"promo_code" validate:"omitempty,max=32"`
+	// gift card codes, drawn on in the order given
+	GiftCards []string `json:"gift_cards" bson:"gift_cards" validate:"omitempty,max=5,unique,dive,required"`
+	// id of the shop location the order is placed at
+	Location string `json:"location" bson:"location" validate:"omitempty,mongodb"`
+	// when the customer will collect the order, for orders placed ahead;
+	// needs a location
+	PickupAt time.Time `json:"pickup_at" bson:"pickup_at"`
+}
+
+type StockShortageParams struct {
+	Product   string `json:"product"`
+	Name      string `json:"name"`
+	Requested int64  `json:"requested"`
+	Available int64  `json:"available"`
+}
+
+type StockErrorResParams struct {
+	Status string                `json:"status"`
+	Error  string                `json:"error"`
+	Items  []StockShortageParams `json:"items"`
+}
+
+type PickupSlotResParams struct {
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Available int64     `json:"available"`
+}
+
+// OrderEventResParams is the data of an order's "status" server-sent event.
+type OrderEventResParams struct {
+	Order     string    `json:"order"`
+	From      string    `json:"from,omitempty"`
+	Status    string    `json:"status"`
+	Note      string    `json:"note,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type KitchenTicketItemResParams struct {
+	Product   string   `json:"product"`
+	Name      string   `json:"name"`
+	Quantity  uint32   `json:"quantity"`
+	Modifiers []string `json:"modifiers,omitempty"`
+}
+
+// KitchenTicketResParams is an order as the kitchen display shows it.
+type KitchenTicketResParams struct {
+	Order    string                       `json:"order"`
+	Status   string                       `json:"status"`
+	Location string                       `json:"location,omitempty"`
+	QueuedAt time.Time                    `json:"queued_at"`
+	PickupAt *time.Time                   `json:"pickup_at,omitempty"`
+	Items    []KitchenTicketItemResParams `json:"items"`
+}
+
+// KitchenFeedMessage is sent over the kitchen feed: the whole queue once the
+// socket opens, then a ticket whenever one is added or changes status.
+// Tickets that are no longer open should be taken off the board.
+type KitchenFeedMessage struct {
+	Type    string                   `json:"type"`
+	Tickets []KitchenTicketResParams `json:"tickets,omitempty"`
+	Ticket  *KitchenTicketResParams  `json:"ticket,omitempty"`
+}
+
+type OrderCancelParams struct {
+	Reason string `bson:"reason" validate:"required"`
+}
+
+type OrderStatusParams struct {
+	Status string `bson:"status" validate:"required,oneof=pending accepted preparing ready completed cancelled rejected"`
+	Note   string `bson:"note"`
+}
+
+type ReviewParams struct {
+	Rating  uint32 `bson:"rating" validate:"required,min=1,max=5"`
+	Comment string `bson:"comment" validate:"required,max=2000"`
+}
+
+type ReviewModerationParams struct {
+	Status string `bson:"status" validate:"required,oneof=published hidden"`
+	Note   string `bson:"note"`
+}
+
+type TableParams struct {
+	Name     string `bson:"name" validate:"required"`
+	Capacity uint32 `bson:"capacity" validate:"required,min=1"`
+	Location string `bson:"location" validate:"required"`
+}
+
+// ReservationParams asks for a table for PartySize guests from StartsAt for
+// Duration minutes. Table optionally names the preferred table.
+type ReservationParams struct {
+	PartySize uint32    `json:"party_size" bson:"party_size" validate:"required,min=1"`
+	StartsAt  time.Time `json:"starts_at" bson:"starts_at" validate:"required"`
+	Duration  uint32    `json:"duration" bson:"duration" validate:"omitempty,min=15,max=240"`
+	Table     string    `json:"table" bson:"table" validate:"omitempty,mongodb"`
+	Note      string    `json:"note" bson:"note"`
+}
+
+type PaymentParams struct {
+	PaymentMethod string `json:"payment_method" bson:"payment_method" validate:"required"`
+}
+
+// PromotionParams describes a promo code. Amount and MinOrder are decimal
+// amounts in the shop's currency. A promotion without Categories or Products
+// applies to the whole order; EndsAt and the limits are unbounded when zero.
+type PromotionParams struct {
+	Code         string    `json:"code" bson:"code" validate:"required,alphanum,min=3,max=32"`
+	Description  string    `json:"description" bson:"description" validate:"max=500"`
+	Kind         string    `json:"kind" bson:"kind" validate:"required,oneof=percentage fixed bxgy"`
+	Percent      uint32    `json:"percent" bson:"percent" validate:"required_if=Kind percentage,max=100"`
+	Amount       string    `json:"amount" bson:"amount" validate:"required_if=Kind fixed"`
+	BuyQuantity  uint32    `json:"buy_quantity" bson:"buy_quantity" validate:"required_if=Kind bxgy"`
+	GetQuantity  uint32    `json:"get_quantity" bson:"get_quantity" validate:"required_if=Kind bxgy"`
+	MinOrder     string    `json:"min_order" bson:"min_order"`
+	Categories   []string  `json:"categories" bson:"categories" validate:"dive,oneof=beverages snacks"`
+	Products     []string  `json:"products" bson:"products" validate:"dive,mongodb"`
+	StartsAt     time.Time `json:"starts_at" bson:"starts_at" validate:"required"`
+	EndsAt       time.Time `json:"ends_at" bson:"ends_at" validate:"omitempty,gtfield=StartsAt"`
+	UsageLimit   uint32    `json:"usage_limit" bson:"usage_limit"`
+	PerUserLimit uint32    `json:"per_user_limit" bson:"per_user_limit"`
+}
+
+// TaxRuleParams describes a tax. Rate is a percentage such as "16" or "7.5".
+// A rule without Category applies to every category and one without Location
+// to every location; the most specific rule of each Name wins.
+type TaxRuleParams struct {
+	Name      string `json:"name" bson:"name" validate:"required,max=32"`
+	Rate      string `json:"rate" bson:"rate" validate:"required"`
+	Inclusive bool   `json:"inclusive" bson:"inclusive"`
+	Category  string `json:"category" bson:"category" validate:"omitempty,oneof=beverages snacks"`
+	Location  string `json:"location" bson:"location" validate:"omitempty,mongodb"`
+}
+
+type AddressParams struct {
+	Street     string `json:"street" bson:"street" validate:"required,max=200"`
+	City       string `json:"city" bson:"city" validate:"required,max=100"`
+	PostalCode string `json:"postal_code" bson:"postal_code" validate:"max=20"`
+	Country    string `json:"country" bson:"country" validate:"required,max=100"`
+}
+
+// OpeningHoursParams opens a location on Day, 0 being Sunday, from Opens
+// until Closes. Both are "15:04" clock times in the location's timezone and
+// Closes may be "24:00".
+type OpeningHoursParams struct {
+	Day    uint8  `json:"day" bson:"day" validate:"max=6"`
+	Opens  string `json:"opens" bson:"opens" validate:"required,len=5"`
+	Closes string `json:"closes" bson:"closes" validate:"required,len=5"`
+}
+
+// ClosureParams closes a location for the whole of Date, a "2006-01-02" day
+// in the location's timezone.
+type ClosureParams struct {
+	Date   string `json:"date" bson:"date" validate:"required,datetime=2006-01-02"`
+	Reason string `json:"reason" bson:"reason" validate:"max=200"`
+}
+
+// LocationParams describes a shop. Timezone is an IANA name such as
+// "Africa/Nairobi".
+type LocationParams struct {
+	Name         string               `json:"name" bson:"name" validate:"required,max=64"`
+	Address      AddressParams        `json:"address" bson:"address" validate:"required"`
+	Timezone     string               `json:"timezone" bson:"timezone" validate:"required"`
+	OpeningHours []OpeningHoursParams `json:"opening_hours" bson:"opening_hours" validate:"required,min=1,dive"`
+	Closures     []ClosureParams      `json:"closures" bson:"closures" validate:"omitempty,dive"`
+}
+
+// LocationProductParams overrides a product at one location. Price is a
+// decimal amount in the shop's currency; empty keeps the product's price.
+type LocationProductParams struct {
+	Available bool   `json:"available" bson:"available"`
+	Price     string `json:"price" bson:"price"`
+}
+
+// GiftCardParams issues a card worth Amount, a decimal amount in the shop's
+// currency. Cards without ExpiresAt never expire.
+type GiftCardParams struct {
+	Amount    string    `json:"amount" bson:"amount" validate:"required"`
+	Note      string    `json:"note" bson:"note" validate:"max=500"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// RefundItemParams refunds Quantity units of the order line at index Line.
+type RefundItemParams struct {
+	Line     int    `json:"line" bson:"line" validate:"min=0"`
+	Quantity uint32 `json:"quantity" bson:"quantity" validate:"required,min=1"`
+}
+
+// RefundParams refunds the listed lines, or everything not yet refunded
+// when Items is empty.
+type RefundParams struct {
+	Reason string             `json:"reason" bson:"reason" validate:"required,max=500"`
+	Items  []RefundItemParams `json:"items" bson:"items" validate:"omitempty,dive"`
 }
 
 type Config struct {
@@ -126,4 +470,32 @@ type Config struct {
 	SECRET_ACCESS_KEY    string `mapstructure:"SECRET_ACCESS_KEY"`
 	REDIS_SERVER_PORT    string `mapstructure:"REDIS_SERVER_PORT"`
 	REDIS_SERVER_ADDRESS string `mapstructure:"REDIS_SERVER_ADDRESS"`
+	DEFAULT_CURRENCY     string `mapstructure:"DEFAULT_CURRENCY"`
+	// minutes after placement during which a customer may still cancel an
+	// order that has already been accepted
+	ORDER_CANCEL_GRACE_PERIOD string `mapstructure:"ORDER_CANCEL_GRACE_PERIOD"`
+	// percentage of VAT already included in menu prices, e.g. 16 or 7.5,
+	// used while no tax rules are configured
+	TAX_RATE               string `mapstructure:"TAX_RATE"`
+	PAYMENT_PROVIDER       string `mapstructure:"PAYMENT_PROVIDER"`
+	PAYMENT_WEBHOOK_SECRET string `mapstructure:"PAYMENT_WEBHOOK_SECRET"`
+	// points earned per whole unit of currency spent on a completed order,
+	// 1 when not set
+	LOYALTY_POINTS_PER_UNIT string `mapstructure:"LOYALTY_POINTS_PER_UNIT"`
+	// points one free item costs at checkout, 100 when not set
+	LOYALTY_POINTS_PER_FREE_ITEM string `mapstructure:"LOYALTY_POINTS_PER_FREE_ITEM"`
+	// days after which unspent points expire, 365 when not set
+	LOYALTY_POINTS_EXPIRE_DAYS string `mapstructure:"LOYALTY_POINTS_EXPIRE_DAYS"`
+	// length of a pickup slot in minutes, 15 when not set
+	PICKUP_SLOT_MINUTES string `mapstructure:"PICKUP_SLOT_MINUTES"`
+	// orders that may be picked up in one slot, 10 when not set
+	PICKUP_SLOT_CAPACITY string `mapstructure:"PICKUP_SLOT_CAPACITY"`
+	// minutes before pickup an order is sent to the kitchen, 10 when not set
+	PICKUP_PREP_MINUTES string `mapstructure:"PICKUP_PREP_MINUTES"`
+	// lifetime of access tokens in minutes, 15 when not set; logins are
+	// renewed with refresh tokens for JWT_EXPIRES_AT days
+	ACCESS_TOKEN_MINUTES string `mapstructure:"ACCESS_TOKEN_MINUTES"`
+	// id of the location orders are placed at when they name none; without
+	// it orders must name a location once any exists
+	DEFAULT_LOCATION string `mapstructure:"DEFAULT_LOCATION"`
 }
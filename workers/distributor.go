@@ -10,11 +10,20 @@ import (
 )
 
 const (
-	UPLOAD_S3_OBJECT           = "task:upload_s3_object"
-	UPLOAD_MULTIPLE_S3_OBJECTS = "task:upload_multiple_s3_objects"
-	DELETE_S3_OBJECT           = "task:delete_s3_object"
-	SEND_VERIFICATION_EMAIL    = "task:send_verification_email"
-	SEND_PASSWORD_RESET_EMAIL  = "task:send_password_reset_email"
+	UPLOAD_S3_OBJECT                    = "task:upload_s3_object"
+	UPLOAD_MULTIPLE_S3_OBJECTS          = "task:upload_multiple_s3_objects"
+	DELETE_S3_OBJECT                    = "task:delete_s3_object"
+	SEND_VERIFICATION_EMAIL             = "task:send_verification_email"
+	SEND_PASSWORD_RESET_EMAIL           = "task:send_password_reset_email"
+	SEND_ORDER_CANCELLED_EMAIL          = "task:send_order_cancelled_email"
+	SEND_RESERVATION_CONFIRMATION_EMAIL = "task:send_reservation_confirmation_email"
+	SEND_RESERVATION_REMINDER_EMAIL     = "task:send_reservation_reminder_email"
+	GENERATE_INVOICE_PDF                = "task:generate_invoice_pdf"
+	PROCESS_PAYMENT_EVENT               = "task:process_payment_event"
+	SEND_REFUND_RECEIPT_EMAIL           = "task:send_refund_receipt_email"
+	EXPIRE_LOYALTY_POINTS               = "task:expire_loyalty_points"
+	QUEUE_SCHEDULED_ORDER               = "task:queue_scheduled_order"
+	QUEUE_DUE_ORDERS                    = "task:queue_due_orders"
 )
 
 type TaskDistributor interface {
@@ -23,6 +32,13 @@ type TaskDistributor interface {
 	S3ObjectUploadTask(ctx context.Context, payload *types.PayloadUploadImage, opts ...asynq.Option) error
 	MultipleS3ObjectUploadTask(ctx context.Context, payload []*types.PayloadUploadImage, opts ...asynq.Option) error
 	S3ObjectDeleteTask(ctx context.Context, images []string, opts ...asynq.Option) error
+	OrderCancelledMailTask(ctx context.Context, payload *types.PayloadOrderNotification, opts ...asynq.Option) error
+	ReservationConfirmationMailTask(ctx context.Context, payload *types.PayloadReservationNotification, opts ...asynq.Option) error
+	ReservationReminderMailTask(ctx context.Context, payload *types.PayloadReservationNotification, opts ...asynq.Option) error
+	InvoicePDFTask(ctx context.Context, payload *types.PayloadInvoice, opts ...asynq.Option) error
+	PaymentEventTask(ctx context.Context, payload *types.PayloadPaymentEvent, opts ...asynq.Option) error
+	RefundReceiptMailTask(ctx context.Context, payload *types.PayloadRefund, opts ...asynq.Option) error
+	QueueScheduledOrderTask(ctx context.Context, payload *types.PayloadScheduledOrder, opts ...asynq.Option) error
 }
 
 type RedisClientTaskDistributor struct {
@@ -116,3 +132,115 @@ func (dist *RedisClientTaskDistributor) S3ObjectDeleteTask(ctx context.Context,
 	fmt.Printf("Enqueued task: %v of max retries: %v\n", info.Type, info.MaxRetry)
 	return nil
 }
+
+func (dist *RedisClientTaskDistributor) OrderCancelledMailTask(ctx context.Context, payload *types.PayloadOrderNotification, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(SEND_ORDER_CANCELLED_EMAIL, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
+
+func (dist *RedisClientTaskDistributor) ReservationConfirmationMailTask(ctx context.Context, payload *types.PayloadReservationNotification, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(SEND_RESERVATION_CONFIRMATION_EMAIL, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
+
+func (dist *RedisClientTaskDistributor) ReservationReminderMailTask(ctx context.Context, payload *types.PayloadReservationNotification, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(SEND_RESERVATION_REMINDER_EMAIL, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
+
+func (dist *RedisClientTaskDistributor) InvoicePDFTask(ctx context.Context, payload *types.PayloadInvoice, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(GENERATE_INVOICE_PDF, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
+
+func (dist *RedisClientTaskDistributor) PaymentEventTask(ctx context.Context, payload *types.PayloadPaymentEvent, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(PROCESS_PAYMENT_EVENT, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
+
+func (dist *RedisClientTaskDistributor) RefundReceiptMailTask(ctx context.Context, payload *types.PayloadRefund, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(SEND_REFUND_RECEIPT_EMAIL, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
+
+func (dist *RedisClientTaskDistributor) QueueScheduledOrderTask(ctx context.Context, payload *types.PayloadScheduledOrder, opts ...asynq.Option) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error %w", err)
+	}
+
+	task := asynq.NewTask(QUEUE_SCHEDULED_ORDER, data, opts...)
+	info, err := dist.client.EnqueueContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("enqueueing task error %w", err)
+	}
+
+	fmt.Printf("Enqueued task: %v of max retries: %v on payload: %v\n", info.Type, info.MaxRetry, string(info.Payload))
+	return nil
+}
@@ -7,13 +7,17 @@ import (
 	"time"
 
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/silaselisha/coffee-api/internal"
 	"github.com/silaselisha/coffee-api/internal/aws"
 	"github.com/silaselisha/coffee-api/internal/mail"
+	"github.com/silaselisha/coffee-api/pkg/events"
+	"github.com/silaselisha/coffee-api/pkg/payments"
 	"github.com/silaselisha/coffee-api/pkg/store"
 	"github.com/silaselisha/coffee-api/types"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -28,6 +32,15 @@ type TaskProcessor interface {
 	ProcessTaskUploadS3Object(ctx context.Context, task *asynq.Task) error
 	ProcessTaskDeleteS3Object(ctx context.Context, task *asynq.Task) error
 	ProcessTaskMultipleUploadS3Object(ctx context.Context, task *asynq.Task) error
+	ProcessTaskSendOrderCancelledMail(ctx context.Context, task *asynq.Task) error
+	ProcessTaskSendReservationConfirmationMail(ctx context.Context, task *asynq.Task) error
+	ProcessTaskSendReservationReminderMail(ctx context.Context, task *asynq.Task) error
+	ProcessTaskGenerateInvoicePDF(ctx context.Context, task *asynq.Task) error
+	ProcessTaskPaymentEvent(ctx context.Context, task *asynq.Task) error
+	ProcessTaskSendRefundReceiptMail(ctx context.Context, task *asynq.Task) error
+	ProcessTaskExpireLoyaltyPoints(ctx context.Context, task *asynq.Task) error
+	ProcessTaskQueueScheduledOrder(ctx context.Context, task *asynq.Task) error
+	ProcessTaskQueueDueOrders(ctx context.Context, task *asynq.Task) error
 }
 
 type RedisSrvTaskProcessor struct {
@@ -35,6 +48,7 @@ type RedisSrvTaskProcessor struct {
 	store              store.Mongo
 	envs               types.Config
 	coffeeShopS3Bucket aws.CoffeeShopBucket
+	broker             events.Broker
 }
 
 func NewTaskServerProcessor(opts asynq.RedisClientOpt, store store.Mongo, envs types.Config, coffeeShopS3Bucket aws.CoffeeShopBucket) TaskProcessor {
@@ -51,6 +65,7 @@ func NewTaskServerProcessor(opts asynq.RedisClientOpt, store store.Mongo, envs t
 		store:              store,
 		envs:               envs,
 		coffeeShopS3Bucket: coffeeShopS3Bucket,
+		broker:             events.NewRedisBroker(&redis.Options{Addr: opts.Addr, Password: opts.Password, DB: opts.DB}),
 	}
 }
 
@@ -156,6 +171,315 @@ func (processor *RedisSrvTaskProcessor) ProcessTaskDeleteS3Object(ctx context.Co
 	return nil
 }
 
+func (processor *RedisSrvTaskProcessor) ProcessTaskSendOrderCancelledMail(ctx context.Context, task *asynq.Task) error {
+	order, payload, err := getOrderFromTask(ctx, processor, task)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving order %w", err)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	transporter := mail.NewSMTPTransporter(&processor.envs)
+	message := fmt.Sprintf("order %s was cancelled at %v, reason: %s", order.Id.Hex(), order.UpdatedAt.Format(time.RFC1123), order.CancelReason)
+
+	err = transporter.MailSender(ctx, payload.Email, []byte(message))
+	if err != nil {
+		return fmt.Errorf("error occured while sending an order cancellation mail to %s at %v err %w", payload.Email, time.Now(), err)
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+func (processor *RedisSrvTaskProcessor) ProcessTaskSendReservationConfirmationMail(ctx context.Context, task *asynq.Task) error {
+	reservation, table, payload, err := getReservationFromTask(ctx, processor, task)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving reservation %w", err)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	transporter := mail.NewSMTPTransporter(&processor.envs)
+	message := fmt.Sprintf("reservation %s confirmed: table %s (%s) for %d on %v until %v",
+		reservation.Id.Hex(), table.Name, table.Location, reservation.PartySize,
+		reservation.StartsAt.Format(time.RFC1123), reservation.EndsAt.Format(time.Kitchen))
+
+	err = transporter.MailSender(ctx, payload.Email, []byte(message))
+	if err != nil {
+		return fmt.Errorf("error occured while sending a reservation confirmation mail to %s at %v err %w", payload.Email, time.Now(), err)
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+func (processor *RedisSrvTaskProcessor) ProcessTaskSendReservationReminderMail(ctx context.Context, task *asynq.Task) error {
+	reservation, table, payload, err := getReservationFromTask(ctx, processor, task)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving reservation %w", err)
+	}
+
+	// the reminder was scheduled when the table was booked and is not
+	// withdrawn on cancellation, so check the reservation still stands
+	if reservation.Status != string(types.RESERVATION_CONFIRMED) {
+		return nil
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	transporter := mail.NewSMTPTransporter(&processor.envs)
+	message := fmt.Sprintf("reminder: table %s (%s) is booked for %d on %v",
+		table.Name, table.Location, reservation.PartySize, reservation.StartsAt.Format(time.RFC1123))
+
+	err = transporter.MailSender(ctx, payload.Email, []byte(message))
+	if err != nil {
+		return fmt.Errorf("error occured while sending a reservation reminder mail to %s at %v err %w", payload.Email, time.Now(), err)
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+func (processor *RedisSrvTaskProcessor) ProcessTaskGenerateInvoicePDF(ctx context.Context, task *asynq.Task) error {
+	var payload types.PayloadInvoice
+	err := json.Unmarshal(task.Payload(), &payload)
+	if err != nil {
+		return fmt.Errorf("unmarshalling error %w", err)
+	}
+
+	id, err := primitive.ObjectIDFromHex(payload.InvoiceId)
+	if err != nil {
+		return fmt.Errorf("invalid invoice id %w", err)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	var invoice store.Invoice
+	invoices := processor.store.Collection(ctx, "coffeeshop", "invoices")
+	err = invoices.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&invoice)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving invoice %w", err)
+	}
+
+	objectKey := internal.InvoiceObjectKey(invoice)
+	err = processor.coffeeShopS3Bucket.UploadObject(ctx, objectKey, processor.envs.S3_BUCKET_NAME, "application/pdf", internal.InvoicePDF(invoice))
+	if err != nil {
+		return err
+	}
+
+	_, err = invoices.UpdateByID(ctx, invoice.Id, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "object_key", Value: objectKey},
+		{Key: "updated_at", Value: time.Now()},
+	}}})
+	if err != nil {
+		return fmt.Errorf("error occured while linking invoice %s to its pdf %w", invoice.Number, err)
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+// ProcessTaskPaymentEvent applies a webhook event stored by the payments
+// webhook handler. The signature was checked before the event was stored.
+func (processor *RedisSrvTaskProcessor) ProcessTaskPaymentEvent(ctx context.Context, task *asynq.Task) error {
+	var payload types.PayloadPaymentEvent
+	err := json.Unmarshal(task.Payload(), &payload)
+	if err != nil {
+		return fmt.Errorf("unmarshalling error %w", err)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	var record store.PaymentEvent
+	events := processor.store.Collection(ctx, "coffeeshop", "payment_events")
+	err = events.FindOne(ctx, bson.D{{Key: "_id", Value: payload.EventId}}).Decode(&record)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving payment event %w", err)
+	}
+	if !record.ProcessedAt.IsZero() {
+		return nil
+	}
+
+	var event payments.Event
+	err = json.Unmarshal(record.Payload, &event)
+	if err != nil {
+		return fmt.Errorf("invalid payment event %s %w", record.Id, err)
+	}
+
+	var order store.Order
+	switch event.Type {
+	case payments.EVENT_PAYMENT_SUCCEEDED:
+		_, order, err = internal.ApplyPaymentOutcome(ctx, processor.store, event.Intent, types.PAID, "")
+	case payments.EVENT_PAYMENT_FAILED:
+		_, order, err = internal.ApplyPaymentOutcome(ctx, processor.store, event.Intent, types.REJECTED, event.FailureReason)
+	default:
+		log.Info().Str("event", record.Id).Str("type", record.Type).Msg("ignoring payment event")
+	}
+	if err != nil {
+		return fmt.Errorf("error occured while applying payment event %s %w", record.Id, err)
+	}
+
+	if !order.Id.IsZero() {
+		// the outcome is stored, a missed announcement only delays streams
+		if err := internal.AnnounceOrder(ctx, processor.broker, order); err != nil {
+			log.Error().Err(err).Str("order", order.Id.Hex()).Msg("announcing order change failed")
+		}
+	}
+
+	_, err = events.UpdateByID(ctx, record.Id, bson.D{{Key: "$set", Value: bson.D{{Key: "processed_at", Value: time.Now()}}}})
+	if err != nil {
+		return fmt.Errorf("error occured while marking payment event %s processed %w", record.Id, err)
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+func (processor *RedisSrvTaskProcessor) ProcessTaskSendRefundReceiptMail(ctx context.Context, task *asynq.Task) error {
+	var payload types.PayloadRefund
+	err := json.Unmarshal(task.Payload(), &payload)
+	if err != nil {
+		return fmt.Errorf("unmarshalling error %w", err)
+	}
+
+	id, err := primitive.ObjectIDFromHex(payload.RefundId)
+	if err != nil {
+		return fmt.Errorf("invalid refund id %w", err)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	var refund store.Refund
+	err = processor.store.Collection(ctx, "coffeeshop", "refunds").FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&refund)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving refund %w", err)
+	}
+
+	var customer store.User
+	err = processor.store.Collection(ctx, "coffeeshop", "users").FindOne(ctx, bson.D{{Key: "_id", Value: refund.Customer}}).Decode(&customer)
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving customer of refund %s %w", refund.Id.Hex(), err)
+	}
+
+	message := fmt.Sprintf("refund %s of %s for order %s was issued at %v, reason: %s", refund.Id.Hex(), refund.Amount, refund.Order.Hex(), refund.UpdatedAt.Format(time.RFC1123), refund.Reason)
+	for _, item := range refund.Items {
+		message += fmt.Sprintf("\n%d x %s: %s", item.Quantity, item.Product.Hex(), item.Amount)
+	}
+
+	transporter := mail.NewSMTPTransporter(&processor.envs)
+	err = transporter.MailSender(ctx, customer.Email, []byte(message))
+	if err != nil {
+		return fmt.Errorf("error occured while sending a refund receipt to %s at %v err %w", customer.Email, time.Now(), err)
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+// ProcessTaskExpireLoyaltyPoints runs on the scheduler and expires the points
+// of every account that still holds some past their lifetime.
+func (processor *RedisSrvTaskProcessor) ProcessTaskExpireLoyaltyPoints(ctx context.Context, task *asynq.Task) error {
+	rules, err := internal.ParseLoyaltyRules(&processor.envs)
+	if err != nil {
+		return fmt.Errorf("%w %w", err, asynq.SkipRetry)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	cutoff := time.Now().Add(-rules.ExpireAfter)
+	accounts := processor.store.Collection(ctx, "coffeeshop", "loyalty_accounts")
+	cur, err := accounts.Find(ctx, bson.D{{Key: "balance", Value: bson.D{{Key: "$gt", Value: 0}}}})
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving loyalty accounts %w", err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var account store.LoyaltyAccount
+		if err := cur.Decode(&account); err != nil {
+			return err
+		}
+
+		expired, err := internal.ExpireLoyaltyPoints(ctx, processor.store, account.Id, cutoff)
+		if err != nil {
+			return fmt.Errorf("error occured while expiring loyalty points of %s %w", account.Id.Hex(), err)
+		}
+		if expired > 0 {
+			log.Info().Str("user", account.Id.Hex()).Int64("points", expired).Msg("expired loyalty points")
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+func getReservationFromTask(ctx context.Context, processor *RedisSrvTaskProcessor, task *asynq.Task) (store.Reservation, store.CoffeeDateTable, types.PayloadReservationNotification, error) {
+	var payload types.PayloadReservationNotification
+	err := json.Unmarshal(task.Payload(), &payload)
+	if err != nil {
+		return store.Reservation{}, store.CoffeeDateTable{}, payload, fmt.Errorf("unmarshalling error %w", err)
+	}
+
+	id, err := primitive.ObjectIDFromHex(payload.ReservationId)
+	if err != nil {
+		return store.Reservation{}, store.CoffeeDateTable{}, payload, fmt.Errorf("invalid reservation id %w", err)
+	}
+
+	var reservation store.Reservation
+	reservations := processor.store.Collection(ctx, "coffeeshop", "reservations")
+	err = reservations.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&reservation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Reservation{}, store.CoffeeDateTable{}, payload, fmt.Errorf("document not found %w", err)
+		}
+		return store.Reservation{}, store.CoffeeDateTable{}, payload, fmt.Errorf("internal server error %w", err)
+	}
+
+	var table store.CoffeeDateTable
+	tables := processor.store.Collection(ctx, "coffeeshop", "tables")
+	err = tables.FindOne(ctx, bson.D{{Key: "_id", Value: reservation.Table}}).Decode(&table)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return store.Reservation{}, store.CoffeeDateTable{}, payload, fmt.Errorf("internal server error %w", err)
+	}
+
+	return reservation, table, payload, nil
+}
+
+func getOrderFromTask(ctx context.Context, processor *RedisSrvTaskProcessor, task *asynq.Task) (store.Order, types.PayloadOrderNotification, error) {
+	var payload types.PayloadOrderNotification
+	err := json.Unmarshal(task.Payload(), &payload)
+	if err != nil {
+		return store.Order{}, payload, fmt.Errorf("unmarshalling error %w", err)
+	}
+
+	id, err := primitive.ObjectIDFromHex(payload.OrderId)
+	if err != nil {
+		return store.Order{}, payload, fmt.Errorf("invalid order id %w", err)
+	}
+
+	var order store.Order
+	orders := processor.store.Collection(ctx, "coffeeshop", "orders")
+	err = orders.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return store.Order{}, payload, fmt.Errorf("document not found %w", err)
+		}
+		return store.Order{}, payload, fmt.Errorf("internal server error %w", err)
+	}
+
+	return order, payload, nil
+}
+
 func getUserByEmail(ctx context.Context, processor *RedisSrvTaskProcessor, task *asynq.Task) (store.User, error) {
 	var Payload types.PayloadSendMail
 	err := json.Unmarshal(task.Payload(), &Payload)
@@ -179,6 +503,74 @@ func getUserByEmail(ctx context.Context, processor *RedisSrvTaskProcessor, task
 	return user, nil
 }
 
+// ProcessTaskQueueScheduledOrder runs shortly before the pickup time of an
+// order placed ahead and sends it to the kitchen.
+func (processor *RedisSrvTaskProcessor) ProcessTaskQueueScheduledOrder(ctx context.Context, task *asynq.Task) error {
+	var payload types.PayloadScheduledOrder
+	err := json.Unmarshal(task.Payload(), &payload)
+	if err != nil {
+		return fmt.Errorf("unmarshalling error %w", err)
+	}
+
+	id, err := primitive.ObjectIDFromHex(payload.OrderId)
+	if err != nil {
+		return fmt.Errorf("invalid order id %w %w", err, asynq.SkipRetry)
+	}
+
+	fmt.Printf("BEGIN @%+v\n", time.Now())
+	fmt.Printf("start processing task %+s\n", task.Type())
+
+	orders := processor.store.Collection(ctx, "coffeeshop", "orders")
+	queued, err := processor.queueOrder(ctx, orders, id)
+	if err != nil {
+		return err
+	}
+	if !queued {
+		// cancelled or rejected before it was due in the kitchen
+		log.Info().Str("order", payload.OrderId).Msg("scheduled order no longer needs queueing")
+	}
+
+	fmt.Printf("END @%+v\n", time.Now())
+	return nil
+}
+
+// ProcessTaskQueueDueOrders runs every minute and sends the orders placed
+// ahead that are due to the kitchen when their own task was never scheduled.
+func (processor *RedisSrvTaskProcessor) ProcessTaskQueueDueOrders(ctx context.Context, task *asynq.Task) error {
+	orders := processor.store.Collection(ctx, "coffeeshop", "orders")
+	ids, err := internal.DueOrders(ctx, orders, time.Now())
+	if err != nil {
+		return fmt.Errorf("error occured while retreiving due orders %w", err)
+	}
+
+	for _, id := range ids {
+		queued, err := processor.queueOrder(ctx, orders, id)
+		if err != nil {
+			return err
+		}
+		if queued {
+			log.Warn().Str("order", id.Hex()).Msg("due order queued without its scheduled task")
+		}
+	}
+	return nil
+}
+
+// queueOrder sends an order to the kitchen and puts its ticket on the
+// boards. It reports false for orders already sent or no longer open.
+func (processor *RedisSrvTaskProcessor) queueOrder(ctx context.Context, orders *mongo.Collection, id primitive.ObjectID) (bool, error) {
+	queued, err := internal.QueueOrder(ctx, orders, id, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("error occured while queueing order %s %w", id.Hex(), err)
+	}
+	if queued {
+		if err := internal.AnnounceKitchenTicket(ctx, processor.broker, id); err != nil {
+			// the ticket still shows up once the board reloads the queue
+			log.Error().Err(err).Str("order", id.Hex()).Msg("announcing kitchen ticket failed")
+		}
+	}
+	return queued, nil
+}
+
 func (processor *RedisSrvTaskProcessor) Start() error {
 	mux := asynq.NewServeMux()
 	mux.HandleFunc(SEND_VERIFICATION_EMAIL, processor.ProcessTaskSendVerificationMail)
@@ -186,6 +578,15 @@ func (processor *RedisSrvTaskProcessor) Start() error {
 	mux.HandleFunc(UPLOAD_S3_OBJECT, processor.ProcessTaskUploadS3Object)
 	mux.HandleFunc(UPLOAD_MULTIPLE_S3_OBJECTS, processor.ProcessTaskMultipleUploadS3Object)
 	mux.HandleFunc(DELETE_S3_OBJECT, processor.ProcessTaskDeleteS3Object)
+	mux.HandleFunc(SEND_ORDER_CANCELLED_EMAIL, processor.ProcessTaskSendOrderCancelledMail)
+	mux.HandleFunc(SEND_RESERVATION_CONFIRMATION_EMAIL, processor.ProcessTaskSendReservationConfirmationMail)
+	mux.HandleFunc(SEND_RESERVATION_REMINDER_EMAIL, processor.ProcessTaskSendReservationReminderMail)
+	mux.HandleFunc(GENERATE_INVOICE_PDF, processor.ProcessTaskGenerateInvoicePDF)
+	mux.HandleFunc(PROCESS_PAYMENT_EVENT, processor.ProcessTaskPaymentEvent)
+	mux.HandleFunc(SEND_REFUND_RECEIPT_EMAIL, processor.ProcessTaskSendRefundReceiptMail)
+	mux.HandleFunc(EXPIRE_LOYALTY_POINTS, processor.ProcessTaskExpireLoyaltyPoints)
+	mux.HandleFunc(QUEUE_SCHEDULED_ORDER, processor.ProcessTaskQueueScheduledOrder)
+	mux.HandleFunc(QUEUE_DUE_ORDERS, processor.ProcessTaskQueueDueOrders)
 
 	return processor.server.Start(mux)
 }
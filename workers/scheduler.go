@@ -0,0 +1,68 @@
+package workers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+)
+
+// loyaltyExpirySchedule is when unspent loyalty points past their lifetime
+// are expired, every night at 03:00.
+const loyaltyExpirySchedule = "0 3 * * *"
+
+// loyaltyExpiryUniqueFor keeps the nightly expiry unique while it is queued
+// or running. Every API instance runs a scheduler, so all of them try to
+// enqueue it at 03:00 and only the first one gets through; one enqueued after
+// that run finished finds no points left to expire.
+const loyaltyExpiryUniqueFor = 12 * time.Hour
+
+// dueOrdersSchedule is how often orders placed ahead whose task was never
+// scheduled are looked for. A run that has not finished keeps the next one
+// out for dueOrdersUniqueFor.
+const (
+	dueOrdersSchedule  = "* * * * *"
+	dueOrdersUniqueFor = time.Minute
+)
+
+type TaskScheduler interface {
+	Start() error
+}
+
+// RedisTaskScheduler enqueues periodic tasks for the task processor.
+type RedisTaskScheduler struct {
+	scheduler *asynq.Scheduler
+}
+
+func NewTaskScheduler(opts asynq.RedisClientOpt) TaskScheduler {
+	scheduler := asynq.NewScheduler(opts, &asynq.SchedulerOpts{
+		EnqueueErrorHandler: func(task *asynq.Task, opts []asynq.Option, err error) {
+			if errors.Is(err, asynq.ErrDuplicateTask) {
+				log.Debug().Str("type", task.Type()).Msg("already scheduled by another instance")
+				return
+			}
+			log.Error().Err(err).Str("type", task.Type()).Msg("schedule failed")
+		},
+	})
+
+	return &RedisTaskScheduler{
+		scheduler: scheduler,
+	}
+}
+
+func (sch *RedisTaskScheduler) Start() error {
+	_, err := sch.scheduler.Register(loyaltyExpirySchedule, asynq.NewTask(EXPIRE_LOYALTY_POINTS, nil),
+		asynq.Queue(DefaultQueue), asynq.MaxRetry(3), asynq.Unique(loyaltyExpiryUniqueFor))
+	if err != nil {
+		return err
+	}
+
+	_, err = sch.scheduler.Register(dueOrdersSchedule, asynq.NewTask(QUEUE_DUE_ORDERS, nil),
+		asynq.Queue(CriticalQueue), asynq.MaxRetry(0), asynq.Unique(dueOrdersUniqueFor))
+	if err != nil {
+		return err
+	}
+
+	return sch.scheduler.Run()
+}